@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
@@ -11,8 +12,12 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
+// shutdownTimeout limits waiting for in-flight requests on shutdown
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	var wg sync.WaitGroup
 
@@ -20,6 +25,42 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
+	// read config
+	if err := gophermart.Configure(); err != nil {
+		log.Fatal(err)
+	}
+
+	// gophermart [flags] migrate up|down [steps]|status
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		if err := gophermart.Migrate(ctx, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// export trace spans
+	shutdownTracing, err := gophermart.Tracing(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// metrics and probes on the internal address, before the store is ready
+	var adminSrv *http.Server
+	if addr := gophermart.AdminAddress(); addr != "" {
+		adminSrv = &http.Server{
+			Addr:    addr,
+			Handler: gophermart.AdminRoutes(),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Log.Infof("Starting admin server on %s", adminSrv.Addr)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Log.Fatal(err)
+			}
+		}()
+	}
+
 	// init app and start server
 	serverAddress, err := gophermart.Setup(ctx)
 	if err != nil {
@@ -42,21 +83,56 @@ func main() {
 	wg.Add(1)
 	go gophermart.CheckAccrual(ctx, &wg)
 
+	// expire stale holds
+	wg.Add(1)
+	go gophermart.ExpireHolds(ctx, &wg)
+
+	// expire points past their lifetime
+	wg.Add(1)
+	go gophermart.ExpirePoints(ctx, &wg)
+
+	// recalculate loyalty tiers as the window moves
+	wg.Add(1)
+	go gophermart.RecalcTiers(ctx, &wg)
+
+	// drop idle rate limit buckets
+	wg.Add(1)
+	go gophermart.SweepRateLimits(ctx, &wg)
+
+	// drop stale failed login attempts
+	wg.Add(1)
+	go gophermart.SweepLoginAttempts(ctx, &wg)
+
 	// gracefully shutdown by signal
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		<-c
-		cancel()
-		// shutdown server
-		if err := srv.Shutdown(ctx); err != nil {
-			logger.Log.Fatalf("Server shutdown failed: %v", err)
+		// readiness fails first so traffic drains before the server stops
+		gophermart.Drain()
+		// in-flight requests get their own deadline: ctx is still needed by them
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownCancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Log.Errorf("Server shutdown failed: %v", err)
 		}
-		if err := api.Repo.Close(); err != nil {
-			logger.Log.Fatalf("Channel Jobs shutdown failed: %v", err)
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(shutdownCtx); err != nil {
+				logger.Log.Errorf("Admin server shutdown failed: %v", err)
+			}
 		}
+		// stop background workers only when no request can enqueue a job
+		cancel()
 	}()
 
 	wg.Wait()
+	// workers are stopped, nothing uses the store anymore
+	if err := api.Repo.Close(); err != nil {
+		logger.Log.Errorf("Store shutdown failed: %v", err)
+	}
+	// flush spans of the last requests and accrual polls
+	if err := shutdownTracing(context.Background()); err != nil {
+		logger.Log.Errorf("Tracing shutdown failed: %v", err)
+	}
 	logger.Log.Infoln("Successful shutdown")
 }
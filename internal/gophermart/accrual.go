@@ -3,15 +3,29 @@ package gophermart
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/middleware"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// circuitOpenBackoff - пауза перед повтором, если пробный запрос к адресу еще выполняется
+const circuitOpenBackoff = 100 * time.Millisecond
+
 func CheckAccrual(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -21,71 +35,205 @@ func CheckAccrual(ctx context.Context, wg *sync.WaitGroup) {
 
 	logger.Log.Infoln("Starting accrual checker")
 	for {
-		job, open := <-api.Repo.Jobs
-		if !open {
+		var job models.AccrualRequest
+		select {
+		case <-ctx.Done():
 			return
+		case job = <-api.Repo.Jobs:
 		}
 
 		time.Sleep(time.Duration(app.AccrualPollInterval) * time.Second)
 
-		logger.Log.Infoln("Checking accrual:", "job.UserID", job.UserID, "job.Number", job.Number)
-
-		// Ходим в accrual service
-		accrualResult, err := GetAccrual(ctx, job.Number)
-		if err != nil {
-			logger.Log.Errorln(err)
-			continue
+		// опрос адреса приостановлен: воркер ждет конца паузы с тем же заказом,
+		// а не гоняет заказы через очередь, которую сам же и читает
+		for retryAt := pollAccrual(ctx, job); !retryAt.IsZero(); retryAt = pollAccrual(ctx, job) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(retryAt)):
+			}
 		}
+	}
+}
+
+// requeue возвращает заказ в очередь. Воркер - ее единственный читатель, поэтому
+// блокирующая запись в заполненную очередь остановила бы опрос навсегда:
+// в этом случае заказ дожидается места в отдельной горутине
+func requeue(ctx context.Context, job models.AccrualRequest) {
+	select {
+	case api.Repo.Jobs <- job:
+	default:
+		go func() {
+			select {
+			case api.Repo.Jobs <- job:
+			case <-ctx.Done():
+			}
+		}()
+	}
+}
+
+// pollAccrual опрашивает accrual по заказу job и зачисляет баллы. Каждый опрос - отдельная
+// трасса, связанная с запросом, который поставил заказ в очередь. Если опрос адреса
+// приостановлен автоматом защиты, возвращает время, когда заказ можно опросить снова
+func pollAccrual(ctx context.Context, job models.AccrualRequest) (retryAt time.Time) {
+	var opts []trace.SpanStartOption
+	if job.Trace != nil {
+		opts = append(opts, trace.WithLinks(tracing.Link(job.Trace)))
+	}
+	opts = append(opts, trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("order.number", job.Number),
+		attribute.String("tenant.id", job.TenantID),
+	))
+	ctx, span := tracing.Tracer().Start(ctx, "accrual.poll", opts...)
+	defer span.End()
+
+	// строки опроса связываются с запросом, который поставил заказ в очередь
+	fields := []any{"request_id", job.RequestID, "user_id", job.UserID, "order", job.Number}
+	if span.SpanContext().IsValid() {
+		fields = append(fields, "trace_id", span.SpanContext().TraceID().String())
+	}
+	ctx = logger.With(logger.WithRequestID(ctx, job.RequestID), fields...)
+
+	logger.FromContext(ctx).Infoln("Checking accrual:", "job.UserID", job.UserID, "job.Number", job.Number)
 
-		logger.Log.Infoln(
-			"There is a accrual:",
-			"accrual.Number", accrualResult.Number,
-			"accrual.Status", accrualResult.Status,
-			"accrual.Accrual", accrualResult.Accrual,
-		)
-
-		// update balance and order
-		order := models.Order{}
-		order.UserID = job.UserID
-		order.Number = accrualResult.Number
-		order.Accrual = models.Money(accrualResult.Accrual.Set())
-		order.Status = ConvertStatus(string(accrualResult.Status))
-		err = api.Repo.Store.UpdateBalanceAndOrder(ctx, order)
+	// Ходим в accrual service
+	tenant := api.TenantByID(job.TenantID)
+	if tenant == nil {
+		logger.FromContext(ctx).Errorln("unknown tenant:", "job.TenantID", job.TenantID, "job.Number", job.Number)
+		span.SetStatus(codes.Error, "unknown tenant")
+		return time.Time{}
+	}
+	accrualResult, err := GetAccrual(ctx, tenant, job.Number)
+	switch {
+	case errors.Is(err, ErrAccrualCircuitOpen):
+		// заказ не теряется: он будет опрошен после паузы
+		metrics.AccrualPolls.WithLabelValues("circuit_open").Inc()
+		span.SetAttributes(attribute.String("accrual.outcome", "circuit_open"))
+		return accrualCircuit(tenant.AccrualSystemAddress).RetryAt(time.Now())
+	case err != nil:
+		metrics.AccrualPolls.WithLabelValues("error").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		logger.FromContext(ctx).Errorln(err)
+		return time.Time{}
+	}
+	metrics.AccrualPolls.WithLabelValues(string(accrualResult.Status)).Inc()
+	span.SetAttributes(attribute.String("accrual.outcome", string(accrualResult.Status)))
+
+	logger.FromContext(ctx).Infoln(
+		"There is a accrual:",
+		"accrual.Number", accrualResult.Number,
+		"accrual.Status", accrualResult.Status,
+		"accrual.Accrual", accrualResult.Accrual,
+	)
+
+	// update balance and order
+	order := models.Order{}
+	order.UserID = job.UserID
+	order.TenantID = tenant.ID
+	order.Number = accrualResult.Number
+	order.Accrual = models.Money(accrualResult.Accrual.Set())
+	order.Status = ConvertStatus(string(accrualResult.Status))
+	if order.Accrual > 0 {
+		// надбавка по уровню, присвоенному до этого начисления;
+		// ошибка уровня не должна задерживать само начисление
+		tier, err := api.Repo.RecalcTier(ctx, job.UserID)
 		if err != nil {
-			logger.Log.Errorln("failed UpdateBalanceAndOrder()=", err)
+			logger.FromContext(ctx).Errorln("failed RecalcTier()=", err)
 		}
-
-		// если статусы нефинальные, то возвращаем в канал
-		switch accrualResult.Status {
-		case models.AccrualStateRegistered, models.AccrualStateProcessing:
-			accrualRequest := models.AccrualRequest{
-				Number: accrualResult.Number,
-				UserID: job.UserID,
+		order.Bonus, order.Tier = api.TierBonus(tier, order.Accrual)
+	}
+	credited, err := api.Repo.Store.UpdateBalanceAndOrder(ctx, order)
+	if err != nil {
+		logger.FromContext(ctx).Errorln("failed UpdateBalanceAndOrder()=", err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		// счетчик не уменьшается: отрицательное начисление от системы расчета в него не попадает
+		if credited > 0 {
+			metrics.PointsCredited.WithLabelValues(tenant.ID).Add(float64(credited))
+		}
+		if order.Accrual > 0 {
+			if _, err := api.Repo.RecalcTier(ctx, job.UserID); err != nil {
+				logger.FromContext(ctx).Errorln("failed RecalcTier()=", err)
 			}
-			api.Repo.Jobs <- accrualRequest
 		}
+		if order.Status == models.OrderStateProcessed {
+			if err := api.Repo.RewardReferral(ctx, tenant.ID, job.UserID, order.Number); err != nil {
+				logger.FromContext(ctx).Errorln("failed RewardReferral()=", err)
+			}
+		}
+	}
+
+	// если статусы нефинальные, то возвращаем в канал
+	switch accrualResult.Status {
+	case models.AccrualStateRegistered, models.AccrualStateProcessing:
+		accrualRequest := models.AccrualRequest{
+			Number:    accrualResult.Number,
+			UserID:    job.UserID,
+			TenantID:  tenant.ID,
+			RequestID: job.RequestID,
+			Trace:     job.Trace,
+		}
+		requeue(ctx, accrualRequest)
 	}
+
+	return time.Time{}
 }
 
-func GetAccrual(ctx context.Context, order string) (*models.AccrualResponse, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/orders/%s", app.AccrualSystemAddress, order), nil)
+// GetAccrual запрашивает начисление по заказу в системе расчета программы лояльности tenant
+func GetAccrual(ctx context.Context, tenant *models.Tenant, order string) (_ *models.AccrualResponse, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "GetAccrual",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("order.number", order)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/orders/%s", tenant.AccrualSystemAddress, order), nil)
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttributes(semconv.HTTPRequestMethodGet, semconv.URLFull(req.URL.String()))
+	// система расчета может продолжить трассу по заголовку traceparent
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if id := logger.RequestID(ctx); id != "" {
+		req.Header.Set(middleware.RequestIDHeader, id)
+	}
+
+	breaker := accrualCircuit(tenant.AccrualSystemAddress)
+	if !breaker.Allow(time.Now()) {
+		return nil, ErrAccrualCircuitOpen
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
+		metrics.AccrualErrors.WithLabelValues("network").Inc()
+		breaker.Failure(time.Now())
 		return nil, err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+
+	// система расчета недоступна или просит снизить нагрузку; остальные ответы,
+	// в том числе 204 для незарегистрированного заказа, - признак того, что она работает
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		breaker.Failure(time.Now())
+	} else {
+		breaker.Success()
+	}
 
 	if resp.StatusCode != http.StatusOK {
+		// 429 - не сбой accrual, а требование опрашивать реже: считается отдельно
+		if resp.StatusCode == http.StatusTooManyRequests {
+			metrics.AccrualRateLimited.Inc()
+		} else {
+			metrics.AccrualErrors.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+		}
 		return nil, fmt.Errorf("response code expected %d, but got %d", http.StatusOK, resp.StatusCode)
 	}
 
 	var accrualResponse models.AccrualResponse
 	if err := json.NewDecoder(resp.Body).Decode(&accrualResponse); err != nil {
+		metrics.AccrualErrors.WithLabelValues("decode").Inc()
 		return nil, err
 	}
 
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"time"
+)
+
+// accountDeletion - удаление аккаунта подтверждается паролем
+type accountDeletion struct {
+	Password string `json:"password"`
+}
+
+func (m *Repository) ExportUser(w http.ResponseWriter, r *http.Request) {
+	//- `200` — архив персональных данных пользователя;
+	//- `401` — пользователь не авторизован;
+	//- `500` — внутренняя ошибка сервера.
+	authUserID := m.GetUserID(r)
+	export, err := m.exportUser(r, authUserID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed exportUser()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.audit(r, models.AuditEvent{
+		TenantID: GetTenant(r).ID,
+		Event:    models.AuditEventDataExported,
+		UserID:   authUserID,
+		Login:    export.Profile.Login,
+	})
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="gophermart-export-%d.json"`, authUserID))
+	if err := m.WriteResponseJSON(w, export, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	//- `204` — аккаунт удален: логин обезличен, токены отозваны, финансовые записи сохранены;
+	//- `400` — неверный формат запроса;
+	//- `401` — пользователь не авторизован;
+	//- `403` — неверный пароль, нет кода в X-OTP-Code (если включен второй фактор) или код неверный;
+	//- `429` — слишком много неудачных попыток, повторить через Retry-After секунд;
+	//- `500` — внутренняя ошибка сервера.
+	var req accountDeletion
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := m.authorizeByPassword(w, r)
+	if !ok || !m.checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	// со вторым фактором одного пароля недостаточно
+	totp, err := m.Store.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if totp != nil && totp.Confirmed && !m.checkSecondFactor(w, r, totp, r.Header.Get(OTPHeader), http.StatusForbidden) {
+		return
+	}
+
+	if err := m.Store.DeleteUser(r.Context(), user.ID); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed DeleteUser()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// логин в журнал уже не пишется: он обезличен вместе с прежними записями
+	m.audit(r, models.AuditEvent{
+		TenantID: user.TenantID,
+		Event:    models.AuditEventAccountDeleted,
+		UserID:   user.ID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportUser собирает архив данных пользователя; пустые списки выгружаются как [],
+// чтобы у архива был один формат
+func (m *Repository) exportUser(r *http.Request, userID int64) (*models.UserExport, error) {
+	user, err := m.Store.GetUser(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	inviteCode, err := m.Store.GetInviteCode(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	totp, err := m.Store.GetTOTP(r.Context(), userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	balance, err := m.Store.GetBalance(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := m.Store.GetOrders(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := m.Store.GetWithdrawals(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := m.Store.GetLedger(r.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	export := models.UserExport{
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Profile: models.UserProfile{
+			Login:      user.Login,
+			Email:      user.Email,
+			InviteCode: inviteCode,
+			TwoFactor:  totp != nil && totp.Confirmed,
+			CreatedAt:  user.CreatedAt,
+		},
+		Balance:        *balance,
+		Orders:         orders,
+		Withdrawals:    withdrawals,
+		BalanceHistory: ledger,
+	}
+	if export.Orders == nil {
+		export.Orders = []models.Order{}
+	}
+	if export.Withdrawals == nil {
+		export.Withdrawals = []models.Withdrawal{}
+	}
+	if export.BalanceHistory == nil {
+		export.BalanceHistory = []models.LedgerEntry{}
+	}
+
+	return &export, nil
+}
@@ -2,9 +2,25 @@ package api
 
 import (
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// expiringPoints - баллы, которые сгорят в ближайшие Days дней
+type expiringPoints struct {
+	Days   int          `json:"days"`
+	Amount models.Money `json:"amount"`
+	Lots   []models.Lot `json:"lots"`
+}
+
+// expiringBalance - сводка по пользователю для предупреждения о сгорании
+type expiringBalance struct {
+	UserID   int64        `json:"user_id"`
+	Expiring models.Money `json:"expiring"`
+}
+
 func (m *Repository) GetBalance(w http.ResponseWriter, r *http.Request) {
 	//- `200` — успешная обработка запроса.
 	//- `401` — пользователь не авторизован.
@@ -12,13 +28,135 @@ func (m *Repository) GetBalance(w http.ResponseWriter, r *http.Request) {
 	authUserID := m.GetUserID(r)
 	balance, err := m.Store.GetBalance(r.Context(), authUserID)
 	if err != nil {
-		logger.Log.Errorln("failed GetBalance()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed GetBalance()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	lots, err := m.getExpiringLots(r, authUserID, app.PointsExpiryNoticeDays)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetExpiringLots()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, lot := range lots {
+		balance.Expiring += lot.Amount
+	}
+
 	if err := m.WriteResponseJSON(w, balance, http.StatusOK); err != nil {
-		logger.Log.Errorln("failed WriteResponseJSON()=", err)
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) GetExpiringPoints(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `400` — неверное число дней;
+	//- `401` — пользователь не авторизован;
+	//- `500` — внутренняя ошибка сервера.
+	days, ok := noticeDays(w, r)
+	if !ok {
+		return
+	}
+
+	lots, err := m.getExpiringLots(r, m.GetUserID(r), days)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetExpiringLots()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := expiringPoints{Days: days, Lots: lots}
+	for _, lot := range lots {
+		res.Amount += lot.Amount
+	}
+	if res.Lots == nil {
+		res.Lots = []models.Lot{}
+	}
+
+	if err := m.WriteResponseJSON(w, res, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) AdminGetExpiringPoints(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `204` — нет баллов, сгорающих в этот срок;
+	//- `400` — неверное число дней;
+	//- `401` — неверный токен администратора;
+	//- `500` — внутренняя ошибка сервера.
+	days, ok := noticeDays(w, r)
+	if !ok {
+		return
+	}
+	if app.PointsTTLMonths <= 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	balances, err := m.Store.GetExpiringBalances(r.Context(), GetTenant(r).ID, expiryCutoff(days))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetExpiringBalances()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(balances) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	res := make([]expiringBalance, 0, len(balances))
+	for _, b := range balances {
+		res = append(res, expiringBalance{UserID: b.UserID, Expiring: b.Expiring})
+	}
+
+	if err := m.WriteResponseJSON(w, res, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
+
+// getExpiringLots возвращает партии пользователя, которые сгорят в ближайшие days дней,
+// с заполненной датой сгорания. Если баллы не сгорают, партий нет
+func (m *Repository) getExpiringLots(r *http.Request, userID int64, days int) ([]models.Lot, error) {
+	if app.PointsTTLMonths <= 0 {
+		return nil, nil
+	}
+
+	lots, err := m.Store.GetExpiringLots(r.Context(), userID, expiryCutoff(days))
+	if err != nil {
+		return nil, err
+	}
+	for i := range lots {
+		earnedAt, err := time.Parse(time.RFC3339, lots[i].EarnedAt)
+		if err != nil {
+			return nil, err
+		}
+		lots[i].ExpiresAt = earnedAt.AddDate(0, app.PointsTTLMonths, 0).UTC().Format(time.RFC3339)
+	}
+
+	return lots, nil
+}
+
+// expiryCutoff возвращает границу начисления: партии, начисленные не позже нее,
+// сгорят в ближайшие days дней
+func expiryCutoff(days int) time.Time {
+	return time.Now().AddDate(0, -app.PointsTTLMonths, days)
+}
+
+// noticeDays читает срок предупреждения из параметра days,
+// по умолчанию app.PointsExpiryNoticeDays
+func noticeDays(w http.ResponseWriter, r *http.Request) (int, bool) {
+	raw := r.URL.Query().Get("days")
+	if raw == "" {
+		return app.PointsExpiryNoticeDays, true
+	}
+	days, err := strconv.Atoi(raw)
+	if err != nil || days < 0 {
+		http.Error(w, "days must be a non-negative integer", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return days, true
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+func (m *Repository) CreateCampaign(w http.ResponseWriter, r *http.Request) {
+	//- `201` — акция создана;
+	//- `400` — неверный формат запроса или правил акции;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	campaign := models.Campaign{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&campaign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := prepareCampaign(&campaign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	campaign.TenantID = GetTenant(r).ID
+	created, err := m.Store.CreateCampaign(r.Context(), campaign)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed CreateCampaign()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(r.Context()).Infoln("Campaign created:", "campaign.ID", created.ID, "campaign.Name", created.Name)
+
+	if err := m.WriteResponseJSON(w, created, http.StatusCreated); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) GetCampaigns(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `204` — нет акций;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	campaigns, err := m.Store.GetCampaigns(r.Context(), GetTenant(r).ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetCampaigns()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(campaigns) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, campaigns, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) GetCampaign(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `401` — нет доступа;
+	//- `404` — акция не найдена;
+	//- `500` — внутренняя ошибка сервера.
+	campaign, ok := m.getCampaign(w, r)
+	if !ok {
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, campaign, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) UpdateCampaign(w http.ResponseWriter, r *http.Request) {
+	//- `200` — акция изменена;
+	//- `400` — неверный формат запроса или правил акции;
+	//- `401` — нет доступа;
+	//- `404` — акция не найдена;
+	//- `500` — внутренняя ошибка сервера.
+	campaign, ok := m.getCampaign(w, r)
+	if !ok {
+		return
+	}
+	// поля, которых нет в запросе, сохраняют текущие значения
+	id := campaign.ID
+	if err := json.NewDecoder(r.Body).Decode(campaign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	campaign.ID = id
+	campaign.TenantID = GetTenant(r).ID
+	if err := prepareCampaign(campaign); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := m.Store.UpdateCampaign(r.Context(), *campaign)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed UpdateCampaign()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(r.Context()).Infoln("Campaign updated:", "campaign.ID", updated.ID, "campaign.Active", updated.Active)
+
+	if err := m.WriteResponseJSON(w, updated, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) DeleteCampaign(w http.ResponseWriter, r *http.Request) {
+	//- `204` — акция удалена;
+	//- `401` — нет доступа;
+	//- `404` — акция не найдена;
+	//- `500` — внутренняя ошибка сервера.
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = m.Store.DeleteCampaign(r.Context(), GetTenant(r).ID, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed DeleteCampaign()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(r.Context()).Infoln("Campaign deleted:", "campaign.ID", id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getCampaign читает акцию по {id} из пути и пишет ответ, если ее нет
+func (m *Repository) getCampaign(w http.ResponseWriter, r *http.Request) (*models.Campaign, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	campaign, err := m.Store.GetCampaign(r.Context(), GetTenant(r).ID, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetCampaign()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return campaign, true
+}
+
+// prepareCampaign проверяет правила акции и приводит даты к UTC, а суммы к копейкам
+func prepareCampaign(c *models.Campaign) error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	startsAt, err := time.Parse(time.RFC3339, c.StartsAt)
+	if err != nil {
+		return errors.New("starts_at must be RFC3339")
+	}
+	endsAt, err := time.Parse(time.RFC3339, c.EndsAt)
+	if err != nil {
+		return errors.New("ends_at must be RFC3339")
+	}
+	if !endsAt.After(startsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if c.Multiplier != 0 && c.Multiplier < 1 {
+		return errors.New("multiplier must be >= 1")
+	}
+	if c.MinAccrual < 0 || c.Bonus < 0 || c.Budget < 0 {
+		return errors.New("amounts must not be negative")
+	}
+	if c.Multiplier <= 1 && c.Bonus == 0 {
+		return errors.New("campaign must have multiplier > 1 or bonus")
+	}
+	for _, tier := range c.Segment {
+		if tierByName(tier) == nil {
+			return errors.New("unknown segment tier " + tier)
+		}
+	}
+
+	c.StartsAt = startsAt.UTC().Format(time.RFC3339)
+	c.EndsAt = endsAt.UTC().Format(time.RFC3339)
+	c.MinAccrual = models.Money(c.MinAccrual.Set())
+	c.Bonus = models.Money(c.Bonus.Set())
+	c.Budget = models.Money(c.Budget.Set())
+
+	return nil
+}
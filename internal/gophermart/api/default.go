@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/notifier"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
 	"net/http"
 )
@@ -15,13 +16,23 @@ var app *config.AppConfig
 
 var ErrDuplicate = errors.New("duplicate key value")
 var ErrNotEnoughMoney = errors.New("not enough money")
+var ErrIdempotencyMismatch = errors.New("idempotency key or order reused with different payload")
+var ErrNotFound = errors.New("not found")
+var ErrReversalExceeded = errors.New("reversal exceeds withdrawn sum")
+var ErrHoldClosed = errors.New("hold is not active")
+var ErrSelfTransfer = errors.New("transfer to self")
+var ErrTransferLimit = errors.New("daily transfer limit exceeded")
+var ErrVoucherRedeemed = errors.New("voucher already redeemed")
+var ErrVoucherExpired = errors.New("voucher expired")
+var ErrInviteCodeTaken = errors.New("invite code already taken")
 
 const bearerSchema = "Bearer "
 
 // Repository описываем структуру репозитория для хендлеров
 type Repository struct {
-	Store store.Repositories
-	Jobs  chan models.AccrualRequest
+	Store    store.Repositories
+	Jobs     chan models.AccrualRequest
+	Notifier notifier.Notifier
 }
 
 // NewRepo создаем новый репозиторий
@@ -51,7 +62,7 @@ func (m *Repository) WriteResponseJSON(w http.ResponseWriter, data interface{},
 func (m *Repository) GetUserID(r *http.Request) int64 {
 	authorization := r.Header.Get("Authorization")
 	token := authorization[len(bearerSchema):]
-	userID := GetUserID(token)
+	userID := GetUserID(token, GetTenant(r))
 
 	return userID
 }
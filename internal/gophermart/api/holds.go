@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"time"
+)
+
+func (m *Repository) AuthorizeHold(w http.ResponseWriter, r *http.Request) {
+	//- `200` — баллы зарезервированы (или резерв по этому заказу уже создан);
+	//- `400` — неверный формат запроса;
+	//- `401` — пользователь не авторизован;
+	//- `402` — на счету недостаточно средств;
+	//- `403` — для суммы выше порога нужен код второго фактора в X-OTP-Code;
+	//- `409` — по заказу уже есть резерв с другой суммой или списание;
+	//- `422` — неверный номер заказа;
+	//- `429` — слишком много неверных кодов второго фактора;
+	//- `500` — внутренняя ошибка сервера.
+	var hold models.Hold
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if hold.Sum <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	order := models.Order{Number: hold.Order}
+	if !order.IsValid() {
+		// `422` — неверный формат номера заказа;
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	hold.UserID = m.GetUserID(r)
+	// резерв - отложенное списание: порог второго фактора тот же
+	if !m.requireSecondFactor(w, r, hold.UserID, hold.Sum) {
+		return
+	}
+	hold.TenantID = GetTenant(r).ID
+	hold.Sum = models.Money(hold.Sum.Set())
+	hold.ExpiresAt = time.Now().Add(app.HoldTTL).UTC().Format(time.RFC3339)
+	created, err := m.Store.AuthorizeHold(r.Context(), hold)
+	switch {
+	case errors.Is(err, ErrNotEnoughMoney):
+		w.WriteHeader(http.StatusPaymentRequired)
+		return
+	case errors.Is(err, ErrIdempotencyMismatch):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed AuthorizeHold()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	m.writeHold(w, created)
+}
+
+func (m *Repository) CaptureHold(w http.ResponseWriter, r *http.Request) {
+	//- `200` — резерв списан (или уже был списан);
+	//- `401` — пользователь не авторизован;
+	//- `404` — резерв не найден;
+	//- `409` — резерв отменен или истек;
+	//- `500` — внутренняя ошибка сервера.
+	hold, captured, err := m.Store.CaptureHold(r.Context(), m.GetUserID(r), chi.URLParam(r, "order"))
+	if !m.checkHoldError(w, err, "CaptureHold") {
+		return
+	}
+	if captured {
+		metrics.PointsWithdrawn.WithLabelValues(hold.TenantID).Add(float64(hold.Sum))
+	}
+
+	m.writeHold(w, hold)
+}
+
+func (m *Repository) VoidHold(w http.ResponseWriter, r *http.Request) {
+	//- `200` — резерв отменен, баллы возвращены (или уже были возвращены);
+	//- `401` — пользователь не авторизован;
+	//- `404` — резерв не найден;
+	//- `409` — резерв уже списан или истек;
+	//- `500` — внутренняя ошибка сервера.
+	hold, err := m.Store.VoidHold(r.Context(), m.GetUserID(r), chi.URLParam(r, "order"))
+	if !m.checkHoldError(w, err, "VoidHold") {
+		return
+	}
+
+	m.writeHold(w, hold)
+}
+
+// checkHoldError отвечает клиенту по ошибке операции с резервом и сообщает, можно ли продолжать
+func (m *Repository) checkHoldError(w http.ResponseWriter, err error, operation string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, ErrHoldClosed):
+		w.WriteHeader(http.StatusConflict)
+	case errors.Is(err, ErrNotEnoughMoney), errors.Is(err, ErrIdempotencyMismatch), errors.Is(err, ErrDuplicate):
+		// по заказу уже есть списание в обход резерва
+		w.WriteHeader(http.StatusConflict)
+	default:
+		logger.Log.Errorf("failed %s()= %v", operation, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	return false
+}
+
+func (m *Repository) writeHold(w http.ResponseWriter, hold *models.Hold) {
+	if err := m.WriteResponseJSON(w, hold, http.StatusOK); err != nil {
+		logger.Log.Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -1,21 +1,35 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
 	"time"
 )
 
+// mfaTokenPurpose - назначение токена второго шага входа
+const mfaTokenPurpose = "mfa"
+
+// mfaTokenTTL - сколько действует токен второго шага входа
+const mfaTokenTTL = 5 * time.Minute
+
 // Claims — структура утверждений, которая включает стандартные утверждения и
-// одно пользовательское UserID
+// пользовательские UserID, TenantID, Version (версия токенов пользователя при выдаче)
+// и Purpose (пустое назначение - доступ к API)
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID int64
+	UserID   int64
+	TenantID string `json:",omitempty"`
+	Version  int64  `json:",omitempty"`
+	Purpose  string `json:",omitempty"`
 }
 
-// BuildJWTString создаёт токен и возвращает его в виде строки.
-func BuildJWTString(userID int64) (string, error) {
+// BuildJWTString создаёт токен программы лояльности tenant с версией токенов пользователя
+// version и возвращает его в виде строки.
+func BuildJWTString(userID, version int64, tenant *models.Tenant) (string, error) {
 	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -23,11 +37,13 @@ func BuildJWTString(userID int64) (string, error) {
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(app.TokenExp))),
 		},
 		// собственное утверждение
-		UserID: userID,
+		UserID:   userID,
+		TenantID: tenant.ID,
+		Version:  version,
 	})
 
 	// создаём строку токена
-	tokenString, err := token.SignedString([]byte(app.SecretKey))
+	tokenString, err := token.SignedString([]byte(tenant.SecretKey))
 	if err != nil {
 		return "", err
 	}
@@ -36,7 +52,59 @@ func BuildJWTString(userID int64) (string, error) {
 	return tokenString, nil
 }
 
-func GetUserID(tokenString string) int64 {
+// buildMFAToken создаёт токен второго шага входа: он подтверждает пароль пользователя,
+// но доступа к API не дает
+func buildMFAToken(userID int64, tenant *models.Tenant) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaTokenTTL)),
+		},
+		UserID:   userID,
+		TenantID: tenant.ID,
+		Purpose:  mfaTokenPurpose,
+	})
+
+	return token.SignedString([]byte(tenant.SecretKey))
+}
+
+// GetUserID возвращает ID пользователя из токена программы лояльности tenant
+// или -1, если токен недействителен или выпущен другой программой.
+// Отзыв токенов не проверяется, это делает Authenticate
+func GetUserID(tokenString string, tenant *models.Tenant) int64 {
+	claims := parseToken(tokenString, tenant, "")
+	if claims == nil {
+		return -1
+	}
+
+	return claims.UserID
+}
+
+// Authenticate возвращает ID пользователя из токена доступа или -1, если токен
+// недействителен или отозван сменой пароля
+func (m *Repository) Authenticate(ctx context.Context, tokenString string, tenant *models.Tenant) (int64, error) {
+	claims := parseToken(tokenString, tenant, "")
+	if claims == nil {
+		return -1, nil
+	}
+
+	user, err := m.Store.GetUser(ctx, claims.UserID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return -1, nil
+	case err != nil:
+		return -1, err
+	}
+	if user.TokenVersion != claims.Version {
+		logger.FromContext(ctx).Infoln("Token is revoked")
+		return -1, nil
+	}
+
+	return claims.UserID, nil
+}
+
+// parseToken возвращает утверждения токена с назначением purpose программы
+// лояльности tenant или nil, если токен недействителен
+func parseToken(tokenString string, tenant *models.Tenant, purpose string) *Claims {
 	// создаём экземпляр структуры с утверждениями
 	claims := &Claims{}
 	// парсим из строки токена tokenString в структуру claims
@@ -45,18 +113,33 @@ func GetUserID(tokenString string) int64 {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return []byte(app.SecretKey), nil
+		return []byte(tenant.SecretKey), nil
 	})
 	if err != nil {
 		logger.Log.Infoln(err)
-		return -1
+		return nil
 	}
 
 	if !token.Valid {
 		logger.Log.Infoln("Token is not valid")
-		return -1
+		return nil
 	}
 
-	// возвращаем ID пользователя в читаемом виде
-	return claims.UserID
+	// токены, выпущенные до появления программ, относятся к программе по умолчанию
+	tenantID := claims.TenantID
+	if tenantID == "" {
+		tenantID = models.DefaultTenantID
+	}
+	if tenantID != tenant.ID {
+		logger.Log.Infoln("Token belongs to another tenant")
+		return nil
+	}
+
+	// токен второго шага входа не дает доступа к API и наоборот
+	if claims.Purpose != purpose {
+		logger.Log.Infoln("Token has another purpose")
+		return nil
+	}
+
+	return claims
 }
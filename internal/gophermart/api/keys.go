@@ -0,0 +1,41 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+)
+
+// IsAdminToken проверяет токен администратора программы лояльности tenant.
+// Пустой токен программы отключает ее admin API
+func IsAdminToken(tenant *models.Tenant, token string) bool {
+	if tenant.AdminToken == "" || token == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(tenant.AdminToken)) == 1
+}
+
+// IsMerchantKey проверяет API-ключ доверенного мерчанта программы лояльности tenant
+func IsMerchantKey(tenant *models.Tenant, key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, merchantKey := range tenant.MerchantAPIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(merchantKey)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sha256Hex возвращает хэш секрета, под которым он хранится. Секреты выдаются
+// сервером и достаточно длинные, поэтому соль не нужна
+func sha256Hex(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
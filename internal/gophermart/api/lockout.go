@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditEventsDefaultLimit и auditEventsMaxLimit ограничивают выдачу журнала аудита
+const (
+	auditEventsDefaultLimit = 100
+	auditEventsMaxLimit     = 1000
+)
+
+// ClientIP возвращает адрес клиента; X-Forwarded-For учитывается только за доверенным прокси
+func ClientIP(r *http.Request) string {
+	if app.RateLimitTrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			ip, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(ip)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// loginPolicy - защита входа от перебора паролей из конфигурации
+func loginPolicy() models.LoginPolicy {
+	return models.LoginPolicy{
+		MaxFailures:   app.LoginMaxFailures,
+		MaxIPFailures: app.LoginMaxIPFailures,
+		Window:        app.LoginFailureWindow,
+		Lockout:       app.LoginLockout,
+		Delay:         app.LoginDelay,
+		MaxDelay:      app.LoginMaxDelay,
+	}
+}
+
+// loginRetryAfter возвращает, через сколько разрешена следующая попытка входа
+// с логином login с адреса ip (0 - сразу)
+func (m *Repository) loginRetryAfter(ctx context.Context, tenantID, login, ip string) (time.Duration, error) {
+	attempts, err := m.Store.GetLoginAttempts(ctx, tenantID, login, ip)
+	if err != nil {
+		return 0, err
+	}
+
+	policy := loginPolicy()
+	now := time.Now()
+	var retryAfter time.Duration
+	for _, a := range attempts {
+		retryAfter = max(retryAfter, policy.RetryAfter(a, now))
+	}
+
+	return retryAfter, nil
+}
+
+// writeTooManyAttempts отвечает `429` с Retry-After в секундах
+func writeTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "too many failed login attempts", http.StatusTooManyRequests)
+}
+
+// audit пишет событие пользователя в журнал аудита с адресом клиента; ошибка записи
+// не отменяет уже выполненную операцию
+func (m *Repository) audit(r *http.Request, event models.AuditEvent) {
+	if event.Actor == "" {
+		event.Actor = "user"
+	}
+	event.IP = ClientIP(r)
+	if err := m.Store.CreateAuditEvent(context.WithoutCancel(r.Context()), event); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed CreateAuditEvent()= ", err)
+	}
+}
+
+func (m *Repository) GetLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `204` — нет действующих блокировок;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	lockouts, err := m.Store.GetLoginLockouts(r.Context(), GetTenant(r).ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetLoginLockouts()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(lockouts) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, lockouts, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) UnlockLogin(w http.ResponseWriter, r *http.Request) {
+	//- `204` — блокировка снята;
+	//- `400` — неизвестный тип блокировки (login или ip);
+	//- `401` — нет доступа;
+	//- `404` — действующей блокировки нет;
+	//- `500` — внутренняя ошибка сервера.
+	scope := models.LoginScope(chi.URLParam(r, "scope"))
+	if scope != models.LoginScopeLogin && scope != models.LoginScopeIP {
+		http.Error(w, "scope must be login or ip", http.StatusBadRequest)
+		return
+	}
+	value := chi.URLParam(r, "value")
+	tenant := GetTenant(r)
+
+	locked, err := m.Store.ResetLoginAttempts(r.Context(), tenant.ID, scope, value)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed ResetLoginAttempts()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !locked {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	event := models.AuditEvent{
+		TenantID: tenant.ID,
+		Event:    models.AuditEventLoginUnlocked,
+		Actor:    "admin",
+		Details:  string(scope) + " unlocked by admin",
+	}
+	if scope == models.LoginScopeLogin {
+		event.Login = value
+	} else {
+		event.Details = "ip " + value + " unlocked by admin"
+	}
+	m.audit(r, event)
+	if scope == models.LoginScopeLogin {
+		value = logger.Login(value)
+	}
+	logger.FromContext(r.Context()).Infoln("Login unlocked:", "scope", scope, "value", value)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Repository) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `204` — нет событий;
+	//- `400` — неверный limit;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	limit := auditEventsDefaultLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, auditEventsMaxLimit)
+	}
+
+	events, err := m.Store.GetAuditEvents(r.Context(), GetTenant(r).ID, r.URL.Query().Get("event"), limit)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetAuditEvents()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, events, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
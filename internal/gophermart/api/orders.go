@@ -5,6 +5,7 @@ import (
 	"errors"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/tracing"
 	"net/http"
 	"strconv"
 	"time"
@@ -20,7 +21,7 @@ func (m *Repository) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	//- `500` — внутренняя ошибка сервера.
 	var orderNumber int64
 	if err := json.NewDecoder(r.Body).Decode(&orderNumber); err != nil {
-		logger.Log.Errorln("orderNumber", orderNumber, err)
+		logger.FromContext(r.Context()).Errorln("orderNumber", orderNumber, err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -33,11 +34,12 @@ func (m *Repository) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	order.UserID = m.GetUserID(r)
+	order.TenantID = GetTenant(r).ID
 	order.Status = models.OrderStateNew
 	order.CreatedAt = time.Now().Format(time.RFC3339)
 	orderNumberDB, userDB, err := m.Store.CreateOrder(r.Context(), order)
 	if err != nil && !errors.Is(err, ErrDuplicate) {
-		logger.Log.Errorln("failed CreateOrder()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed CreateOrder()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -57,8 +59,11 @@ func (m *Repository) CreateOrder(w http.ResponseWriter, r *http.Request) {
 
 	// пишем в канал
 	accrualRequest := models.AccrualRequest{
-		Number: orderNumberDB,
-		UserID: userDB,
+		Number:    orderNumberDB,
+		UserID:    userDB,
+		TenantID:  order.TenantID,
+		RequestID: logger.RequestID(r.Context()),
+		Trace:     tracing.Carrier(r.Context()),
 	}
 	m.Jobs <- accrualRequest
 
@@ -66,7 +71,7 @@ func (m *Repository) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 	_, err = w.Write([]byte(orderNumberDB))
 	if err != nil {
-		logger.Log.Errorln("failed Write()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed Write()= ", err)
 	}
 }
 
@@ -78,12 +83,12 @@ func (m *Repository) GetOrders(w http.ResponseWriter, r *http.Request) {
 	authUserID := m.GetUserID(r)
 	orders, err := m.Store.GetOrders(r.Context(), authUserID)
 	if err != nil {
-		logger.Log.Errorln("failed GetOrders()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed GetOrders()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	logger.Log.Infoln("Requested by authUserID", authUserID, "|", "orders", orders)
+	logger.FromContext(r.Context()).Debugln("Requested by authUserID", authUserID, "|", "orders", orders)
 
 	// 204` — нет данных для ответа.
 	if len(orders) == 0 {
@@ -92,12 +97,13 @@ func (m *Repository) GetOrders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := m.WriteResponseJSON(w, orders, http.StatusOK); err != nil {
-		logger.Log.Errorln("failed WriteResponseJSON()=", err)
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+// Close закрывает хранилище; очередь Jobs не закрывается, так как заказы
+// в нее могут возвращать горутины, ожидающие места
 func (m *Repository) Close() error {
-	close(m.Jobs)
-	return nil
+	return m.Store.Close()
 }
@@ -0,0 +1,449 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/tracing"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partnerKeyPrefix отличает партнерские ключи от прочих секретов (например, в логах и сканерах утечек)
+const partnerKeyPrefix = "gmp_"
+
+// partnerKeyLen - длина случайной части ключа (32 символа из 32 - 160 бит)
+const partnerKeyLen = 32
+
+// partnerReportDefaultPeriod - период отчета, если from не указан
+const partnerReportDefaultPeriod = 30 * 24 * time.Hour
+
+type partnerContextKey struct{}
+
+// partnerOrder - заказ, который партнер регистрирует за покупателя
+type partnerOrder struct {
+	Number string `json:"number"`
+	Login  string `json:"login"`
+}
+
+// AuthenticatePartner возвращает активного партнера по API-ключу или ErrNotFound
+func (m *Repository) AuthenticatePartner(ctx context.Context, key string) (*models.Partner, error) {
+	if !strings.HasPrefix(key, partnerKeyPrefix) {
+		return nil, ErrNotFound
+	}
+
+	return m.Store.GetPartnerByKeyHash(ctx, sha256Hex(key))
+}
+
+// WithPartner сохраняет проверенного партнера в контексте запроса
+func WithPartner(ctx context.Context, partner *models.Partner) context.Context {
+	return context.WithValue(ctx, partnerContextKey{}, partner)
+}
+
+// GetPartner возвращает партнера, сохраненного middleware.CheckPartner
+func (m *Repository) GetPartner(r *http.Request) *models.Partner {
+	partner, _ := r.Context().Value(partnerContextKey{}).(*models.Partner)
+
+	return partner
+}
+
+func (m *Repository) PartnerCreateOrder(w http.ResponseWriter, r *http.Request) {
+	//- `200` — номер заказа уже был загружен этим покупателем;
+	//- `202` — новый номер заказа принят в обработку;
+	//- `400` — неверный формат запроса;
+	//- `401` — неверный API-ключ;
+	//- `403` — у ключа нет доступа orders:write;
+	//- `404` — покупатель не найден;
+	//- `409` — номер заказа уже был загружен другим пользователем;
+	//- `422` — неверный формат номера заказа;
+	//- `500` — внутренняя ошибка сервера.
+	var req partnerOrder
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Login == "" {
+		http.Error(w, "login is required", http.StatusBadRequest)
+		return
+	}
+
+	order := models.Order{Number: req.Number}
+	if req.Number == "" || !order.IsValid() {
+		// `422` — неверный формат номера заказа;
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	userID, err := m.Store.GetUserIDByLogin(r.Context(), GetTenant(r).ID, req.Login)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "customer not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetUserIDByLogin()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	partner := m.GetPartner(r)
+	order.UserID = userID
+	order.PartnerID = partner.ID
+	order.TenantID = partner.TenantID
+	order.Status = models.OrderStateNew
+	order.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	orderNumberDB, userDB, err := m.Store.CreateOrder(r.Context(), order)
+	switch {
+	case errors.Is(err, ErrDuplicate):
+		// `200` — номер заказа уже был загружен этим покупателем;
+		w.WriteHeader(http.StatusOK)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed CreateOrder()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	case userDB != order.UserID:
+		// `409` — номер заказа уже был загружен другим пользователем;
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	logger.FromContext(r.Context()).Infoln("Partner order:", "partner.ID", partner.ID, "order.Number", orderNumberDB)
+
+	m.Jobs <- models.AccrualRequest{
+		Number:    orderNumberDB,
+		UserID:    userDB,
+		TenantID:  order.TenantID,
+		RequestID: logger.RequestID(r.Context()),
+		Trace:     tracing.Carrier(r.Context()),
+	}
+
+	// `202` — новый номер заказа принят в обработку;
+	w.WriteHeader(http.StatusAccepted)
+	_, err = w.Write([]byte(orderNumberDB))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed Write()= ", err)
+	}
+}
+
+func (m *Repository) PartnerGetOrder(w http.ResponseWriter, r *http.Request) {
+	//- `200` — статус и начисление по заказу;
+	//- `401` — неверный API-ключ;
+	//- `403` — у ключа нет доступа orders:read;
+	//- `404` — заказ не найден среди заказов партнера;
+	//- `500` — внутренняя ошибка сервера.
+	order, err := m.Store.GetPartnerOrder(r.Context(), m.GetPartner(r).ID, chi.URLParam(r, "number"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetPartnerOrder()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, order, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) PartnerGetWithdrawal(w http.ResponseWriter, r *http.Request) {
+	//- `200` — списание по заказу с учетом возвратов;
+	//- `401` — неверный API-ключ;
+	//- `403` — у ключа нет доступа withdrawals:read;
+	//- `404` — списания по заказу партнера нет;
+	//- `500` — внутренняя ошибка сервера.
+	withdrawal, err := m.Store.GetPartnerWithdrawal(r.Context(), m.GetPartner(r).ID, chi.URLParam(r, "order"))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetPartnerWithdrawal()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, withdrawal, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) PartnerGetReport(w http.ResponseWriter, r *http.Request) {
+	//- `200` — отчет за период ?from=&to= (RFC3339, по умолчанию последние 30 дней);
+	//- `400` — неверный период;
+	//- `401` — неверный API-ключ;
+	//- `403` — у ключа нет доступа reports:read;
+	//- `500` — внутренняя ошибка сервера.
+	m.writePartnerReport(w, r, m.GetPartner(r).ID)
+}
+
+func (m *Repository) CreatePartner(w http.ResponseWriter, r *http.Request) {
+	//- `201` — партнер создан, ключ возвращается один раз;
+	//- `400` — неверный формат запроса;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	partner := models.Partner{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&partner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := preparePartner(&partner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, err := newPartnerKey()
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed newPartnerKey()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	partner.KeyPrefix = partnerKeyPrefixOf(key)
+	partner.TenantID = GetTenant(r).ID
+	created, err := m.Store.CreatePartner(r.Context(), partner, sha256Hex(key))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed CreatePartner()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	created.APIKey = key
+	logger.FromContext(r.Context()).Infoln("Partner created:", "partner.ID", created.ID, "partner.Name", created.Name)
+
+	if err := m.WriteResponseJSON(w, created, http.StatusCreated); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) GetPartners(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `204` — нет партнеров;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	partners, err := m.Store.GetPartners(r.Context(), GetTenant(r).ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetPartners()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(partners) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, partners, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) AdminGetPartner(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `401` — нет доступа;
+	//- `404` — партнер не найден;
+	//- `500` — внутренняя ошибка сервера.
+	partner, ok := m.getPartner(w, r)
+	if !ok {
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, partner, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) UpdatePartner(w http.ResponseWriter, r *http.Request) {
+	//- `200` — партнер изменен;
+	//- `400` — неверный формат запроса;
+	//- `401` — нет доступа;
+	//- `404` — партнер не найден;
+	//- `500` — внутренняя ошибка сервера.
+	partner, ok := m.getPartner(w, r)
+	if !ok {
+		return
+	}
+	// поля, которых нет в запросе, сохраняют текущие значения
+	id := partner.ID
+	if err := json.NewDecoder(r.Body).Decode(partner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	partner.ID = id
+	if err := preparePartner(partner); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	updated, err := m.Store.UpdatePartner(r.Context(), *partner)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed UpdatePartner()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	logger.FromContext(r.Context()).Infoln("Partner updated:", "partner.ID", updated.ID, "partner.Active", updated.Active)
+
+	if err := m.WriteResponseJSON(w, updated, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) RotatePartnerKey(w http.ResponseWriter, r *http.Request) {
+	//- `200` — выпущен новый ключ, прежний больше не действует;
+	//- `401` — нет доступа;
+	//- `404` — партнер не найден;
+	//- `500` — внутренняя ошибка сервера.
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	key, err := newPartnerKey()
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed newPartnerKey()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	partner, err := m.Store.SetPartnerKey(r.Context(), GetTenant(r).ID, id, partnerKeyPrefixOf(key), sha256Hex(key))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed SetPartnerKey()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	partner.APIKey = key
+	logger.FromContext(r.Context()).Infoln("Partner key rotated:", "partner.ID", partner.ID, "partner.KeyPrefix", partner.KeyPrefix)
+
+	if err := m.WriteResponseJSON(w, partner, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) AdminGetPartnerReport(w http.ResponseWriter, r *http.Request) {
+	//- `200` — отчет партнера за период ?from=&to=;
+	//- `400` — неверный период;
+	//- `401` — нет доступа;
+	//- `404` — партнер не найден;
+	//- `500` — внутренняя ошибка сервера.
+	partner, ok := m.getPartner(w, r)
+	if !ok {
+		return
+	}
+
+	m.writePartnerReport(w, r, partner.ID)
+}
+
+// getPartner читает партнера по {id} из пути и пишет ответ, если его нет
+func (m *Repository) getPartner(w http.ResponseWriter, r *http.Request) (*models.Partner, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	partner, err := m.Store.GetPartner(r.Context(), GetTenant(r).ID, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetPartner()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return partner, true
+}
+
+// writePartnerReport строит отчет партнера за период из ?from=&to= и пишет ответ
+func (m *Repository) writePartnerReport(w http.ResponseWriter, r *http.Request, partnerID int64) {
+	// граница to не входит в период, поэтому по умолчанию это следующая секунда:
+	// даты в хранилище могут быть округлены до секунд
+	to := time.Now().UTC().Truncate(time.Second).Add(time.Second)
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "to must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-partnerReportDefaultPeriod)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "from must be RFC3339", http.StatusBadRequest)
+			return
+		}
+		from = t.UTC()
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	report, err := m.Store.GetPartnerReport(r.Context(), partnerID, from, to)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetPartnerReport()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, report, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// preparePartner проверяет имя и области доступа партнера
+func preparePartner(p *models.Partner) error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	if p.Scopes == nil {
+		p.Scopes = []string{}
+	}
+	for _, scope := range p.Scopes {
+		known := false
+		for _, s := range models.PartnerScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return errors.New("unknown scope " + scope)
+		}
+	}
+
+	return nil
+}
+
+// newPartnerKey возвращает новый API-ключ партнера
+func newPartnerKey() (string, error) {
+	code, err := randomCode(partnerKeyLen)
+	if err != nil {
+		return "", err
+	}
+
+	return partnerKeyPrefix + code, nil
+}
+
+// partnerKeyPrefixOf возвращает видимую часть ключа, по которой его можно узнать
+func partnerKeyPrefixOf(key string) string {
+	return key[:len(partnerKeyPrefix)+8]
+}
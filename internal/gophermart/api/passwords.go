@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/notifier"
+	"net/http"
+	"net/mail"
+	"time"
+)
+
+// passwordResetTokenLen - длина токена сброса пароля в байтах (256 бит)
+const passwordResetTokenLen = 32
+
+// passwordChange - смена пароля пользователем
+type passwordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// emailChange - смена адреса для сброса пароля, подтверждается паролем
+type emailChange struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// passwordResetRequest - запрос токена сброса пароля
+type passwordResetRequest struct {
+	Login string `json:"login"`
+}
+
+// passwordReset - новый пароль по токену сброса
+type passwordReset struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (m *Repository) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	//- `200` — пароль изменен, в Authorization новый токен, выданные раньше токены отозваны;
+	//- `400` — неверный формат запроса;
+	//- `401` — пользователь не авторизован;
+	//- `403` — неверный текущий пароль;
+	//- `429` — слишком много неудачных попыток, повторить через Retry-After секунд;
+	//- `500` — внутренняя ошибка сервера.
+	var req passwordChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.OldPassword == "" || req.NewPassword == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, ok := m.authorizeByPassword(w, r)
+	if !ok {
+		return
+	}
+
+	changed, err := m.Store.ChangePassword(r.Context(), user.ID, sha256Hex(req.OldPassword), sha256Hex(req.NewPassword))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		m.passwordFailed(w, r, user)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed ChangePassword()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.audit(r, models.AuditEvent{
+		TenantID: changed.TenantID,
+		Event:    models.AuditEventPasswordChanged,
+		UserID:   changed.ID,
+		Login:    changed.Login,
+	})
+
+	// текущий сеанс продолжается с токеном новой версии
+	token, err := BuildJWTString(changed.ID, changed.TokenVersion, GetTenant(r))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed BuildJWTString()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *Repository) SetEmail(w http.ResponseWriter, r *http.Request) {
+	//- `204` — адрес изменен (пустой адрес удаляет его);
+	//- `400` — неверный формат запроса или адреса;
+	//- `401` — пользователь не авторизован;
+	//- `403` — неверный пароль;
+	//- `429` — слишком много неудачных попыток, повторить через Retry-After секунд;
+	//- `500` — внутренняя ошибка сервера.
+	var req emailChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Password == "" || req.Email != "" && !isEmail(req.Email) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// адрес, на который приходит сброс пароля, - ключ от аккаунта: без пароля его не сменить
+	user, ok := m.authorizeByPassword(w, r)
+	if !ok || !m.checkPassword(w, r, user, req.Password) {
+		return
+	}
+
+	if err := m.Store.SetEmail(r.Context(), user.ID, req.Email); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed SetEmail()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.audit(r, models.AuditEvent{
+		TenantID: user.TenantID,
+		Event:    models.AuditEventEmailChanged,
+		UserID:   user.ID,
+		Login:    user.Login,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Repository) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	//- `202` — если у пользователя есть адрес, на него отправлен токен сброса;
+	//- `400` — неверный формат запроса;
+	//- `500` — внутренняя ошибка сервера.
+	var req passwordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Login == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// ответ одинаков для неизвестного логина и пользователя без адреса,
+	// чтобы по нему нельзя было узнать, есть ли такой пользователь
+	tenant := GetTenant(r)
+	userID, err := m.Store.GetUserIDByLogin(r.Context(), tenant.ID, req.Login)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetUserIDByLogin()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	user, err := m.Store.GetUser(r.Context(), userID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetUser()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if user.Email == "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if err := m.sendPasswordReset(r.Context(), user); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed sendPasswordReset()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	m.audit(r, models.AuditEvent{
+		TenantID: user.TenantID,
+		Event:    models.AuditEventPasswordResetAsked,
+		UserID:   user.ID,
+		Login:    user.Login,
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	//- `204` — пароль изменен, выданные раньше токены отозваны, токен доступа не выдается
+	//  (вход по новому паролю, со вторым фактором — и по коду);
+	//- `400` — неверный формат запроса, токен недействителен, использован или истек;
+	//- `500` — внутренняя ошибка сервера.
+	var req passwordReset
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := m.Store.ResetPassword(r.Context(), sha256Hex(req.Token), sha256Hex(req.Password))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "invalid or expired reset token", http.StatusBadRequest)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed ResetPassword()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// владелец подтвердил доступ к адресу: блокировка входа по логину снимается
+	if _, err := m.Store.ResetLoginAttempts(r.Context(), user.TenantID, models.LoginScopeLogin, user.Login); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed ResetLoginAttempts()= ", err)
+	}
+	m.audit(r, models.AuditEvent{
+		TenantID: user.TenantID,
+		Event:    models.AuditEventPasswordReset,
+		UserID:   user.ID,
+		Login:    user.Login,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeByPassword возвращает пользователя запроса, который подтверждает операцию
+// паролем, если попытки входа с его логином не приостановлены; иначе пишет ответ
+func (m *Repository) authorizeByPassword(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := m.Store.GetUser(r.Context(), m.GetUserID(r))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetUser()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	// проверка пароля - та же попытка входа: защита от перебора общая
+	retryAfter, err := m.loginRetryAfter(r.Context(), user.TenantID, user.Login, ClientIP(r))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetLoginAttempts()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if retryAfter > 0 {
+		writeTooManyAttempts(w, retryAfter)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// checkPassword проверяет пароль пользователя; если он неверный, пишет ответ и возвращает false
+func (m *Repository) checkPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string) bool {
+	id, err := m.Store.GetIDUserByAuth(r.Context(), models.User{
+		TenantID: user.TenantID,
+		Login:    user.Login,
+		Password: sha256Hex(password),
+	})
+	switch {
+	case errors.Is(err, ErrNotFound) || err == nil && id != user.ID:
+		m.passwordFailed(w, r, user)
+		return false
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetIDUserByAuth()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// passwordFailed учитывает неверный пароль как неудачную попытку входа и отвечает `403`
+func (m *Repository) passwordFailed(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if err := m.Store.RecordLoginFailure(r.Context(), user.TenantID, user.Login, ClientIP(r), loginPolicy()); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed RecordLoginFailure()= ", err)
+	}
+	http.Error(w, "invalid password", http.StatusForbidden)
+}
+
+// sendPasswordReset создает одноразовый токен сброса пароля и отправляет его пользователю
+func (m *Repository) sendPasswordReset(ctx context.Context, user *models.User) error {
+	b := make([]byte, passwordResetTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	expiresAt := time.Now().Add(app.PasswordResetTTL)
+
+	if err := m.Store.CreatePasswordReset(ctx, user.ID, sha256Hex(token), expiresAt); err != nil {
+		return err
+	}
+
+	return m.Notifier.Notify(ctx, notifier.Message{
+		To:      user.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Your password reset token for %s: %s\nIt is valid until %s. If you did not request it, ignore this message.",
+			user.Login, token, expiresAt.UTC().Format(time.RFC3339)),
+	})
+}
+
+// isEmail проверяет, что строка - один адрес без имени
+func isEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+
+	return err == nil && addr.Address == email
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// inviteCodeAlphabet - символы кода приглашения без похожих друг на друга (0/O, 1/I)
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const inviteCodeLen = 8
+
+// inviteCodeAttempts - сколько случайных кодов приглашения пробуется при регистрации
+const inviteCodeAttempts = 5
+
+// referrals - код приглашения пользователя и приглашенные им
+type referrals struct {
+	InviteCode string            `json:"invite_code"`
+	Referrals  []models.Referral `json:"referrals"`
+}
+
+func (m *Repository) GetReferrals(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `401` — пользователь не авторизован;
+	//- `500` — внутренняя ошибка сервера.
+	userID := m.GetUserID(r)
+	code, err := m.Store.GetInviteCode(r.Context(), userID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetInviteCode()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	list, err := m.Store.GetReferrals(r.Context(), userID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetReferrals()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := referrals{InviteCode: code, Referrals: list}
+	for i := range res.Referrals {
+		res.Referrals[i].Login = maskLogin(res.Referrals[i].Login)
+	}
+	if res.Referrals == nil {
+		res.Referrals = []models.Referral{}
+	}
+
+	if err := m.WriteResponseJSON(w, res, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// RewardReferral начисляет бонусы за приглашение после обработанного заказа пользователя
+func (m *Repository) RewardReferral(ctx context.Context, tenantID string, userID int64, order string) error {
+	referral, err := m.Store.RewardReferral(ctx, userID, order, models.ReferralPolicy{
+		ReferrerReward: models.Money(app.ReferralReferrerReward.Set()),
+		RefereeReward:  models.Money(app.ReferralRefereeReward.Set()),
+		MaxRewards:     app.ReferralMaxRewards,
+	})
+	if err != nil || referral == nil {
+		return err
+	}
+	if referral.Status == models.ReferralStatusRewarded {
+		// бонусы получают оба участника; неположительные награды хранилище не начисляет
+		var credited models.Money
+		for _, reward := range []models.Money{app.ReferralReferrerReward, app.ReferralRefereeReward} {
+			if reward > 0 {
+				credited += reward
+			}
+		}
+		metrics.PointsCredited.WithLabelValues(tenantID).Add(float64(credited))
+	}
+	logger.FromContext(ctx).Infoln(
+		"Referral closed:",
+		"referral.ReferrerID", referral.ReferrerID,
+		"referral.RefereeID", referral.RefereeID,
+		"referral.Status", referral.Status,
+	)
+
+	return nil
+}
+
+// createReferral связывает нового пользователя с пригласившим. Приглашение сверх
+// суточного лимита сохраняется отклоненным, чтобы регистрация не срывалась
+func (m *Repository) createReferral(ctx context.Context, referrerID, refereeID int64) error {
+	referral := models.Referral{
+		ReferrerID: referrerID,
+		RefereeID:  refereeID,
+		Status:     models.ReferralStatusPending,
+	}
+	if app.ReferralDailyLimit > 0 {
+		count, err := m.Store.CountReferrals(ctx, referrerID, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			return err
+		}
+		if count >= int64(app.ReferralDailyLimit) {
+			referral.Status = models.ReferralStatusRejected
+			referral.Reason = "referrer daily limit reached"
+		}
+	}
+
+	return m.Store.CreateReferral(ctx, referral)
+}
+
+// newInviteCode возвращает случайный код приглашения
+func newInviteCode() (string, error) {
+	return randomCode(inviteCodeLen)
+}
+
+// randomCode возвращает случайную строку длины n из символов inviteCodeAlphabet.
+// Длина алфавита делит 256, поэтому символы распределены равномерно
+func randomCode(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = inviteCodeAlphabet[int(b[i])%len(inviteCodeAlphabet)]
+	}
+
+	return string(b), nil
+}
+
+// normalizeInviteCode приводит введенный пользователем код к виду, в котором он хранится
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// maskLogin скрывает логин приглашенного, оставляя первые символы
+func maskLogin(login string) string {
+	runes := []rune(login)
+	if len(runes) <= 2 {
+		return "***"
+	}
+
+	return string(runes[:2]) + "***"
+}
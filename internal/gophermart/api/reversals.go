@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+)
+
+func (m *Repository) AdminReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	m.reverseWithdrawal(w, r, "admin")
+}
+
+func (m *Repository) MerchantReverseWithdrawal(w http.ResponseWriter, r *http.Request) {
+	m.reverseWithdrawal(w, r, "merchant")
+}
+
+func (m *Repository) reverseWithdrawal(w http.ResponseWriter, r *http.Request, source string) {
+	//- `200` — баллы возвращены, в ответе списание с учетом возврата;
+	//- `400` — неверный формат запроса;
+	//- `401` — нет доступа;
+	//- `404` — списание по заказу не найдено;
+	//- `422` — сумма возврата больше остатка списания;
+	//- `500` — внутренняя ошибка сервера.
+	var reversal models.Reversal
+	if err := json.NewDecoder(r.Body).Decode(&reversal); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reversal.Sum < 0 || reversal.Reason == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	reversal.Order = chi.URLParam(r, "order")
+	reversal.Sum = models.Money(reversal.Sum.Set())
+	reversal.Source = source
+	reversal.TenantID = GetTenant(r).ID
+
+	logger.FromContext(r.Context()).Infoln(
+		"Reversal:",
+		"reversal.Order", reversal.Order,
+		"reversal.Sum", reversal.Sum,
+		"reversal.Source", reversal.Source,
+	)
+
+	withdrawal, err := m.Store.ReverseWithdrawal(r.Context(), reversal)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, ErrReversalExceeded):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed ReverseWithdrawal()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, withdrawal, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
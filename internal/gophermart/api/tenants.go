@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TenantHeader - заголовок, которым клиент явно выбирает программу лояльности
+const TenantHeader = "X-Tenant-ID"
+
+type tenantContextKey struct{}
+
+// ResolveTenant определяет программу лояльности запроса: по заголовку X-Tenant-ID,
+// затем по имени хоста, иначе - программа по умолчанию.
+// Неизвестная программа в заголовке - ErrNotFound
+func ResolveTenant(r *http.Request) (*models.Tenant, error) {
+	if id := r.Header.Get(TenantHeader); id != "" {
+		for i := range app.Tenants {
+			if app.Tenants[i].ID == id {
+				return &app.Tenants[i], nil
+			}
+		}
+		return nil, ErrNotFound
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for i := range app.Tenants {
+		for _, h := range app.Tenants[i].Hosts {
+			if h == host {
+				return &app.Tenants[i], nil
+			}
+		}
+	}
+
+	return TenantByID(models.DefaultTenantID), nil
+}
+
+// TenantByID возвращает программу лояльности по идентификатору; неизвестный
+// идентификатор (например, программа удалена из конфигурации) - nil
+func TenantByID(id string) *models.Tenant {
+	if id == "" {
+		id = models.DefaultTenantID
+	}
+	for i := range app.Tenants {
+		if app.Tenants[i].ID == id {
+			return &app.Tenants[i]
+		}
+	}
+
+	return nil
+}
+
+// WithTenant сохраняет программу лояльности запроса в контексте
+func WithTenant(ctx context.Context, tenant *models.Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// GetTenant возвращает программу лояльности, определенную middleware.ResolveTenant
+func GetTenant(r *http.Request) *models.Tenant {
+	if tenant, ok := r.Context().Value(tenantContextKey{}).(*models.Tenant); ok {
+		return tenant
+	}
+
+	return TenantByID(models.DefaultTenantID)
+}
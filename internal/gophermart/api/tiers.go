@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"math"
+	"net/http"
+	"time"
+)
+
+// tierProgress - уровень пользователя и прогресс до следующего
+type tierProgress struct {
+	Tier          string           `json:"tier"`
+	Multiplier    float64          `json:"multiplier"`
+	Bonus         models.Money     `json:"bonus,omitempty"`
+	Basis         models.TierBasis `json:"basis"`
+	WindowMonths  int              `json:"window_months"`
+	Qualifying    models.Money     `json:"qualifying"`
+	Next          string           `json:"next,omitempty"`
+	NextThreshold models.Money     `json:"next_threshold,omitempty"`
+	Remaining     models.Money     `json:"remaining,omitempty"`
+	UpdatedAt     string           `json:"updated_at,omitempty"`
+	Tiers         []models.Tier    `json:"tiers"`
+}
+
+func (m *Repository) GetTier(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `401` — пользователь не авторизован;
+	//- `500` — внутренняя ошибка сервера.
+	userTier, err := m.Store.GetUserTier(r.Context(), m.GetUserID(r))
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetUserTier()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// прогресс считается по текущей сумме, уровень - присвоенный при пересчете
+	total, err := m.Store.GetQualifyingTotal(r.Context(), userTier.UserID, app.LoyaltyTierBasis, tierWindowStart())
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetQualifyingTotal()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res := tierProgress{
+		Tier:         userTier.Tier,
+		Multiplier:   1,
+		Basis:        app.LoyaltyTierBasis,
+		WindowMonths: app.LoyaltyTierWindowMonths,
+		Qualifying:   total,
+		UpdatedAt:    userTier.UpdatedAt,
+		Tiers:        app.LoyaltyTiers,
+	}
+	if tier := tierByName(userTier.Tier); tier != nil {
+		res.Multiplier = tier.Multiplier
+		res.Bonus = tier.Bonus
+	}
+	if next := nextTier(userTier.Tier); next != nil {
+		res.Next = next.Name
+		res.NextThreshold = next.Threshold
+		res.Remaining = max(next.Threshold-total, 0)
+	}
+	if res.Tiers == nil {
+		res.Tiers = []models.Tier{}
+	}
+
+	if err := m.WriteResponseJSON(w, res, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// RecalcTier пересчитывает уровень пользователя по сумме за скользящее окно
+// и возвращает его. Без настроенных уровней возвращает nil
+func (m *Repository) RecalcTier(ctx context.Context, userID int64) (*models.Tier, error) {
+	if len(app.LoyaltyTiers) == 0 {
+		return nil, nil
+	}
+
+	current, err := m.Store.GetUserTier(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	total, err := m.Store.GetQualifyingTotal(ctx, userID, app.LoyaltyTierBasis, tierWindowStart())
+	if err != nil {
+		return nil, err
+	}
+
+	tier := tierFor(total)
+	if err := m.setTier(ctx, *current, tier, total); err != nil {
+		return nil, err
+	}
+
+	return tier, nil
+}
+
+// RecalcTiers пересчитывает уровни всех пользователей: окно сдвигается, и уровень
+// может понизиться без новых операций. Возвращает число изменившихся уровней
+func (m *Repository) RecalcTiers(ctx context.Context) (int, error) {
+	if len(app.LoyaltyTiers) == 0 {
+		return 0, nil
+	}
+
+	totals, err := m.Store.GetQualifyingTotals(ctx, app.LoyaltyTierBasis, tierWindowStart())
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	for _, current := range totals {
+		tier := tierFor(current.Qualifying)
+		if tierName(tier) == current.Tier {
+			continue
+		}
+		if err := m.setTier(ctx, current, tier, current.Qualifying); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
+// TierBonus возвращает надбавку уровня к начислению accrual (в копейках) и имя уровня
+func TierBonus(tier *models.Tier, accrual models.Money) (models.Money, string) {
+	if tier == nil {
+		return 0, ""
+	}
+	bonus := models.Money(math.Round(float64(accrual)*(tier.Multiplier-1))) + models.Money(tier.Bonus.Set())
+
+	return bonus, tier.Name
+}
+
+func (m *Repository) setTier(ctx context.Context, current models.UserTier, tier *models.Tier, total models.Money) error {
+	name := tierName(tier)
+	if name != current.Tier {
+		logger.FromContext(ctx).Infoln("Loyalty tier changed:", "userID", current.UserID, "from", current.Tier, "to", name)
+	}
+
+	return m.Store.SetUserTier(ctx, models.UserTier{
+		UserID:     current.UserID,
+		Tier:       name,
+		Qualifying: models.Money(total.Set()),
+	})
+}
+
+// tierFor возвращает старший уровень, порог которого достигнут, или nil
+func tierFor(total models.Money) *models.Tier {
+	var tier *models.Tier
+	for i := range app.LoyaltyTiers {
+		if total >= app.LoyaltyTiers[i].Threshold {
+			tier = &app.LoyaltyTiers[i]
+		}
+	}
+
+	return tier
+}
+
+func tierByName(name string) *models.Tier {
+	for i := range app.LoyaltyTiers {
+		if app.LoyaltyTiers[i].Name == name {
+			return &app.LoyaltyTiers[i]
+		}
+	}
+
+	return nil
+}
+
+// nextTier возвращает уровень, следующий за name, или первый, если уровня нет
+func nextTier(name string) *models.Tier {
+	if name == "" {
+		if len(app.LoyaltyTiers) > 0 {
+			return &app.LoyaltyTiers[0]
+		}
+		return nil
+	}
+	for i := range app.LoyaltyTiers {
+		if app.LoyaltyTiers[i].Name == name && i+1 < len(app.LoyaltyTiers) {
+			return &app.LoyaltyTiers[i+1]
+		}
+	}
+
+	return nil
+}
+
+func tierName(tier *models.Tier) string {
+	if tier == nil {
+		return ""
+	}
+
+	return tier.Name
+}
+
+// tierWindowStart возвращает начало скользящего окна уровней
+func tierWindowStart() time.Time {
+	return time.Now().AddDate(0, -app.LoyaltyTierWindowMonths, 0)
+}
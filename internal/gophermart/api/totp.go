@@ -0,0 +1,422 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// параметры TOTP (RFC 6238) по умолчанию: их понимают все приложения-генераторы
+const (
+	totpSecretLen = 20 // байт, как длина HMAC-SHA1
+	totpDigits    = 6
+	totpPeriod    = 30 // секунд
+	totpSkew      = 1  // допустимое расхождение часов в шагах
+)
+
+// recoveryCodesCount и recoveryCodeLen - число и длина кодов восстановления (12 символов - 60 бит)
+const (
+	recoveryCodesCount = 10
+	recoveryCodeLen    = 12
+)
+
+// OTPHeader - заголовок с кодом второго фактора для операций, которые его требуют
+const OTPHeader = "X-OTP-Code"
+
+// totpBase32 - кодировка секрета в ссылке для приложения-генератора
+var totpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// mfaChallenge - ответ на вход с паролем, когда нужен второй фактор
+type mfaChallenge struct {
+	Token     string `json:"mfa_token"`
+	ExpiresIn int    `json:"expires_in"` // секунд
+}
+
+// mfaLogin - второй шаг входа
+type mfaLogin struct {
+	Token string `json:"mfa_token"`
+	Code  string `json:"code"`
+}
+
+// totpEnrolment - секрет для приложения-генератора и коды восстановления; показываются один раз
+type totpEnrolment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// totpStatus - состояние второго фактора пользователя
+type totpStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// totpConfirmation - первый код из приложения-генератора
+type totpConfirmation struct {
+	Code string `json:"code"`
+}
+
+func (m *Repository) LoginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	//- `200` — пользователь успешно аутентифицирован;
+	//- `400` — неверный формат запроса;
+	//- `401` — токен второго шага недействителен или код неверный;
+	//- `429` — слишком много неудачных попыток, повторить через Retry-After секунд;
+	//- `500` — внутренняя ошибка сервера.
+	var req mfaLogin
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" || req.Code == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	claims := parseToken(req.Token, GetTenant(r), mfaTokenPurpose)
+	if claims == nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserID
+	totp, err := m.Store.GetTOTP(r.Context(), userID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// второй фактор отключен после выдачи токена
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !totp.Confirmed {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if !m.checkSecondFactor(w, r, totp, req.Code, http.StatusUnauthorized) {
+		return
+	}
+
+	m.completeLogin(w, r, userID)
+}
+
+func (m *Repository) GetTOTPStatus(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `401` — пользователь не авторизован;
+	//- `500` — внутренняя ошибка сервера.
+	var status totpStatus
+	totp, err := m.Store.GetTOTP(r.Context(), m.GetUserID(r))
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	default:
+		status.Enabled = totp.Confirmed
+		if totp.Confirmed {
+			status.RecoveryCodesLeft = totp.RecoveryCodesLeft
+		}
+	}
+
+	if err := m.WriteResponseJSON(w, status, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	//- `200` — секрет создан, второй фактор включится после подтверждения кодом;
+	//- `401` — пользователь не авторизован;
+	//- `409` — второй фактор уже включен;
+	//- `500` — внутренняя ошибка сервера.
+	secret := make([]byte, totpSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed rand.Read()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	enrolment := totpEnrolment{Secret: totpBase32.EncodeToString(secret)}
+	hashes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		code, err := randomCode(recoveryCodeLen)
+		if err != nil {
+			logger.FromContext(r.Context()).Errorln("failed randomCode()= ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		enrolment.RecoveryCodes = append(enrolment.RecoveryCodes, formatVoucherCode(code))
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	totp, err := m.Store.CreateTOTP(r.Context(), models.TOTP{UserID: m.GetUserID(r), Secret: enrolment.Secret}, hashes)
+	switch {
+	case errors.Is(err, ErrDuplicate):
+		http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed CreateTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	enrolment.URI = totpURI(app.TOTPIssuer, totp.Login, enrolment.Secret)
+
+	if err := m.WriteResponseJSON(w, enrolment, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	//- `204` — второй фактор включен;
+	//- `400` — неверный формат запроса;
+	//- `401` — пользователь не авторизован;
+	//- `404` — секрет не создан;
+	//- `409` — второй фактор уже включен;
+	//- `422` — неверный код;
+	//- `500` — внутренняя ошибка сервера.
+	var req totpConfirmation
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := m.GetUserID(r)
+	totp, err := m.Store.GetTOTP(r.Context(), userID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if totp.Confirmed {
+		http.Error(w, "two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	step, ok := totpMatch(totp.Secret, strings.TrimSpace(req.Code), time.Now())
+	if !ok {
+		http.Error(w, "invalid one-time code", http.StatusUnprocessableEntity)
+		return
+	}
+	err = m.Store.ConfirmTOTP(r.Context(), userID, step)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		// параллельно подтвержден или заменен
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed ConfirmTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	m.audit(r, totpAuditEvent(totp, models.AuditEventTOTPEnabled))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Repository) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	//- `204` — второй фактор отключен;
+	//- `401` — пользователь не авторизован;
+	//- `403` — нет кода в X-OTP-Code или код неверный;
+	//- `404` — второй фактор не включен;
+	//- `429` — слишком много неудачных попыток, повторить через Retry-After секунд;
+	//- `500` — внутренняя ошибка сервера.
+	userID := m.GetUserID(r)
+	totp, err := m.Store.GetTOTP(r.Context(), userID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// неподтвержденный секрет отключается без кода: второй фактор еще не действует
+	if totp.Confirmed && !m.checkSecondFactor(w, r, totp, r.Header.Get(OTPHeader), http.StatusForbidden) {
+		return
+	}
+
+	err = m.Store.DeleteTOTP(r.Context(), userID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed DeleteTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if totp.Confirmed {
+		m.audit(r, totpAuditEvent(totp, models.AuditEventTOTPDisabled))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireSecondFactor требует код из X-OTP-Code для списания sum баллов выше порога
+// TOTPWithdrawThreshold. Возвращает false, если ответ уже записан и операцию выполнять нельзя
+func (m *Repository) requireSecondFactor(w http.ResponseWriter, r *http.Request, userID int64, sum models.Money) bool {
+	if app.TOTPWithdrawThreshold <= 0 || sum <= app.TOTPWithdrawThreshold {
+		return true
+	}
+
+	totp, err := m.Store.GetTOTP(r.Context(), userID)
+	switch {
+	case errors.Is(err, ErrNotFound):
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if totp == nil || !totp.Confirmed {
+		http.Error(w, fmt.Sprintf("two-factor authentication is required for withdrawals above %v", app.TOTPWithdrawThreshold),
+			http.StatusForbidden)
+		return false
+	}
+
+	return m.checkSecondFactor(w, r, totp, r.Header.Get(OTPHeader), http.StatusForbidden)
+}
+
+// checkSecondFactor проверяет одноразовый код или код восстановления пользователя.
+// Неверные коды считаются неудачными попытками входа с его логином: это та же защита
+// от перебора. Если код не принят, пишет ответ (failStatus для неверного кода, `429` или `500`)
+// и возвращает false
+func (m *Repository) checkSecondFactor(w http.ResponseWriter, r *http.Request, totp *models.TOTP, code string, failStatus int) bool {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		http.Error(w, "one-time code is required", failStatus)
+		return false
+	}
+
+	ip := ClientIP(r)
+	retryAfter, err := m.loginRetryAfter(r.Context(), totp.TenantID, totp.Login, ip)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetLoginAttempts()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if retryAfter > 0 {
+		writeTooManyAttempts(w, retryAfter)
+		return false
+	}
+
+	ok, err := m.verifySecondFactor(r, totp, code)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed verifySecondFactor()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		if err := m.Store.RecordLoginFailure(r.Context(), totp.TenantID, totp.Login, ip, loginPolicy()); err != nil {
+			logger.FromContext(r.Context()).Errorln("failed RecordLoginFailure()= ", err)
+		}
+		http.Error(w, "invalid one-time code", failStatus)
+		return false
+	}
+
+	return true
+}
+
+// verifySecondFactor принимает код из приложения-генератора (каждый шаг времени
+// один раз) или неиспользованный код восстановления
+func (m *Repository) verifySecondFactor(r *http.Request, totp *models.TOTP, code string) (bool, error) {
+	if len(code) == totpDigits {
+		step, ok := totpMatch(totp.Secret, code, time.Now())
+		if !ok || step <= totp.LastStep {
+			return false, nil
+		}
+		return m.Store.UseTOTPStep(r.Context(), totp.UserID, step)
+	}
+
+	err := m.Store.UseRecoveryCode(r.Context(), totp.UserID, hashRecoveryCode(code))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	event := totpAuditEvent(totp, models.AuditEventRecoveryUsed)
+	event.Details = fmt.Sprintf("%d recovery codes left", totp.RecoveryCodesLeft-1)
+	m.audit(r, event)
+
+	return true, nil
+}
+
+// totpAuditEvent - событие второго фактора пользователя для журнала аудита
+func totpAuditEvent(totp *models.TOTP, event string) models.AuditEvent {
+	return models.AuditEvent{
+		TenantID: totp.TenantID,
+		Event:    event,
+		UserID:   totp.UserID,
+		Login:    totp.Login,
+	}
+}
+
+// totpMatch возвращает шаг времени, которому соответствует code, с допуском totpSkew шагов
+func totpMatch(secret, code string, now time.Time) (int64, bool) {
+	key, err := totpBase32.DecodeString(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current + totpSkew; step >= current-totpSkew; step-- {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// totpCode - одноразовый код шага времени step (HOTP, RFC 4226)
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// динамическое усечение: 31 бит со смещения из последних 4 бит подписи
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// totpURI - ссылка otpauth:// для QR-кода приложения-генератора
+func totpURI(issuer, login, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+login) + "?" + params.Encode()
+}
+
+// hashRecoveryCode возвращает хэш кода восстановления; код вводится так же,
+// как код сертификата, - с дефисами или без, в любом регистре
+func hashRecoveryCode(code string) string {
+	return sha256Hex(normalizeVoucherCode(code))
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"strings"
+)
+
+// transferCommentMaxLen - ограничение длины комментария к переводу (см. миграцию)
+const transferCommentMaxLen = 255
+
+func (m *Repository) PostTransfer(w http.ResponseWriter, r *http.Request) {
+	//- `200` — перевод выполнен;
+	//- `400` — неверный формат запроса, сумма меньше минимальной или перевод самому себе;
+	//- `401` — пользователь не авторизован;
+	//- `402` — на счету недостаточно средств;
+	//- `403` — для суммы выше порога нужен код второго фактора в X-OTP-Code;
+	//- `404` — получатель не найден;
+	//- `422` — превышен суточный лимит переводов;
+	//- `429` — слишком много неверных кодов второго фактора;
+	//- `500` — внутренняя ошибка сервера.
+	var transfer models.Transfer
+	if err := json.NewDecoder(r.Body).Decode(&transfer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transfer.To = strings.TrimSpace(transfer.To)
+	switch {
+	case transfer.To == "":
+		http.Error(w, "recipient login is required", http.StatusBadRequest)
+		return
+	case transfer.Sum <= 0 || transfer.Sum < app.TransferMinSum:
+		http.Error(w, "sum is less than the minimum transfer", http.StatusBadRequest)
+		return
+	case len(transfer.Comment) > transferCommentMaxLen:
+		http.Error(w, "comment is too long", http.StatusBadRequest)
+		return
+	}
+
+	authUserID := m.GetUserID(r)
+	logger.FromContext(r.Context()).Infoln(
+		"Transfer:",
+		"authUserID", authUserID,
+		"transfer.To", transfer.To,
+		"transfer.Sum", transfer.Sum,
+	)
+	// перевод уводит баллы со счета так же, как списание: порог второго фактора тот же
+	if !m.requireSecondFactor(w, r, authUserID, transfer.Sum) {
+		return
+	}
+
+	transfer.FromUserID = authUserID
+	transfer.TenantID = GetTenant(r).ID
+	transfer.Sum = models.Money(transfer.Sum.Set())
+	res, err := m.Store.Transfer(r.Context(), transfer, models.TransferPolicy{
+		DailyLimit: models.Money(app.TransferDailyLimit.Set()),
+	})
+	switch {
+	case errors.Is(err, ErrSelfTransfer):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, ErrNotEnoughMoney):
+		// `402` — на счету недостаточно средств;
+		w.WriteHeader(http.StatusPaymentRequired)
+		return
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, "recipient not found", http.StatusNotFound)
+		return
+	case errors.Is(err, ErrTransferLimit):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed Transfer()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, res, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) GetTransfers(w http.ResponseWriter, r *http.Request) {
+	//- `200` — успешная обработка запроса;
+	//- `204` — нет ни одного перевода;
+	//- `401` — пользователь не авторизован;
+	//- `500` — внутренняя ошибка сервера.
+	authUserID := m.GetUserID(r)
+	transfers, err := m.Store.GetTransfers(r.Context(), authUserID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetTransfers()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(transfers) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, transfers, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -26,6 +27,36 @@ func (m *Repository) Register(w http.ResponseWriter, r *http.Request) {
 	}
 	if user.Login == "" || user.Password == "" {
 		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// такие логины получают удаленные пользователи
+	if strings.HasPrefix(user.Login, models.DeletedLoginPrefix) {
+		http.Error(w, "login is reserved", http.StatusBadRequest)
+		return
+	}
+	// адрес необязателен, он нужен только для сброса пароля
+	if user.Email != "" && !isEmail(user.Email) {
+		http.Error(w, "invalid email", http.StatusBadRequest)
+		return
+	}
+	tenant := GetTenant(r)
+	user.TenantID = tenant.ID
+
+	// код пригласившего необязателен, но неизвестный код - ошибка клиента
+	var referrerID int64
+	if user.ReferralCode != "" {
+		user.ReferralCode = normalizeInviteCode(user.ReferralCode)
+		id, err := m.Store.GetUserIDByInviteCode(r.Context(), tenant.ID, user.ReferralCode)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			http.Error(w, "unknown referral code", http.StatusBadRequest)
+			return
+		case err != nil:
+			logger.FromContext(r.Context()).Errorln("failed GetUserIDByInviteCode()= ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		referrerID = id
 	}
 
 	// дописываем нужные значения в модель пользователя
@@ -33,10 +64,19 @@ func (m *Repository) Register(w http.ResponseWriter, r *http.Request) {
 	hash := sha256.Sum256([]byte(user.Password))
 	user.Password = hex.EncodeToString(hash[:])
 
-	// пишем в базу
-	resp, err := m.Store.CreateUser(r.Context(), user)
+	// пишем в базу; при совпадении случайного кода приглашения пробуем другой
+	var resp *models.User
+	var err error
+	for attempt := 0; attempt < inviteCodeAttempts; attempt++ {
+		if user.InviteCode, err = newInviteCode(); err != nil {
+			break
+		}
+		if resp, err = m.Store.CreateUser(r.Context(), user); !errors.Is(err, ErrInviteCodeTaken) {
+			break
+		}
+	}
 	if err != nil && !errors.Is(err, ErrDuplicate) {
-		logger.Log.Errorln("failed CreateUser()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed CreateUser()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -45,10 +85,16 @@ func (m *Repository) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if referrerID != 0 {
+		if err := m.createReferral(r.Context(), referrerID, resp.ID); err != nil {
+			logger.FromContext(r.Context()).Errorln("failed CreateReferral()= ", err)
+		}
+	}
+
 	// выставляем токен для авторизации зарегистрированного пользователя
-	token, err := BuildJWTString(resp.ID)
+	token, err := BuildJWTString(resp.ID, resp.TokenVersion, tenant)
 	if err != nil {
-		logger.Log.Errorln("failed BuildJWTString()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed BuildJWTString()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -56,15 +102,17 @@ func (m *Repository) Register(w http.ResponseWriter, r *http.Request) {
 
 	// отвечаем клиенту
 	if err := m.WriteResponseJSON(w, *resp, http.StatusOK); err != nil {
-		logger.Log.Errorln("failed WriteResponseJSON()=", err)
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
 func (m *Repository) Login(w http.ResponseWriter, r *http.Request) {
 	//- `200` — пользователь успешно аутентифицирован;
+	//- `202` — пароль верный, токен выдается после кода второго фактора (POST /api/user/login/2fa);
 	//- `400` — неверный формат запроса;
 	//- `401` — неверная пара логин/пароль;
+	//- `429` — слишком много неудачных попыток, повторить через Retry-After секунд;
 	//- `500` — внутренняя ошибка сервера.
 	var user models.User
 
@@ -76,21 +124,88 @@ func (m *Repository) Login(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	tenant := GetTenant(r)
+	user.TenantID = tenant.ID
+	ip := ClientIP(r)
+
+	// неудачи считаются для любого логина, поэтому пауза и блокировка одинаковы
+	// для существующих и несуществующих пользователей, а пароль в это время не проверяется
+	retryAfter, err := m.loginRetryAfter(r.Context(), tenant.ID, user.Login, ip)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetLoginAttempts()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		writeTooManyAttempts(w, retryAfter)
+		return
+	}
 
 	hash := sha256.Sum256([]byte(user.Password))
 	user.Password = hex.EncodeToString(hash[:])
 
 	// check auth
 	id, err := m.Store.GetIDUserByAuth(r.Context(), user)
-	if err != nil {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if err := m.Store.RecordLoginFailure(r.Context(), tenant.ID, user.Login, ip, loginPolicy()); err != nil {
+			logger.FromContext(r.Context()).Errorln("failed RecordLoginFailure()= ", err)
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		return
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed GetIDUserByAuth()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// со вторым фактором токен выдается только после кода: неудачи по логину
+	// до этого не забываются, иначе пароль открывал бы перебор кодов
+	totp, err := m.Store.GetTOTP(r.Context(), id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		logger.FromContext(r.Context()).Errorln("failed GetTOTP()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if totp != nil && totp.Confirmed {
+		mfaToken, err := buildMFAToken(id, tenant)
+		if err != nil {
+			logger.FromContext(r.Context()).Errorln("failed buildMFAToken()= ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// `202` — пароль верный, нужен код второго фактора;
+		challenge := mfaChallenge{Token: mfaToken, ExpiresIn: int(mfaTokenTTL.Seconds())}
+		if err := m.WriteResponseJSON(w, challenge, http.StatusAccepted); err != nil {
+			logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	m.completeLogin(w, r, id)
+}
+
+// completeLogin забывает неудачи по логину и выдает токен доступа
+func (m *Repository) completeLogin(w http.ResponseWriter, r *http.Request, userID int64) {
+	tenant := GetTenant(r)
+	user, err := m.Store.GetUser(r.Context(), userID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetUser()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// успешный вход забывает неудачи по логину; неудачи с IP забываются только
+	// со временем, иначе вход в свой аккаунт обнулял бы перебор чужих
+	if _, err := m.Store.ResetLoginAttempts(r.Context(), tenant.ID, models.LoginScopeLogin, user.Login); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed ResetLoginAttempts()= ", err)
 	}
 
 	// set token
-	token, err := BuildJWTString(id)
+	token, err := BuildJWTString(userID, user.TokenVersion, tenant)
 	if err != nil {
-		logger.Log.Errorln("failed BuildJWTString()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed BuildJWTString()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// voucherCodeLen - длина кода сертификата без разделителей (16 символов из 32 - 80 бит)
+const voucherCodeLen = 16
+
+// voucherBatchMaxCount - ограничение размера одного выпуска
+const voucherBatchMaxCount = 10000
+
+func (m *Repository) CreateVoucherBatch(w http.ResponseWriter, r *http.Request) {
+	//- `201` — выпуск создан, коды возвращаются один раз;
+	//- `400` — неверный формат запроса;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	var batch models.VoucherBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expiresAt, err := time.Parse(time.RFC3339, batch.ExpiresAt)
+	switch {
+	case batch.Name == "":
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	case batch.Value <= 0:
+		http.Error(w, "value must be positive", http.StatusBadRequest)
+		return
+	case batch.Count <= 0 || batch.Count > voucherBatchMaxCount:
+		http.Error(w, "count is out of range", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "expires_at must be RFC3339", http.StatusBadRequest)
+		return
+	case !expiresAt.After(time.Now()):
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	codes := make([]string, batch.Count)
+	hashes := make([]string, batch.Count)
+	for i := range codes {
+		code, err := randomCode(voucherCodeLen)
+		if err != nil {
+			logger.FromContext(r.Context()).Errorln("failed randomCode()= ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		codes[i] = formatVoucherCode(code)
+		hashes[i] = hashVoucherCode(code)
+	}
+
+	batch.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	batch.Value = models.Money(batch.Value.Set())
+	batch.TenantID = GetTenant(r).ID
+	created, err := m.Store.CreateVoucherBatch(r.Context(), batch, hashes)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed CreateVoucherBatch()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	created.Codes = codes
+	logger.FromContext(r.Context()).Infoln("Voucher batch created:", "batch.ID", created.ID, "batch.Count", created.Count)
+
+	if err := m.WriteResponseJSON(w, created, http.StatusCreated); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) GetVoucherBatches(w http.ResponseWriter, r *http.Request) {
+	//- `200` — отчет по выпускам: выпущено, погашено и сгорело;
+	//- `204` — нет выпусков;
+	//- `401` — нет доступа;
+	//- `500` — внутренняя ошибка сервера.
+	batches, err := m.Store.GetVoucherBatches(r.Context(), GetTenant(r).ID)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed GetVoucherBatches()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(batches) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := m.WriteResponseJSON(w, batches, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (m *Repository) RedeemVoucher(w http.ResponseWriter, r *http.Request) {
+	//- `200` — сертификат погашен (в том числе повторный запрос того же пользователя);
+	//- `400` — неверный формат запроса;
+	//- `401` — пользователь не авторизован;
+	//- `404` — сертификат не найден;
+	//- `409` — сертификат уже погашен другим пользователем;
+	//- `410` — срок действия сертификата истек;
+	//- `500` — внутренняя ошибка сервера.
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code := normalizeVoucherCode(req.Code)
+	if len(code) != voucherCodeLen {
+		http.Error(w, "invalid voucher code", http.StatusBadRequest)
+		return
+	}
+
+	authUserID := m.GetUserID(r)
+	voucher, err := m.Store.RedeemVoucher(r.Context(), GetTenant(r).ID, authUserID, hashVoucherCode(code))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, ErrVoucherRedeemed):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, ErrVoucherExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case errors.Is(err, ErrDuplicate):
+		logger.FromContext(r.Context()).Infoln("Voucher redemption replayed:", "authUserID", authUserID, "voucher.ID", voucher.ID)
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed RedeemVoucher()= ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	default:
+		logger.FromContext(r.Context()).Infoln("Voucher redeemed:", "authUserID", authUserID, "voucher.ID", voucher.ID, "voucher.Value", voucher.Value)
+		metrics.PointsCredited.WithLabelValues(GetTenant(r).ID).Add(float64(voucher.Value))
+	}
+	voucher.Code = formatVoucherCode(code)
+
+	if err := m.WriteResponseJSON(w, voucher, http.StatusOK); err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// normalizeVoucherCode убирает разделители и приводит код к верхнему регистру
+func normalizeVoucherCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+// formatVoucherCode разбивает код на группы по 4 символа: XXXX-XXXX-XXXX-XXXX
+func formatVoucherCode(code string) string {
+	var b strings.Builder
+	for i, c := range code {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// hashVoucherCode возвращает хэш кода, по которому сертификат ищется в хранилище
+func hashVoucherCode(code string) string {
+	return sha256Hex(normalizeVoucherCode(code))
+}
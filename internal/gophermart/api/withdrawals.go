@@ -4,23 +4,42 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
 	"net/http"
 )
 
+// idempotencyKeyMaxLen ограничивает длину заголовка Idempotency-Key
+const idempotencyKeyMaxLen = 255
+
 func (m *Repository) PostWithdrawal(w http.ResponseWriter, r *http.Request) {
-	//- `200` — успешная обработка запроса;
+	//- `200` — успешная обработка запроса (в том числе повтор с тем же Idempotency-Key или номером заказа);
+	//- `400` — неверный формат запроса;
 	//- `401` — пользователь не авторизован;
 	//- `402` — на счету недостаточно средств;
+	//- `403` — для суммы выше порога нужен код второго фактора в X-OTP-Code;
+	//- `409` — ключ идемпотентности или номер заказа уже использованы с другими данными;
 	//- `422` — неверный номер заказа;
+	//- `429` — слишком много неверных кодов второго фактора;
 	//- `500` — внутренняя ошибка сервера.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > idempotencyKeyMaxLen {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+
 	var withdrawal models.Withdrawal
 	if err := json.NewDecoder(r.Body).Decode(&withdrawal); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// неположительная сумма начислила бы баллы вместо списания
+	if withdrawal.Sum <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	logger.Log.Infoln(
+	logger.FromContext(r.Context()).Infoln(
 		"Withdrawal:",
 		"withdrawal.Order", withdrawal.Order,
 		"withdrawal.Sum", withdrawal.Sum,
@@ -35,19 +54,43 @@ func (m *Repository) PostWithdrawal(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authUserID := m.GetUserID(r)
+	sum := withdrawal.Sum
 	withdrawal.UserID = authUserID
+	withdrawal.TenantID = GetTenant(r).ID
 	withdrawal.Sum = models.Money(withdrawal.Sum.Set())
-	err := m.Store.SetWithdrawal(r.Context(), withdrawal)
-	if err != nil && !errors.Is(err, ErrNotEnoughMoney) {
-		logger.Log.Errorln("failed SetWithdrawal()= ", err)
-		// `500` — внутренняя ошибка сервера.
+	withdrawal.IdempotencyKey = idempotencyKey
+
+	// повтор выполненного списания возвращает исходный результат без нового кода
+	// второго фактора: использованный код повторно не принимается
+	done, err := m.Store.WithdrawalDone(r.Context(), withdrawal)
+	if err != nil {
+		logger.FromContext(r.Context()).Errorln("failed WithdrawalDone()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	// `402` — на счету недостаточно средств;
-	if errors.Is(err, ErrNotEnoughMoney) {
+	if !done && !m.requireSecondFactor(w, r, authUserID, sum) {
+		return
+	}
+	err = m.Store.SetWithdrawal(r.Context(), withdrawal)
+	switch {
+	case errors.Is(err, ErrNotEnoughMoney):
+		// `402` — на счету недостаточно средств;
 		w.WriteHeader(http.StatusPaymentRequired)
 		return
+	case errors.Is(err, ErrIdempotencyMismatch):
+		// `409` — ключ или номер заказа уже использованы с другими данными;
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, ErrDuplicate):
+		// повтор: списание уже выполнено, возвращаем исходный результат
+		logger.FromContext(r.Context()).Infoln("Withdrawal replayed:", "withdrawal.Order", withdrawal.Order)
+	case err != nil:
+		logger.FromContext(r.Context()).Errorln("failed SetWithdrawal()= ", err)
+		// `500` — внутренняя ошибка сервера.
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	default:
+		metrics.PointsWithdrawn.WithLabelValues(withdrawal.TenantID).Add(float64(withdrawal.Sum.Get()))
 	}
 
 	// `200` — успешная обработка запроса;
@@ -62,12 +105,12 @@ func (m *Repository) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	authUserID := m.GetUserID(r)
 	withdrawals, err := m.Store.GetWithdrawals(r.Context(), authUserID)
 	if err != nil {
-		logger.Log.Errorln("failed GetWithdrawals()= ", err)
+		logger.FromContext(r.Context()).Errorln("failed GetWithdrawals()= ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	logger.Log.Infoln("Requested by authUserID", authUserID, "|", "withdrawals", withdrawals)
+	logger.FromContext(r.Context()).Debugln("Requested by authUserID", authUserID, "|", "withdrawals", withdrawals)
 
 	// 204` — нет данных для ответа.
 	if len(withdrawals) == 0 {
@@ -76,7 +119,7 @@ func (m *Repository) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := m.WriteResponseJSON(w, withdrawals, http.StatusOK); err != nil {
-		logger.Log.Errorln("failed WriteResponseJSON()=", err)
+		logger.FromContext(r.Context()).Errorln("failed WriteResponseJSON()=", err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
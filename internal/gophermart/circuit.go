@@ -0,0 +1,132 @@
+package gophermart
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAccrualCircuitOpen - опрос accrual приостановлен после серии ошибок
+var ErrAccrualCircuitOpen = errors.New("accrual circuit is open")
+
+// состояния автомата защиты
+const (
+	circuitClosed   = "closed"    // запросы идут как обычно
+	circuitOpen     = "open"      // запросы не выполняются до конца паузы
+	circuitHalfOpen = "half-open" // пауза закончилась, пропускается один пробный запрос
+)
+
+// circuit - автомат защиты клиента accrual одного адреса: после AccrualCircuitFailures
+// ошибок подряд опрос приостанавливается на AccrualCircuitCooldown, чтобы не нагружать
+// упавшую систему расчета; затем пробный запрос решает, возобновить опрос или продлить паузу
+type circuit struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool // пробный запрос уже выполняется
+}
+
+var (
+	circuitsMu sync.Mutex
+	circuits   = make(map[string]*circuit)
+)
+
+// accrualCircuit возвращает автомат защиты адреса системы расчета
+func accrualCircuit(address string) *circuit {
+	circuitsMu.Lock()
+	defer circuitsMu.Unlock()
+
+	c, ok := circuits[address]
+	if !ok {
+		c = &circuit{}
+		circuits[address] = c
+	}
+
+	return c
+}
+
+// accrualCircuitStates возвращает состояния автоматов защиты по адресам систем расчета
+func accrualCircuitStates(now time.Time) map[string]string {
+	circuitsMu.Lock()
+	defer circuitsMu.Unlock()
+
+	states := make(map[string]string, len(circuits))
+	for address, c := range circuits {
+		states[address] = c.State(now)
+	}
+
+	return states
+}
+
+// Allow сообщает, можно ли выполнить запрос
+func (c *circuit) Allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state(now) {
+	case circuitOpen:
+		return false
+	case circuitHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+	}
+
+	return true
+}
+
+// Success закрывает автомат: система расчета отвечает
+func (c *circuit) Success() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.failures = 0
+	c.openUntil = time.Time{}
+	c.probing = false
+}
+
+// Failure учитывает ошибку; неудачный пробный запрос сразу продлевает паузу
+func (c *circuit) Failure(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if app.AccrualCircuitFailures <= 0 {
+		return
+	}
+	c.failures++
+	if c.probing || c.failures >= app.AccrualCircuitFailures {
+		c.openUntil = now.Add(app.AccrualCircuitCooldown)
+	}
+	c.probing = false
+}
+
+// RetryAt возвращает, когда автомат пропустит следующий запрос
+func (c *circuit) RetryAt(now time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state(now) == circuitOpen {
+		return c.openUntil
+	}
+	// пауза закончилась, но пробный запрос еще выполняется
+	return now.Add(circuitOpenBackoff)
+}
+
+func (c *circuit) State(now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state(now)
+}
+
+func (c *circuit) state(now time.Time) string {
+	switch {
+	case c.openUntil.IsZero():
+		return circuitClosed
+	case now.Before(c.openUntil):
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
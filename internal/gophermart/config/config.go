@@ -1,11 +1,118 @@
 package config
 
+import (
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
 type AppConfig struct {
 	ServerAddress        string
+	AdminAddress         string // служебный адрес метрик и проб (пустой - отключен)
 	StoreDriver          string
 	StoreDatabaseURI     string
 	SecretKey            string
 	TokenExp             int
 	AccrualSystemAddress string
 	AccrualPollInterval  int
+
+	// автомат защиты клиента accrual: ошибок подряд до паузы опроса (0 - отключен) и ее длительность
+	AccrualCircuitFailures int
+	AccrualCircuitCooldown time.Duration
+
+	// готовность к трафику: доля заполнения очереди accrual, при которой реплика
+	// перестает быть готовой (0 - не проверяется), и пауза перед остановкой сервера,
+	// за которую оркестратор успевает снять с нее трафик
+	ReadyQueueSaturation float64
+	ShutdownDrain        time.Duration
+
+	// пул соединений хранилища
+	StoreMaxConns               int32
+	StoreMinConns               int32
+	StoreMaxConnLifetime        time.Duration
+	StoreMaxConnIdleTime        time.Duration
+	StoreStatementCacheCapacity int
+
+	// реплики только для чтения; при отставании больше StoreReplicaMaxLag
+	// чтение уходит на основной сервер
+	StoreReplicaURIs   []string
+	StoreReplicaMaxLag time.Duration
+
+	// доступ к служебным API программы по умолчанию: пустой токен или список ключей
+	// отключает соответствующий API (у остальных программ свои, см. models.Tenant)
+	AdminToken      string
+	MerchantAPIKeys []string
+
+	// резервы баллов: срок жизни неподтвержденного резерва и период фоновой очистки
+	HoldTTL           time.Duration
+	HoldSweepInterval time.Duration
+
+	// сгорание баллов: срок жизни партии в месяцах (0 - баллы не сгорают),
+	// за сколько дней предупреждать о сгорании и период фоновой очистки
+	PointsTTLMonths           int
+	PointsExpiryNoticeDays    int
+	PointsExpirySweepInterval time.Duration
+
+	// уровни лояльности по возрастанию порога (пустой список - уровней нет),
+	// по чему считается сумма, ее скользящее окно в месяцах и период пересчета
+	LoyaltyTiers              []models.Tier
+	LoyaltyTierBasis          models.TierBasis
+	LoyaltyTierWindowMonths   int
+	LoyaltyTierRecalcInterval time.Duration
+
+	// реферальная программа: бонусы в баллах пригласившему и приглашенному,
+	// лимиты награждений на пригласившего всего и новых приглашений за сутки (0 - без ограничения)
+	ReferralReferrerReward models.Money
+	ReferralRefereeReward  models.Money
+	ReferralMaxRewards     int
+	ReferralDailyLimit     int
+
+	// переводы баллов между пользователями: минимальная сумма и лимит
+	// отправителя за сутки в баллах (0 - без ограничения)
+	TransferMinSum     models.Money
+	TransferDailyLimit models.Money
+
+	// программы лояльности; первая - программа по умолчанию с общими
+	// ключом подписи и адресом системы начислений
+	Tenants []models.Tenant
+
+	// ограничение частоты запросов по IP и пользователю для групп маршрутов;
+	// общие корзины в хранилище нужны, когда реплик приложения несколько,
+	// X-Forwarded-For учитывается (и при защите входа) только за доверенным прокси
+	RateLimits             []models.RateLimit
+	RateLimitShared        bool
+	RateLimitTrustProxy    bool
+	RateLimitSweepInterval time.Duration
+
+	// защита входа от перебора паролей: неудачи до блокировки по логину и с одного IP
+	// (0 - без блокировки), окно, за которое они считаются, срок блокировки и пауза
+	// после первой неудачи, которая удваивается с каждой следующей до максимальной
+	LoginMaxFailures   int
+	LoginMaxIPFailures int
+	LoginFailureWindow time.Duration
+	LoginLockout       time.Duration
+	LoginDelay         time.Duration
+	LoginMaxDelay      time.Duration
+
+	// второй фактор TOTP: издатель в приложении-генераторе и сумма списания в баллах,
+	// выше которой нужен одноразовый код (0 - код не требуется)
+	TOTPIssuer            string
+	TOTPWithdrawThreshold models.Money
+
+	// уведомления пользователям (log или file - для локального запуска) и срок
+	// действия токена сброса пароля
+	Notifier         string
+	NotifierFile     string
+	PasswordResetTTL time.Duration
+
+	// журнал: начальный уровень (меняется на лету через служебный адрес), формат
+	// (json или console) и маскировка секретов, паролей DSN, токенов и логинов
+	LogLevel  string
+	LogFormat string
+	LogRedact bool
+
+	// трассировка: экспортер спанов (none, stdout или otlp), адрес OTLP-коллектора
+	// и доля трассируемых запросов без входящего контекста трассировки
+	TraceExporter    string
+	TraceEndpoint    string
+	TraceSampleRatio float64
 }
@@ -0,0 +1,146 @@
+package gophermart
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/migrate"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// readyTimeout ограничивает проверки зависимостей одной пробы
+const readyTimeout = 2 * time.Second
+
+// статусы проверок
+const (
+	healthOK       = "ok"
+	healthFail     = "fail"
+	healthDegraded = "degraded" // работает с ограничениями, но трафик принимать может
+)
+
+// healthCheck - результат проверки одной зависимости
+type healthCheck struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+// healthReport - ответ пробы
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// probes - зависимости, которые проверяет /readyz; заполняются в Setup
+var probes struct {
+	store    store.Repositories
+	migrator *migrate.Migrator
+	started  atomic.Bool // хранилище открыто, миграции применены
+	draining atomic.Bool // идет остановка, новый трафик не принимается
+}
+
+// Drain переводит /readyz в состояние отказа и ждет app.ShutdownDrain,
+// чтобы оркестратор успел снять трафик до остановки сервера
+func Drain() {
+	probes.draining.Store(true)
+	logger.Log.Infof("Draining for %s before shutdown", app.ShutdownDrain)
+	time.Sleep(app.ShutdownDrain)
+}
+
+// Healthz - проба живости: процесс отвечает. Зависимости не проверяются,
+// иначе сбой базы приводил бы к перезапуску всех реплик
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthReport{Status: healthOK})
+}
+
+// Readyz - проба готовности: `200`, если реплика может обслуживать запросы, иначе `503`
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{Status: healthOK, Checks: make(map[string]healthCheck)}
+	switch {
+	case probes.draining.Load():
+		report.Checks["shutdown"] = healthCheck{Status: healthFail, Error: "shutting down"}
+	case !probes.started.Load():
+		report.Checks["startup"] = healthCheck{Status: healthFail, Error: "starting"}
+	default:
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		report.Checks["database"] = checkDatabase(ctx)
+		report.Checks["migrations"] = checkMigrations(ctx)
+		report.Checks["accrual"] = checkAccrual()
+		report.Checks["queue"] = checkQueue()
+	}
+
+	for _, check := range report.Checks {
+		if check.Status == healthFail {
+			report.Status = healthFail
+		}
+	}
+	writeHealth(w, report)
+}
+
+func checkDatabase(ctx context.Context) healthCheck {
+	if err := probes.store.Ping(ctx); err != nil {
+		return healthCheck{Status: healthFail, Error: err.Error()}
+	}
+
+	return healthCheck{Status: healthOK}
+}
+
+// checkMigrations не пропускает трафик на схему, которую откатили или
+// еще не обновили до версии кода
+func checkMigrations(ctx context.Context) healthCheck {
+	pending, err := probes.migrator.Pending(ctx)
+	if err != nil {
+		return healthCheck{Status: healthFail, Error: err.Error()}
+	}
+	details := map[string]int{"pending": pending}
+	if pending > 0 {
+		return healthCheck{Status: healthFail, Error: "pending migrations", Details: details}
+	}
+
+	return healthCheck{Status: healthOK, Details: details}
+}
+
+// checkAccrual сообщает о приостановленном опросе accrual, но готовность не снимает:
+// API пользователей работает и без системы расчета, а заказы дождутся ее в очереди
+func checkAccrual() healthCheck {
+	states := accrualCircuitStates(time.Now())
+	for _, state := range states {
+		if state != circuitClosed {
+			return healthCheck{Status: healthDegraded, Details: states}
+		}
+	}
+
+	return healthCheck{Status: healthOK, Details: states}
+}
+
+// checkQueue снимает готовность, пока очередь опроса accrual почти заполнена:
+// новые заказы блокировали бы запросы в ожидании места
+func checkQueue() healthCheck {
+	depth, capacity := len(api.Repo.Jobs), cap(api.Repo.Jobs)
+	details := map[string]int{"depth": depth, "capacity": capacity}
+	if app.ReadyQueueSaturation > 0 && float64(depth) >= app.ReadyQueueSaturation*float64(capacity) {
+		return healthCheck{Status: healthFail, Error: "accrual queue is saturated", Details: details}
+	}
+
+	return healthCheck{Status: healthOK, Details: details}
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport) {
+	status := http.StatusOK
+	if report.Status != healthOK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Log.Errorln("failed Encode()=", err)
+	}
+}
@@ -0,0 +1,34 @@
+package gophermart
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"sync"
+	"time"
+)
+
+// ExpireHolds периодически возвращает на баланс баллы из просроченных резервов
+func ExpireHolds(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	logger.Log.Infoln("Starting holds sweeper")
+	ticker := time.NewTicker(app.HoldSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := api.Repo.Store.ExpireHolds(ctx)
+			if err != nil {
+				logger.Log.Errorln("failed ExpireHolds()=", err)
+				continue
+			}
+			if expired > 0 {
+				logger.Log.Infoln("Expired holds:", expired)
+			}
+		}
+	}
+}
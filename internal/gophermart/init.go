@@ -2,30 +2,94 @@ package gophermart
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/middleware"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/notifier"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/pg"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/sqlite"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/traced"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/tracing"
 	"log"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var app config.AppConfig
 
-func Setup(ctx context.Context) (*string, error) {
+// Configure читает флаги и переменные окружения и инициализирует логер
+func Configure() error {
 	// flags:
 	serverAddress := flag.String("a", "localhost:8080", "gophermart server address")
-	storeDriver := flag.String("s", "postgresql", "gophermart store driver")
+	adminAddress := flag.String("admin-address", "localhost:9090", "internal address for metrics, health probes and log level (empty - disabled)")
+	storeDriver := flag.String("s", "postgresql", "gophermart store driver (postgresql, sqlite)")
 	databaseURI := flag.String("d", "", "database uri")
 	secretKey := flag.String("k", "", "secret key")
 	tokenExp := flag.Int("t", 2, "token exp (hour)")
 	accrualSystemAddress := flag.String("r", "localhost:8181", "accrual system address")
 	accrualPollInterval := flag.Int("i", 1, "accrual poll interval (sec)")
+	accrualCircuitFailures := flag.Int("accrual-circuit-failures", 5, "consecutive accrual failures that pause polling (0 - never pause)")
+	accrualCircuitCooldown := flag.Duration("accrual-circuit-cooldown", 30*time.Second, "accrual polling pause after consecutive failures")
+	readyQueueSaturation := flag.Float64("ready-queue-saturation", 0.9, "accrual queue fill ratio at which the instance reports not ready (0 - not checked)")
+	shutdownDrain := flag.Duration("shutdown-drain", 5*time.Second, "delay between failing readiness and stopping the server on shutdown")
+	storeMaxConns := flag.Int("db-max-conns", 10, "database pool max connections")
+	storeMinConns := flag.Int("db-min-conns", 2, "database pool min connections")
+	storeMaxConnLifetime := flag.Duration("db-max-conn-lifetime", time.Hour, "database connection max lifetime")
+	storeMaxConnIdleTime := flag.Duration("db-max-conn-idle-time", 30*time.Minute, "database connection max idle time")
+	storeStatementCacheCapacity := flag.Int("db-statement-cache", 512, "prepared statement cache capacity per connection (0 - disabled)")
+	storeReplicaURIs := flag.String("db-replicas", "", "comma-separated read replica database uris")
+	storeReplicaMaxLag := flag.Duration("db-replica-max-lag", 5*time.Second, "max replica lag before reads fall back to primary")
+	adminToken := flag.String("admin-token", "", "admin API token")
+	merchantAPIKeys := flag.String("merchant-api-keys", "", "comma-separated merchant API keys")
+	holdTTL := flag.Duration("hold-ttl", 15*time.Minute, "points hold lifetime before it expires")
+	holdSweepInterval := flag.Duration("hold-sweep-interval", time.Minute, "expired holds sweep interval")
+	pointsTTLMonths := flag.Int("points-ttl-months", 0, "months after which earned points expire (0 - never)")
+	pointsExpiryNoticeDays := flag.Int("points-expiry-notice-days", 30, "days ahead to report expiring points")
+	pointsExpirySweepInterval := flag.Duration("points-expiry-sweep-interval", time.Hour, "expired points sweep interval")
+	loyaltyTiers := flag.String("loyalty-tiers", "", "comma-separated loyalty tiers name:threshold:multiplier[:bonus], e.g. silver:1000:1.1,gold:5000:1.25")
+	loyaltyTierBasis := flag.String("loyalty-tier-basis", string(models.TierBasisAccrued), "loyalty tier basis (accrued, spent)")
+	loyaltyTierWindowMonths := flag.Int("loyalty-tier-window-months", 12, "loyalty tier rolling window (months)")
+	loyaltyTierRecalcInterval := flag.Duration("loyalty-tier-recalc-interval", time.Hour, "loyalty tiers recalculation interval")
+	referralReferrerReward := flag.Float64("referral-referrer-reward", 100, "points for the referrer after the referee's first processed order")
+	referralRefereeReward := flag.Float64("referral-referee-reward", 50, "points for the referee after their first processed order")
+	referralMaxRewards := flag.Int("referral-max-rewards", 20, "max rewarded referrals per referrer (0 - unlimited)")
+	referralDailyLimit := flag.Int("referral-daily-limit", 5, "max referrals per referrer per 24h (0 - unlimited)")
+	transferMinSum := flag.Float64("transfer-min-sum", 1, "min points per transfer to another user")
+	transferDailyLimit := flag.Float64("transfer-daily-limit", 10000, "max points transferred by a user per 24h (0 - unlimited)")
+	tenantsFile := flag.String("tenants-file", "", "JSON file with additional loyalty programs (tenants)")
+	rateLimits := flag.String("rate-limits", "register=5/1m,login=10/1m,orders=60/1m,withdraw=30/1m",
+		"comma-separated rate limits route=requests/period[:burst], routes: "+strings.Join(models.RateLimitRoutes, ", "))
+	rateLimitShared := flag.Bool("rate-limit-shared", false, "keep rate limit buckets in the database shared by all replicas (postgresql only)")
+	rateLimitTrustProxy := flag.Bool("rate-limit-trust-proxy", false, "take client IP from X-Forwarded-For set by a trusted proxy")
+	rateLimitSweepInterval := flag.Duration("rate-limit-sweep-interval", time.Minute, "idle rate limit buckets sweep interval")
+	loginMaxFailures := flag.Int("login-max-failures", 5, "failed logins per login before lockout (0 - no lockout)")
+	loginMaxIPFailures := flag.Int("login-max-ip-failures", 50, "failed logins from one IP before lockout (0 - no lockout)")
+	loginFailureWindow := flag.Duration("login-failure-window", 15*time.Minute, "window in which failed logins are counted")
+	loginLockout := flag.Duration("login-lockout", 15*time.Minute, "login lockout duration")
+	loginDelay := flag.Duration("login-delay", time.Second, "delay before the next login after a failure, doubled on each failure")
+	loginMaxDelay := flag.Duration("login-max-delay", 30*time.Second, "max delay before the next login after a failure")
+	totpIssuer := flag.String("totp-issuer", "Gophermart", "issuer shown in TOTP authenticator apps")
+	totpWithdrawThreshold := flag.Float64("totp-withdraw-threshold", 0, "withdrawals above this sum require a TOTP code (0 - never)")
+	notifierKind := flag.String("notifier", "log", "user notifications delivery: log or file")
+	notifierFile := flag.String("notifier-file", "notifications.jsonl", "file for user notifications (notifier=file)")
+	passwordResetTTL := flag.Duration("password-reset-ttl", 30*time.Minute, "password reset token lifetime")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn, error")
+	logFormat := flag.String("log-format", logger.FormatJSON, "log format: json or console")
+	logRedact := flag.Bool("log-redact", true, "mask secrets, DSN passwords, tokens and logins in logs")
+	traceExporter := flag.String("trace-exporter", tracing.ExporterNone, "trace spans exporter: none, stdout or otlp")
+	traceEndpoint := flag.String("trace-endpoint", "", "OTLP/HTTP collector url, e.g. http://localhost:4318 (empty - from OTEL_EXPORTER_OTLP_* envs)")
+	traceSampleRatio := flag.Float64("trace-sample-ratio", 1, "share of traced requests that arrive without a trace context")
 
 	flag.Parse()
 
@@ -33,6 +97,9 @@ func Setup(ctx context.Context) (*string, error) {
 	if envRunAddr := os.Getenv("RUN_ADDRESS"); envRunAddr != "" {
 		serverAddress = &envRunAddr
 	}
+	if envAdminAddress, ok := os.LookupEnv("ADMIN_ADDRESS"); ok {
+		adminAddress = &envAdminAddress
+	}
 	if envStoreDriver := os.Getenv("STORE_DRIVER"); envStoreDriver != "" {
 		storeDriver = &envStoreDriver
 	}
@@ -59,21 +126,189 @@ func Setup(ctx context.Context) (*string, error) {
 		}
 		accrualPollInterval = &pi
 	}
+	envInt("ACCRUAL_CIRCUIT_FAILURES", accrualCircuitFailures)
+	envDuration("ACCRUAL_CIRCUIT_COOLDOWN", accrualCircuitCooldown)
+	envFloat("READY_QUEUE_SATURATION", readyQueueSaturation)
+	envDuration("SHUTDOWN_DRAIN", shutdownDrain)
+	envInt("DATABASE_MAX_CONNS", storeMaxConns)
+	envInt("DATABASE_MIN_CONNS", storeMinConns)
+	envDuration("DATABASE_MAX_CONN_LIFETIME", storeMaxConnLifetime)
+	envDuration("DATABASE_MAX_CONN_IDLE_TIME", storeMaxConnIdleTime)
+	envInt("DATABASE_STATEMENT_CACHE", storeStatementCacheCapacity)
+	if envStoreReplicaURIs := os.Getenv("DATABASE_REPLICA_URIS"); envStoreReplicaURIs != "" {
+		storeReplicaURIs = &envStoreReplicaURIs
+	}
+	envDuration("DATABASE_REPLICA_MAX_LAG", storeReplicaMaxLag)
+	if envAdminToken := os.Getenv("ADMIN_TOKEN"); envAdminToken != "" {
+		adminToken = &envAdminToken
+	}
+	if envMerchantAPIKeys := os.Getenv("MERCHANT_API_KEYS"); envMerchantAPIKeys != "" {
+		merchantAPIKeys = &envMerchantAPIKeys
+	}
+	envDuration("HOLD_TTL", holdTTL)
+	envDuration("HOLD_SWEEP_INTERVAL", holdSweepInterval)
+	envInt("POINTS_TTL_MONTHS", pointsTTLMonths)
+	envInt("POINTS_EXPIRY_NOTICE_DAYS", pointsExpiryNoticeDays)
+	envDuration("POINTS_EXPIRY_SWEEP_INTERVAL", pointsExpirySweepInterval)
+	if envLoyaltyTiers := os.Getenv("LOYALTY_TIERS"); envLoyaltyTiers != "" {
+		loyaltyTiers = &envLoyaltyTiers
+	}
+	if envLoyaltyTierBasis := os.Getenv("LOYALTY_TIER_BASIS"); envLoyaltyTierBasis != "" {
+		loyaltyTierBasis = &envLoyaltyTierBasis
+	}
+	envInt("LOYALTY_TIER_WINDOW_MONTHS", loyaltyTierWindowMonths)
+	envDuration("LOYALTY_TIER_RECALC_INTERVAL", loyaltyTierRecalcInterval)
+	envFloat("REFERRAL_REFERRER_REWARD", referralReferrerReward)
+	envFloat("REFERRAL_REFEREE_REWARD", referralRefereeReward)
+	envInt("REFERRAL_MAX_REWARDS", referralMaxRewards)
+	envInt("REFERRAL_DAILY_LIMIT", referralDailyLimit)
+	envFloat("TRANSFER_MIN_SUM", transferMinSum)
+	envFloat("TRANSFER_DAILY_LIMIT", transferDailyLimit)
+	if envTenantsFile := os.Getenv("TENANTS_FILE"); envTenantsFile != "" {
+		tenantsFile = &envTenantsFile
+	}
+	if envRateLimits, ok := os.LookupEnv("RATE_LIMITS"); ok {
+		rateLimits = &envRateLimits
+	}
+	envBool("RATE_LIMIT_SHARED", rateLimitShared)
+	envBool("RATE_LIMIT_TRUST_PROXY", rateLimitTrustProxy)
+	envDuration("RATE_LIMIT_SWEEP_INTERVAL", rateLimitSweepInterval)
+	envInt("LOGIN_MAX_FAILURES", loginMaxFailures)
+	envInt("LOGIN_MAX_IP_FAILURES", loginMaxIPFailures)
+	envDuration("LOGIN_FAILURE_WINDOW", loginFailureWindow)
+	envDuration("LOGIN_LOCKOUT", loginLockout)
+	envDuration("LOGIN_DELAY", loginDelay)
+	envDuration("LOGIN_MAX_DELAY", loginMaxDelay)
+	if envTOTPIssuer := os.Getenv("TOTP_ISSUER"); envTOTPIssuer != "" {
+		totpIssuer = &envTOTPIssuer
+	}
+	envFloat("TOTP_WITHDRAW_THRESHOLD", totpWithdrawThreshold)
+	if envNotifier := os.Getenv("NOTIFIER"); envNotifier != "" {
+		notifierKind = &envNotifier
+	}
+	if envNotifierFile := os.Getenv("NOTIFIER_FILE"); envNotifierFile != "" {
+		notifierFile = &envNotifierFile
+	}
+	envDuration("PASSWORD_RESET_TTL", passwordResetTTL)
+	if envLogLevel := os.Getenv("LOG_LEVEL"); envLogLevel != "" {
+		logLevel = &envLogLevel
+	}
+	if envLogFormat := os.Getenv("LOG_FORMAT"); envLogFormat != "" {
+		logFormat = &envLogFormat
+	}
+	envBool("LOG_REDACT", logRedact)
+	if envTraceExporter := os.Getenv("TRACE_EXPORTER"); envTraceExporter != "" {
+		traceExporter = &envTraceExporter
+	}
+	if envTraceEndpoint := os.Getenv("TRACE_ENDPOINT"); envTraceEndpoint != "" {
+		traceEndpoint = &envTraceEndpoint
+	}
+	envFloat("TRACE_SAMPLE_RATIO", traceSampleRatio)
+	tiers, err := parseTiers(*loyaltyTiers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch models.TierBasis(*loyaltyTierBasis) {
+	case models.TierBasisAccrued, models.TierBasisSpent:
+	default:
+		log.Fatalf("unknown loyalty tier basis %q", *loyaltyTierBasis)
+	}
+	limits, err := parseRateLimits(*rateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tenants, err := loadTenants(*tenantsFile, models.Tenant{
+		ID:                   models.DefaultTenantID,
+		AccrualSystemAddress: URL(*accrualSystemAddress),
+		SecretKey:            *secretKey,
+		AdminToken:           *adminToken,
+		MerchantAPIKeys:      splitList(*merchantAPIKeys),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// init logger:
-	if err := logger.Initialize("info"); err != nil {
-		return nil, err
+	if err := logger.Initialize(*logLevel, *logFormat, *logRedact); err != nil {
+		return err
 	}
 
 	// config:
 	a := config.AppConfig{
 		ServerAddress:        *serverAddress,
+		AdminAddress:         *adminAddress,
 		StoreDriver:          *storeDriver,
 		StoreDatabaseURI:     *databaseURI,
 		SecretKey:            *secretKey,
 		TokenExp:             *tokenExp,
 		AccrualSystemAddress: URL(*accrualSystemAddress),
 		AccrualPollInterval:  *accrualPollInterval,
+
+		AccrualCircuitFailures: *accrualCircuitFailures,
+		AccrualCircuitCooldown: *accrualCircuitCooldown,
+
+		ReadyQueueSaturation: *readyQueueSaturation,
+		ShutdownDrain:        *shutdownDrain,
+
+		StoreMaxConns:               int32(*storeMaxConns),
+		StoreMinConns:               int32(*storeMinConns),
+		StoreMaxConnLifetime:        *storeMaxConnLifetime,
+		StoreMaxConnIdleTime:        *storeMaxConnIdleTime,
+		StoreStatementCacheCapacity: *storeStatementCacheCapacity,
+		StoreReplicaURIs:            splitList(*storeReplicaURIs),
+		StoreReplicaMaxLag:          *storeReplicaMaxLag,
+
+		AdminToken:      *adminToken,
+		MerchantAPIKeys: splitList(*merchantAPIKeys),
+
+		HoldTTL:           *holdTTL,
+		HoldSweepInterval: *holdSweepInterval,
+
+		PointsTTLMonths:           *pointsTTLMonths,
+		PointsExpiryNoticeDays:    *pointsExpiryNoticeDays,
+		PointsExpirySweepInterval: *pointsExpirySweepInterval,
+
+		LoyaltyTiers:              tiers,
+		LoyaltyTierBasis:          models.TierBasis(*loyaltyTierBasis),
+		LoyaltyTierWindowMonths:   *loyaltyTierWindowMonths,
+		LoyaltyTierRecalcInterval: *loyaltyTierRecalcInterval,
+
+		ReferralReferrerReward: models.Money(*referralReferrerReward),
+		ReferralRefereeReward:  models.Money(*referralRefereeReward),
+		ReferralMaxRewards:     *referralMaxRewards,
+		ReferralDailyLimit:     *referralDailyLimit,
+
+		TransferMinSum:     models.Money(*transferMinSum),
+		TransferDailyLimit: models.Money(*transferDailyLimit),
+
+		Tenants: tenants,
+
+		RateLimits:             limits,
+		RateLimitShared:        *rateLimitShared,
+		RateLimitTrustProxy:    *rateLimitTrustProxy,
+		RateLimitSweepInterval: *rateLimitSweepInterval,
+
+		LoginMaxFailures:   *loginMaxFailures,
+		LoginMaxIPFailures: *loginMaxIPFailures,
+		LoginFailureWindow: *loginFailureWindow,
+		LoginLockout:       *loginLockout,
+		LoginDelay:         *loginDelay,
+		LoginMaxDelay:      *loginMaxDelay,
+
+		TOTPIssuer:            *totpIssuer,
+		TOTPWithdrawThreshold: models.Money(*totpWithdrawThreshold),
+
+		Notifier:         *notifierKind,
+		NotifierFile:     *notifierFile,
+		PasswordResetTTL: *passwordResetTTL,
+
+		LogLevel:  *logLevel,
+		LogFormat: *logFormat,
+		LogRedact: *logRedact,
+
+		TraceExporter:    *traceExporter,
+		TraceEndpoint:    *traceEndpoint,
+		TraceSampleRatio: *traceSampleRatio,
 	}
 	app = a
 
@@ -81,31 +316,365 @@ func Setup(ctx context.Context) (*string, error) {
 	logger.Log.Infoln(
 		"Starting configuration:",
 		"RUN_ADDRESS", app.ServerAddress,
+		"ADMIN_ADDRESS", app.AdminAddress,
 		"STORE_DRIVER", app.StoreDriver,
-		"DATABASE_URI", app.StoreDatabaseURI,
-		"SECRET_KEY", app.SecretKey,
+		"DATABASE_URI", logger.DSN(app.StoreDatabaseURI),
+		"SECRET_KEY", logger.Secret(app.SecretKey),
 		"TOKEN_EXP", app.TokenExp,
 		"ACCRUAL_SYSTEM_ADDRESS", app.AccrualSystemAddress,
 		"ACCRUAL_POLL_INTERVAL", app.AccrualPollInterval,
+		"ACCRUAL_CIRCUIT_FAILURES", app.AccrualCircuitFailures,
+		"ACCRUAL_CIRCUIT_COOLDOWN", app.AccrualCircuitCooldown,
+		"READY_QUEUE_SATURATION", app.ReadyQueueSaturation,
+		"SHUTDOWN_DRAIN", app.ShutdownDrain,
+		"DATABASE_MAX_CONNS", app.StoreMaxConns,
+		"DATABASE_MIN_CONNS", app.StoreMinConns,
+		"DATABASE_MAX_CONN_LIFETIME", app.StoreMaxConnLifetime,
+		"DATABASE_MAX_CONN_IDLE_TIME", app.StoreMaxConnIdleTime,
+		"DATABASE_STATEMENT_CACHE", app.StoreStatementCacheCapacity,
+		"DATABASE_REPLICA_URIS", len(app.StoreReplicaURIs),
+		"DATABASE_REPLICA_MAX_LAG", app.StoreReplicaMaxLag,
+		"ADMIN_TOKEN", app.AdminToken != "",
+		"MERCHANT_API_KEYS", len(app.MerchantAPIKeys),
+		"HOLD_TTL", app.HoldTTL,
+		"HOLD_SWEEP_INTERVAL", app.HoldSweepInterval,
+		"POINTS_TTL_MONTHS", app.PointsTTLMonths,
+		"POINTS_EXPIRY_NOTICE_DAYS", app.PointsExpiryNoticeDays,
+		"POINTS_EXPIRY_SWEEP_INTERVAL", app.PointsExpirySweepInterval,
+		"LOYALTY_TIERS", *loyaltyTiers,
+		"LOYALTY_TIER_BASIS", app.LoyaltyTierBasis,
+		"LOYALTY_TIER_WINDOW_MONTHS", app.LoyaltyTierWindowMonths,
+		"LOYALTY_TIER_RECALC_INTERVAL", app.LoyaltyTierRecalcInterval,
+		"REFERRAL_REFERRER_REWARD", app.ReferralReferrerReward,
+		"REFERRAL_REFEREE_REWARD", app.ReferralRefereeReward,
+		"REFERRAL_MAX_REWARDS", app.ReferralMaxRewards,
+		"REFERRAL_DAILY_LIMIT", app.ReferralDailyLimit,
+		"TRANSFER_MIN_SUM", app.TransferMinSum,
+		"TRANSFER_DAILY_LIMIT", app.TransferDailyLimit,
+		"TENANTS", len(app.Tenants),
+		"RATE_LIMITS", *rateLimits,
+		"RATE_LIMIT_SHARED", app.RateLimitShared,
+		"RATE_LIMIT_TRUST_PROXY", app.RateLimitTrustProxy,
+		"RATE_LIMIT_SWEEP_INTERVAL", app.RateLimitSweepInterval,
+		"LOGIN_MAX_FAILURES", app.LoginMaxFailures,
+		"LOGIN_MAX_IP_FAILURES", app.LoginMaxIPFailures,
+		"LOGIN_FAILURE_WINDOW", app.LoginFailureWindow,
+		"LOGIN_LOCKOUT", app.LoginLockout,
+		"LOGIN_DELAY", app.LoginDelay,
+		"LOGIN_MAX_DELAY", app.LoginMaxDelay,
+		"TOTP_ISSUER", app.TOTPIssuer,
+		"TOTP_WITHDRAW_THRESHOLD", app.TOTPWithdrawThreshold,
+		"NOTIFIER", app.Notifier,
+		"NOTIFIER_FILE", app.NotifierFile,
+		"PASSWORD_RESET_TTL", app.PasswordResetTTL,
+		"LOG_LEVEL", app.LogLevel,
+		"LOG_FORMAT", app.LogFormat,
+		"LOG_REDACT", app.LogRedact,
+		"TRACE_EXPORTER", app.TraceExporter,
+		"TRACE_ENDPOINT", app.TraceEndpoint,
+		"TRACE_SAMPLE_RATIO", app.TraceSampleRatio,
 	)
 
+	return nil
+}
+
+// Setup инициализирует хранилище и хендлеры, вызывается после Configure
+func Setup(ctx context.Context) (*string, error) {
 	// init store:
+	raw := NewStore()
+	if err := raw.Initialize(ctx, app); err != nil {
+		return nil, err
+	}
+	// каждый вызов хранилища из хендлеров и фоновых задач - спан трассировки
+	var db store.Repositories = traced.New(raw, app.StoreDriver)
+
+	// статистика пула доступна в метриках на служебном адресе
+	metrics.RegisterStore(db.Stats)
+
+	// init app:
+	repo := api.NewRepo(db)
+	switch app.Notifier {
+	case "log":
+		repo.Notifier = notifier.Log{}
+	case "file":
+		repo.Notifier = &notifier.File{Path: app.NotifierFile}
+	default:
+		return nil, fmt.Errorf("unknown notifier %s", app.Notifier)
+	}
+	api.NewHandlers(repo, &app)
+	metrics.RegisterQueue(
+		func() int { return len(repo.Jobs) },
+		func() int { return cap(repo.Jobs) },
+	)
+
+	// ограничение частоты запросов: общие корзины поддерживает не каждое хранилище
+	var limiter middleware.Limiter = middleware.NewMemoryLimiter()
+	if app.RateLimitShared {
+		shared, ok := raw.(store.RateLimits)
+		if !ok {
+			return nil, fmt.Errorf("store driver %s does not support shared rate limits", app.StoreDriver)
+		}
+		limiter = &middleware.StoreLimiter{Store: shared}
+	}
+	middleware.SetRateLimiter(limiter, app.RateLimits)
+
+	// с этого момента /readyz проверяет зависимости
+	migrator, err := db.Migrator()
+	if err != nil {
+		return nil, err
+	}
+	probes.store = db
+	probes.migrator = migrator
+	probes.started.Store(true)
+
+	return &app.ServerAddress, nil
+}
+
+// Tracing настраивает экспорт трассировки; возвращает функцию, отправляющую
+// оставшиеся спаны при остановке
+func Tracing(ctx context.Context) (func(context.Context) error, error) {
+	return tracing.Initialize(ctx, app.TraceExporter, app.TraceEndpoint, app.TraceSampleRatio)
+}
+
+// AdminAddress возвращает служебный адрес метрик; пустой - служебный адрес отключен
+func AdminAddress() string {
+	return app.AdminAddress
+}
+
+// NewStore возвращает хранилище для app.StoreDriver
+func NewStore() store.Repositories {
 	var db store.Repositories
 	switch app.StoreDriver {
 	case "postgresql", "postgres":
 		db = &pg.Store{}
+	case "sqlite", "sqlite3":
+		db = &sqlite.Store{}
 	default:
 		logger.Log.Fatalf("Unknown storage app.StoreDriver=%s", app.StoreDriver)
 	}
-	if err := db.Initialize(ctx, app); err != nil {
+
+	return db
+}
+
+// envInt переопределяет значение флага переменной окружения name
+func envInt(name string, value *int) {
+	if env := os.Getenv(name); env != "" {
+		v, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*value = v
+	}
+}
+
+// envFloat переопределяет значение флага переменной окружения name
+func envFloat(name string, value *float64) {
+	if env := os.Getenv(name); env != "" {
+		v, err := strconv.ParseFloat(env, 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*value = v
+	}
+}
+
+// envDuration переопределяет значение флага переменной окружения name, например "30s"
+func envDuration(name string, value *time.Duration) {
+	if env := os.Getenv(name); env != "" {
+		v, err := time.ParseDuration(env)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*value = v
+	}
+}
+
+// envBool переопределяет значение флага переменной окружения name, например "true"
+func envBool(name string, value *bool) {
+	if env := os.Getenv(name); env != "" {
+		v, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatal(err)
+		}
+		*value = v
+	}
+}
+
+// splitList разбирает список через запятую, пропуская пустые элементы
+func splitList(raw string) []string {
+	var list []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// parseTiers разбирает уровни лояльности вида name:threshold:multiplier[:bonus]
+// и сортирует их по возрастанию порога
+func parseTiers(raw string) ([]models.Tier, error) {
+	var tiers []models.Tier
+	for _, item := range splitList(raw) {
+		parts := strings.Split(item, ":")
+		if len(parts) < 3 || len(parts) > 4 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid loyalty tier %q, expected name:threshold:multiplier[:bonus]", item)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 32)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid loyalty tier %q threshold", item)
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || multiplier < 1 {
+			return nil, fmt.Errorf("invalid loyalty tier %q multiplier, must be >= 1", item)
+		}
+		var bonus float64
+		if len(parts) == 4 {
+			if bonus, err = strconv.ParseFloat(parts[3], 32); err != nil || bonus < 0 {
+				return nil, fmt.Errorf("invalid loyalty tier %q bonus", item)
+			}
+		}
+		tiers = append(tiers, models.Tier{
+			Name:       parts[0],
+			Threshold:  models.Money(threshold),
+			Multiplier: multiplier,
+			Bonus:      models.Money(bonus),
+		})
+	}
+	sort.SliceStable(tiers, func(i, j int) bool {
+		return tiers[i].Threshold < tiers[j].Threshold
+	})
+
+	return tiers, nil
+}
+
+// parseRateLimits разбирает ограничения частоты запросов вида route=requests/period[:burst],
+// например login=10/1m:3; без burst подряд можно сделать все requests запросов
+func parseRateLimits(raw string) ([]models.RateLimit, error) {
+	var limits []models.RateLimit
+	seen := map[string]bool{}
+	for _, item := range splitList(raw) {
+		route, rule, ok := strings.Cut(item, "=")
+		if !ok || !slices.Contains(models.RateLimitRoutes, route) {
+			return nil, fmt.Errorf("invalid rate limit %q, expected route=requests/period[:burst] with route one of %s",
+				item, strings.Join(models.RateLimitRoutes, ", "))
+		}
+		if seen[route] {
+			return nil, fmt.Errorf("duplicate rate limit for route %q", route)
+		}
+		seen[route] = true
+
+		rule, rawBurst, hasBurst := strings.Cut(rule, ":")
+		rawRequests, rawPer, ok := strings.Cut(rule, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit %q, expected route=requests/period[:burst]", item)
+		}
+		requests, err := strconv.Atoi(rawRequests)
+		if err != nil || requests <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q requests", item)
+		}
+		per, err := time.ParseDuration(rawPer)
+		if err != nil || per <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q period", item)
+		}
+		burst := requests
+		if hasBurst {
+			if burst, err = strconv.Atoi(rawBurst); err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid rate limit %q burst", item)
+			}
+		}
+		limits = append(limits, models.RateLimit{
+			Route:    route,
+			Requests: requests,
+			Per:      per,
+			Burst:    burst,
+		})
+	}
+
+	return limits, nil
+}
+
+// loadTenants читает программы лояльности из JSON-файла path. Программа по умолчанию
+// получает общие адрес системы начислений, ключ подписи и доступы к служебным API
+// (defaults) и всегда идет первой; в файле ее можно описать, чтобы задать имена хостов
+func loadTenants(path string, defaults models.Tenant) ([]models.Tenant, error) {
+	accrualSystemAddress, secretKey := defaults.AccrualSystemAddress, defaults.SecretKey
+	tenants := []models.Tenant{defaults}
+	if path == "" {
+		return tenants, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return nil, err
 	}
+	var list []models.Tenant
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid tenants file %s: %w", path, err)
+	}
 
-	// init app:
-	repo := api.NewRepo(db)
-	api.NewHandlers(repo, &app)
+	ids := map[string]bool{}
+	hosts := map[string]string{}
+	for _, tenant := range list {
+		if tenant.ID == "" || len(tenant.ID) > 50 {
+			return nil, fmt.Errorf("invalid tenant id %q", tenant.ID)
+		}
+		if ids[tenant.ID] {
+			return nil, fmt.Errorf("duplicate tenant %q", tenant.ID)
+		}
+		ids[tenant.ID] = true
+		for i, host := range tenant.Hosts {
+			host = strings.ToLower(host)
+			if other, ok := hosts[host]; ok {
+				return nil, fmt.Errorf("host %q belongs to tenants %q and %q", host, other, tenant.ID)
+			}
+			hosts[host] = tenant.ID
+			tenant.Hosts[i] = host
+		}
+		if tenant.AccrualSystemAddress == "" {
+			tenant.AccrualSystemAddress = accrualSystemAddress
+		} else {
+			tenant.AccrualSystemAddress = URL(tenant.AccrualSystemAddress)
+		}
+
+		if tenant.ID == models.DefaultTenantID {
+			if tenant.SecretKey == "" {
+				tenant.SecretKey = secretKey
+			}
+			if tenant.AdminToken == "" {
+				tenant.AdminToken = defaults.AdminToken
+			}
+			if len(tenant.MerchantAPIKeys) == 0 {
+				tenant.MerchantAPIKeys = defaults.MerchantAPIKeys
+			}
+			tenants[0] = tenant
+			continue
+		}
+		// общий ключ позволил бы токену одной программы пройти проверку в другой
+		if tenant.SecretKey == "" || tenant.SecretKey == secretKey {
+			return nil, fmt.Errorf("tenant %q requires its own secret_key", tenant.ID)
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	// то же для доступов к служебным API: токен или ключ одной программы не должен
+	// подходить к другой
+	owners := map[string]string{}
+	for _, tenant := range tenants {
+		secrets := tenant.MerchantAPIKeys
+		if tenant.AdminToken != "" {
+			secrets = append(slices.Clone(secrets), tenant.AdminToken)
+		}
+		for _, secret := range secrets {
+			if other, ok := owners[secret]; ok && other != tenant.ID {
+				return nil, fmt.Errorf("tenants %q and %q share an admin token or merchant API key", other, tenant.ID)
+			}
+			owners[secret] = tenant.ID
+		}
+	}
 
-	return serverAddress, nil
+	return tenants, nil
 }
 
 func URL(rawURL string) string {
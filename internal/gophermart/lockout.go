@@ -0,0 +1,35 @@
+package gophermart
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"sync"
+	"time"
+)
+
+// SweepLoginAttempts периодически удаляет неудачные попытки входа, забытые по окну,
+// чтобы они не копились для разовых логинов и IP
+func SweepLoginAttempts(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	logger.Log.Infoln("Starting login attempts sweeper")
+	ticker := time.NewTicker(app.LoginFailureWindow)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := api.Repo.Store.DeleteStaleLoginAttempts(ctx, time.Now().Add(-app.LoginFailureWindow))
+			if err != nil {
+				logger.Log.Errorln("failed DeleteStaleLoginAttempts()=", err)
+				continue
+			}
+			if deleted > 0 {
+				logger.Log.Infoln("Deleted stale login attempts:", deleted)
+			}
+		}
+	}
+}
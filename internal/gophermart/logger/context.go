@@ -0,0 +1,43 @@
+package logger
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+type (
+	loggerKey    struct{}
+	requestIDKey struct{}
+)
+
+// WithContext сохраняет в контексте логер запроса или задачи: обычно дочерний Log
+// с полями request_id, user_id и т.п., по которым строки одного запроса связываются
+func WithContext(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext возвращает логер, сохраненный WithContext, или Log
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return l
+	}
+
+	return Log
+}
+
+// With добавляет поля к логеру контекста
+func With(ctx context.Context, args ...any) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
+
+// WithRequestID сохраняет в контексте идентификатор запроса
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID возвращает идентификатор запроса из контекста или пустую строку
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
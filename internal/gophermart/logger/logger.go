@@ -1,25 +1,56 @@
 package logger
 
-import "go.uber.org/zap"
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net/http"
+)
 
 // Log будет доступен всему коду как синглтон.
 // Никакой код навыка, кроме функции InitLogger, не должен модифицировать эту переменную.
 var Log *zap.SugaredLogger
 
-// Initialize инициализирует синглтон логера с необходимым уровнем логирования.
-func Initialize(level string) error {
+// Level - текущий уровень логирования, меняется на лету через LevelHandler
+var Level = zap.NewAtomicLevel()
+
+// форматы журнала
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console" // для чтения человеком при локальном запуске
+)
+
+// Initialize инициализирует синглтон логера с необходимым уровнем логирования, форматом
+// и маскировкой чувствительных данных.
+func Initialize(level, format string, redact bool) error {
 	// преобразуем текстовый уровень логирования в zap.AtomicLevel
-	lvl, err := zap.ParseAtomicLevel(level)
+	lvl, err := zapcore.ParseLevel(level)
 	if err != nil {
 		return err
 	}
+	Level.SetLevel(lvl)
 	// создаём новую конфигурацию логера
-	//cfg := zap.NewProductionConfig()
 	cfg := zap.NewProductionConfig()
+	switch format {
+	case FormatJSON:
+	case FormatConsole:
+		cfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+	cfg.Encoding = format
 	// устанавливаем уровень
-	cfg.Level = lvl
+	cfg.Level = Level
+	// маскировка оборачивает все записи, в том числе сторонних пакетов через этот логер
+	redaction.Store(redact)
+	var opts []zap.Option
+	if redact {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return redactCore{Core: core}
+		}))
+	}
 	// создаём логер на основе конфигурации
-	zl, err := cfg.Build()
+	zl, err := cfg.Build(opts...)
 	if err != nil {
 		return err
 	}
@@ -27,3 +58,15 @@ func Initialize(level string) error {
 	Log = zl.Sugar()
 	return nil
 }
+
+// LevelHandler показывает (GET) и меняет (PUT {"level":"debug"}) уровень логирования
+// без перезапуска
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before := Level.Level()
+		Level.ServeHTTP(w, r)
+		if after := Level.Level(); after != before {
+			Log.Infoln("Log level changed:", "from", before, "to", after)
+		}
+	})
+}
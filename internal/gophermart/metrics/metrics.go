@@ -0,0 +1,145 @@
+// Package metrics - метрики приложения в формате Prometheus. Отдаются
+// на отдельном служебном адресе, а не на публичном API
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
+	"net/http"
+)
+
+const namespace = "gophermart"
+
+// Registry - реестр метрик приложения и процесса
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// AccrualPolls - исход опроса accrual: статус начисления или error
+	AccrualPolls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_polls_total",
+		Help:      "Accrual system polls by outcome (accrual status or error).",
+	}, []string{"outcome"})
+	// AccrualErrors - ошибки клиента accrual: network, decode или HTTP-код ответа (кроме 429)
+	AccrualErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_client_errors_total",
+		Help:      "Accrual client errors by reason (network, decode or HTTP status code other than 429).",
+	}, []string{"reason"})
+	AccrualRateLimited = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_rate_limited_total",
+		Help:      "Accrual system responses with 429 Too Many Requests.",
+	})
+
+	// бизнес-показатели в баллах
+	PointsCredited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_credited_total",
+		Help:      "Points credited: order accruals with tier and campaign bonuses, referral rewards and vouchers.",
+	}, []string{"tenant"})
+	PointsWithdrawn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "points_withdrawn_total",
+		Help:      "Points withdrawn by users, including captured holds.",
+	}, []string{"tenant"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests, HTTPDuration,
+		AccrualPolls, AccrualErrors, AccrualRateLimited,
+		PointsCredited, PointsWithdrawn,
+	)
+}
+
+// RegisterQueue публикует заполненность очереди заказов на опрос accrual
+func RegisterQueue(depth, capacity func() int) {
+	Registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "accrual_queue_depth",
+			Help:      "Orders waiting in the accrual poll queue.",
+		}, func() float64 { return float64(depth()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "accrual_queue_capacity",
+			Help:      "Capacity of the accrual poll queue.",
+		}, func() float64 { return float64(capacity()) }),
+	)
+}
+
+// RegisterStore публикует статистику пула соединений хранилища
+func RegisterStore(stats func() store.Stats) {
+	Registry.MustRegister(&storeCollector{stats: stats})
+}
+
+// Handler отдает метрики в текстовом формате Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// storeCollector снимает статистику пула при каждом запросе метрик
+type storeCollector struct {
+	stats func() store.Stats
+}
+
+var (
+	dbMaxConns = prometheus.NewDesc(namespace+"_db_max_conns",
+		"Maximum size of the database connection pool.", nil, nil)
+	dbConns = prometheus.NewDesc(namespace+"_db_conns",
+		"Open database connections by state (idle, acquired).", []string{"state"}, nil)
+	dbAcquires = prometheus.NewDesc(namespace+"_db_acquires_total",
+		"Database connections acquired from the pool.", nil, nil)
+	dbAcquireWaits = prometheus.NewDesc(namespace+"_db_acquire_waits_total",
+		"Acquires that waited for a free database connection.", nil, nil)
+	dbCanceledAcquires = prometheus.NewDesc(namespace+"_db_canceled_acquires_total",
+		"Acquires canceled while waiting for a database connection.", nil, nil)
+	dbAcquireSeconds = prometheus.NewDesc(namespace+"_db_acquire_seconds_total",
+		"Total time spent acquiring database connections.", nil, nil)
+	dbNewConns = prometheus.NewDesc(namespace+"_db_new_conns_total",
+		"Database connections opened.", nil, nil)
+	dbDestroyedConns = prometheus.NewDesc(namespace+"_db_destroyed_conns_total",
+		"Database connections closed by reason (max_lifetime, max_idle).", []string{"reason"}, nil)
+)
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbMaxConns
+	ch <- dbConns
+	ch <- dbAcquires
+	ch <- dbAcquireWaits
+	ch <- dbCanceledAcquires
+	ch <- dbAcquireSeconds
+	ch <- dbNewConns
+	ch <- dbDestroyedConns
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.stats()
+	ch <- prometheus.MustNewConstMetric(dbMaxConns, prometheus.GaugeValue, float64(s.MaxConns))
+	ch <- prometheus.MustNewConstMetric(dbConns, prometheus.GaugeValue, float64(s.IdleConns), "idle")
+	ch <- prometheus.MustNewConstMetric(dbConns, prometheus.GaugeValue, float64(s.AcquiredConns), "acquired")
+	ch <- prometheus.MustNewConstMetric(dbAcquires, prometheus.CounterValue, float64(s.AcquireCount))
+	ch <- prometheus.MustNewConstMetric(dbAcquireWaits, prometheus.CounterValue, float64(s.EmptyAcquireCount))
+	ch <- prometheus.MustNewConstMetric(dbCanceledAcquires, prometheus.CounterValue, float64(s.CanceledAcquireCount))
+	ch <- prometheus.MustNewConstMetric(dbAcquireSeconds, prometheus.CounterValue, s.AcquireDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(dbNewConns, prometheus.CounterValue, float64(s.NewConnsCount))
+	ch <- prometheus.MustNewConstMetric(dbDestroyedConns, prometheus.CounterValue, float64(s.MaxLifetimeDestroyCount), "max_lifetime")
+	ch <- prometheus.MustNewConstMetric(dbDestroyedConns, prometheus.CounterValue, float64(s.MaxIdleDestroyCount), "max_idle")
+}
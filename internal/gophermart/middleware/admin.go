@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"net/http"
+)
+
+// CheckAdmin пускает администратора программы лояльности запроса: токен другой
+// программы не подходит
+func CheckAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.IsAdminToken(api.GetTenant(r), r.Header.Get("X-Admin-Token")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckMerchant пускает мерчанта программы лояльности запроса, как CheckPartner
+func CheckMerchant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !api.IsMerchantKey(api.GetTenant(r), r.Header.Get("X-API-Key")) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CheckPartner пускает партнера с действующим API-ключом и областью доступа scope
+func CheckPartner(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			partner, err := api.Repo.AuthenticatePartner(r.Context(), r.Header.Get("X-API-Key"))
+			switch {
+			case errors.Is(err, api.ErrNotFound):
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			case err != nil:
+				logger.FromContext(r.Context()).Errorln("failed AuthenticatePartner()= ", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			// ключ партнера действует только в его программе лояльности
+			if partner.TenantID != api.GetTenant(r).ID {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if !partner.HasScope(scope) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			ctx := logger.With(api.WithPartner(r.Context(), partner), "partner_id", partner.ID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
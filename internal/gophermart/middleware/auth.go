@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
 	"net/http"
 	"strings"
 )
@@ -16,12 +17,17 @@ func CheckAuth(next http.Handler) http.Handler {
 			return
 		}
 		token := authorization[len(bearerSchema):]
-		userID := api.GetUserID(token)
+		userID, err := api.Repo.Authenticate(r.Context(), token, api.GetTenant(r))
+		if err != nil {
+			logger.FromContext(r.Context()).Errorln("failed Authenticate()= ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 		if userID < 0 {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(logger.With(r.Context(), "user_id", userID)))
 	})
 }
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"github.com/go-chi/chi/v5"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
 	"net/http"
 	"time"
@@ -49,8 +50,14 @@ func WithLogging(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 
-		logger.Log.Infoln(
-			"uri", r.RequestURI,
+		// параметры пути бывают персональными данными (логин в разблокировке входа),
+		// поэтому при маскировке вместо пути пишется шаблон маршрута
+		uri := r.RequestURI
+		if rctx := chi.RouteContext(r.Context()); logger.Redacting() && rctx != nil && len(rctx.URLParams.Keys) > 0 {
+			uri = rctx.RoutePattern()
+		}
+		logger.FromContext(r.Context()).Infoln(
+			"uri", uri,
 			"method", r.Method,
 			"status", responseData.status, // получаем перехваченный код статуса ответа
 			"duration", duration,
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// WithMetrics считает запросы и их длительность по шаблону маршрута: по самому пути
+// (с номерами заказов и т.п.) число рядов метрик росло бы неограниченно
+func WithMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		responseData := &responseData{}
+		lw := loggingResponseWriter{
+			ResponseWriter: w,
+			responseData:   responseData,
+		}
+		next.ServeHTTP(&lw, r)
+
+		// шаблон известен только после маршрутизации
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := responseData.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := []string{route, r.Method, strconv.Itoa(status)}
+
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limiter - корзины токенов ограничения частоты запросов
+type Limiter interface {
+	// Take забирает токен из корзины key; если токенов нет, возвращает,
+	// через сколько появится следующий
+	Take(ctx context.Context, key string, limit models.RateLimit) (retryAfter time.Duration, err error)
+	// Sweep удаляет полные корзины, чтобы они не копились для разовых клиентов
+	Sweep(ctx context.Context) (int64, error)
+}
+
+var rateLimiter Limiter
+var rateLimits map[string]models.RateLimit
+
+// SetRateLimiter задает корзины и ограничения для RateLimit, вызывается до Routes
+func SetRateLimiter(limiter Limiter, limits []models.RateLimit) {
+	rateLimiter = limiter
+	rateLimits = make(map[string]models.RateLimit, len(limits))
+	for _, limit := range limits {
+		rateLimits[limit.Route] = limit
+	}
+}
+
+// GetRateLimiter возвращает корзины, заданные SetRateLimiter
+func GetRateLimiter() Limiter {
+	return rateLimiter
+}
+
+// RateLimit ограничивает частоту запросов к группе маршрутов route отдельно для
+// каждого IP и, если запрос авторизован, для каждого пользователя.
+// Без ограничения для route запросы проходят как есть
+func RateLimit(route string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		limit, ok := rateLimits[route]
+		if !ok || rateLimiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys := []string{route + ":ip:" + api.ClientIP(r)}
+			if userID := authUserID(r); userID > 0 {
+				keys = append(keys, route+":user:"+strconv.FormatInt(userID, 10))
+			}
+
+			for _, key := range keys {
+				retryAfter, err := rateLimiter.Take(r.Context(), key, limit)
+				if err != nil {
+					// недоступность общих корзин не должна останавливать сервис
+					logger.FromContext(r.Context()).Errorln("failed Take()= ", err)
+					break
+				}
+				if retryAfter > 0 {
+					// как и система расчета начислений: Retry-After в секундах
+					// и текст с допустимой частотой запросов
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					http.Error(w, fmt.Sprintf("No more than %d requests per %s allowed", limit.Requests, perName(limit.Per)),
+						http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authUserID возвращает ID пользователя из токена запроса или -1 без действующего токена
+func authUserID(r *http.Request) int64 {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerSchema) {
+		return -1
+	}
+
+	return api.GetUserID(authorization[len(bearerSchema):], api.GetTenant(r))
+}
+
+// perName - период ограничения для текста ответа: "minute" для минуты, иначе длительность
+func perName(per time.Duration) string {
+	switch per {
+	case time.Second:
+		return "second"
+	case time.Minute:
+		return "minute"
+	case time.Hour:
+		return "hour"
+	}
+
+	return per.String()
+}
+
+// MemoryLimiter - корзины токенов в памяти процесса: лимиты действуют на каждую
+// реплику приложения отдельно
+type MemoryLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // когда корзина снова наполнится
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{buckets: map[string]*bucket{}}
+}
+
+func (l *MemoryLimiter) Take(_ context.Context, key string, limit models.RateLimit) (time.Duration, error) {
+	now := time.Now()
+	interval := limit.Interval()
+	capacity := float64(limit.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+	// токены возвращаются равномерно, по одному за interval
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updated))/float64(interval))
+	b.updated = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(interval)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((capacity - b.tokens) * float64(interval)))
+
+	return 0, nil
+}
+
+func (l *MemoryLimiter) Sweep(_ context.Context) (int64, error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var deleted int64
+	for key, b := range l.buckets {
+		if !b.full.After(now) {
+			delete(l.buckets, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// StoreLimiter - корзины токенов в хранилище, общие для всех реплик приложения
+type StoreLimiter struct {
+	Store store.RateLimits
+}
+
+func (l *StoreLimiter) Take(ctx context.Context, key string, limit models.RateLimit) (time.Duration, error) {
+	return l.Store.TakeRateToken(ctx, key, limit)
+}
+
+func (l *StoreLimiter) Sweep(ctx context.Context) (int64, error) {
+	return l.Store.DeleteIdleRateBuckets(ctx)
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// RequestIDHeader - заголовок идентификатора запроса
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength ограничивает идентификатор клиента, который попадает в журнал
+const maxRequestIDLength = 64
+
+// WithRequestID принимает идентификатор запроса от клиента или прокси либо создает новый,
+// возвращает его в ответе и сохраняет в контексте логер с полем request_id
+// (и trace_id, если запрос трассируется)
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := logger.WithRequestID(r.Context(), id)
+		fields := []any{"request_id", id}
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			fields = append(fields, "trace_id", sc.TraceID().String())
+		}
+		ctx = logger.With(ctx, fields...)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID допускает только короткие идентификаторы из печатных символов без пробелов,
+// чтобы клиент не мог подделать строки журнала
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logger.Log.Errorln("failed rand.Read()= ", err)
+	}
+
+	return hex.EncodeToString(b)
+}
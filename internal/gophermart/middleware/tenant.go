@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"net/http"
+)
+
+// ResolveTenant определяет программу лояльности запроса и сохраняет ее в контексте
+func ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, err := api.ResolveTenant(r)
+		switch {
+		case errors.Is(err, api.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case err != nil:
+			logger.FromContext(r.Context()).Errorln("failed ResolveTenant()= ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(api.WithTenant(r.Context(), tenant)))
+	})
+}
@@ -0,0 +1,72 @@
+package gophermart
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+var ErrMigrateUsage = errors.New("usage: gophermart [flags] migrate up|down [steps]|status")
+
+// Migrate выполняет подкоманду migrate: up, down [steps] или status
+func Migrate(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return ErrMigrateUsage
+	}
+
+	db := NewStore()
+	if err := db.Open(ctx, app); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := db.Migrator()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Log.Infoln("Applied migrations:", applied)
+	case "down":
+		// по умолчанию откатываем одну последнюю миграцию
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return ErrMigrateUsage
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		logger.Log.Infoln("Reverted migrations:", reverted)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return tw.Flush()
+	default:
+		return ErrMigrateUsage
+	}
+
+	return nil
+}
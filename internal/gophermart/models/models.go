@@ -1,10 +1,46 @@
 package models
 
+import (
+	"math"
+	"time"
+)
+
 type User struct {
 	ID        int64  `json:"id"`
+	TenantID  string `json:"-"`
 	Login     string `json:"login"`
 	Password  string `json:"password"`
 	CreatedAt string `json:"created_at"`
+
+	InviteCode   string `json:"invite_code,omitempty"`   // личный код для приглашения других
+	ReferralCode string `json:"referral_code,omitempty"` // код пригласившего при регистрации
+
+	Email        string `json:"email,omitempty"` // канал для сброса пароля
+	TokenVersion int64  `json:"-"`               // токены с другой версией отозваны
+}
+
+// DeletedLoginPrefix - префикс обезличенного логина удаленного пользователя (за ним ID),
+// такие логины не регистрируются
+const DeletedLoginPrefix = "deleted-"
+
+// UserProfile - профиль пользователя в архиве его данных
+type UserProfile struct {
+	Login      string `json:"login"`
+	Email      string `json:"email,omitempty"`
+	InviteCode string `json:"invite_code,omitempty"`
+	TwoFactor  bool   `json:"two_factor"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// UserExport - архив персональных данных пользователя. История баланса - операции
+// помимо начислений за заказы и списаний, которые выгружаются отдельно
+type UserExport struct {
+	ExportedAt     string        `json:"exported_at"`
+	Profile        UserProfile   `json:"profile"`
+	Balance        Balance       `json:"balance"`
+	Orders         []Order       `json:"orders"`
+	Withdrawals    []Withdrawal  `json:"withdrawals"`
+	BalanceHistory []LedgerEntry `json:"balance_history"`
 }
 
 type OrderState string
@@ -19,7 +55,11 @@ const (
 type Order struct {
 	Number    string     `json:"number"`
 	UserID    int64      `json:"-"`
+	TenantID  string     `json:"-"`
 	Accrual   Money      `json:"accrual,omitempty"`
+	Bonus     Money      `json:"bonus,omitempty"` // надбавка уровня лояльности сверх Accrual
+	Tier      string     `json:"-"`               // уровень, по которому начислена надбавка
+	PartnerID int64      `json:"-"`               // партнер, зарегистрировавший заказ (0 - сам пользователь)
 	Status    OrderState `json:"status"`
 	CreatedAt string     `json:"uploaded_at"`
 }
@@ -46,8 +86,11 @@ func (o Order) IsValid() bool {
 
 type Balance struct {
 	UserID    int64  `json:"-"`
-	Current   Money  `json:"current"`
+	TenantID  string `json:"-"`
+	Current   Money  `json:"current"` // доступно для списания
 	Withdrawn Money  `json:"withdrawn"`
+	Reserved  Money  `json:"reserved"` // удерживается под неподтвержденные покупки
+	Expiring  Money  `json:"expiring"` // сгорит в ближайшие app.PointsExpiryNoticeDays дней
 	CreatedAt string `json:"-"`
 }
 
@@ -71,8 +114,15 @@ const (
 )
 
 type AccrualRequest struct {
-	Number string
-	UserID int64
+	Number   string
+	UserID   int64
+	TenantID string
+	// RequestID - идентификатор запроса, поставившего заказ в очередь: по нему
+	// в журнале находится весь путь заказа до начисления
+	RequestID string
+	// Trace - W3C-контекст трассировки запроса, поставившего заказ в очередь:
+	// опросы accrual связываются с ним, пока заказ не будет рассчитан
+	Trace map[string]string
 }
 
 type AccrualResponse struct {
@@ -82,8 +132,467 @@ type AccrualResponse struct {
 }
 
 type Withdrawal struct {
-	Order     string `json:"order"`
-	UserID    int64  `json:"-"`
-	Sum       Money  `json:"sum"`
-	CreatedAt string `json:"processed_at"`
+	Order          string `json:"order"`
+	UserID         int64  `json:"-"`
+	TenantID       string `json:"-"`
+	Sum            Money  `json:"sum"`
+	Reversed       Money  `json:"reversed,omitempty"`
+	CreatedAt      string `json:"processed_at"`
+	IdempotencyKey string `json:"-"`
+}
+
+type HoldState string
+
+const (
+	HoldStateHeld     HoldState = "HELD"     // баллы зарезервированы
+	HoldStateCaptured HoldState = "CAPTURED" // покупка оплачена, баллы списаны
+	HoldStateVoided   HoldState = "VOIDED"   // покупка отменена, баллы возвращены
+	HoldStateExpired  HoldState = "EXPIRED"  // резерв не подтвержден вовремя, баллы возвращены
+)
+
+// Hold - резерв баллов под покупку до ее оплаты
+type Hold struct {
+	Order     string    `json:"order"`
+	UserID    int64     `json:"-"`
+	TenantID  string    `json:"-"`
+	Sum       Money     `json:"sum"`
+	Status    HoldState `json:"status"`
+	ExpiresAt string    `json:"expires_at"`
+	CreatedAt string    `json:"created_at"`
+}
+
+// Reversal - возврат баллов по списанию, например при отмене покупки
+type Reversal struct {
+	Order    string `json:"-"`
+	TenantID string `json:"-"`
+	Sum      Money  `json:"sum"` // 0 - вернуть весь остаток списания
+	Reason   string `json:"reason"`
+	Source   string `json:"-"` // кто инициировал возврат: admin, merchant
+}
+
+type LedgerOperation string
+
+const (
+	LedgerOperationReversal      LedgerOperation = "REVERSAL"       // возврат баллов по списанию
+	LedgerOperationExpiry        LedgerOperation = "EXPIRY"         // сгорание партии баллов
+	LedgerOperationTierBonus     LedgerOperation = "TIER_BONUS"     // надбавка уровня лояльности к начислению
+	LedgerOperationCampaignBonus LedgerOperation = "CAMPAIGN_BONUS" // бонус промо-акции
+	LedgerOperationReferralBonus LedgerOperation = "REFERRAL_BONUS" // бонус за приглашение
+	LedgerOperationTransferOut   LedgerOperation = "TRANSFER_OUT"   // перевод баллов другому пользователю
+	LedgerOperationTransferIn    LedgerOperation = "TRANSFER_IN"    // перевод баллов от другого пользователя
+	LedgerOperationVoucher       LedgerOperation = "VOUCHER"        // погашение подарочного сертификата
+)
+
+// LedgerEntry - запись в истории операций с балансом
+type LedgerEntry struct {
+	ID        int64           `json:"-"`
+	UserID    int64           `json:"-"`
+	Operation LedgerOperation `json:"operation"`
+	Amount    Money           `json:"amount"`
+	Order     string          `json:"order,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Ref       string          `json:"-"`
+	CreatedAt string          `json:"created_at"`
+}
+
+type LotSource string
+
+const (
+	LotSourceAccrual   LotSource = "ACCRUAL"   // начисление за заказ
+	LotSourceReversal  LotSource = "REVERSAL"  // возврат по списанию
+	LotSourceRelease   LotSource = "RELEASE"   // отмененный или истекший резерв
+	LotSourceCampaign  LotSource = "CAMPAIGN"  // бонус промо-акции
+	LotSourceReferral  LotSource = "REFERRAL"  // бонус за приглашение
+	LotSourceTransfer  LotSource = "TRANSFER"  // перевод от другого пользователя
+	LotSourceVoucher   LotSource = "VOUCHER"   // подарочный сертификат
+	LotSourceMigration LotSource = "MIGRATION" // остаток на момент введения партий
+)
+
+// Lot - партия начисленных баллов, сгорающая через заданный срок после начисления
+type Lot struct {
+	ID        int64     `json:"-"`
+	UserID    int64     `json:"-"`
+	Source    LotSource `json:"source"`
+	Order     string    `json:"order,omitempty"`
+	Amount    Money     `json:"amount"` // несписанный остаток партии
+	EarnedAt  string    `json:"earned_at"`
+	ExpiresAt string    `json:"expires_at"`
+}
+
+type TierBasis string
+
+const (
+	TierBasisAccrued TierBasis = "accrued" // уровень по сумме начислений за окно
+	TierBasisSpent   TierBasis = "spent"   // уровень по сумме списаний за окно
+)
+
+// Tier - уровень программы лояльности. Threshold и Bonus задаются в баллах
+type Tier struct {
+	Name       string  `json:"name"`
+	Threshold  Money   `json:"threshold"`       // сумма за скользящее окно для перехода на уровень
+	Multiplier float64 `json:"multiplier"`      // множитель начисления accrual
+	Bonus      Money   `json:"bonus,omitempty"` // фиксированная надбавка к каждому начислению
+}
+
+// UserTier - уровень, присвоенный пользователю при последнем пересчете
+type UserTier struct {
+	UserID     int64  `json:"-"`
+	Tier       string `json:"tier"`
+	Qualifying Money  `json:"qualifying"` // сумма за окно, по которой присвоен уровень
+	UpdatedAt  string `json:"updated_at"`
+}
+
+// Campaign - промо-акция, начисляющая бонус к PROCESSED заказам по правилам
+type Campaign struct {
+	ID         int64    `json:"id"`
+	TenantID   string   `json:"-"`
+	Name       string   `json:"name"`
+	StartsAt   string   `json:"starts_at"`
+	EndsAt     string   `json:"ends_at"`
+	FirstOrder bool     `json:"first_order"`           // только первый начисленный заказ пользователя
+	MinAccrual Money    `json:"min_accrual,omitempty"` // минимальное начисление по заказу
+	Segment    []string `json:"segment,omitempty"`     // уровни лояльности; пусто - все пользователи
+	Multiplier float64  `json:"multiplier,omitempty"`  // 2 - двойные баллы
+	Bonus      Money    `json:"bonus,omitempty"`       // фиксированный бонус за заказ
+	Budget     Money    `json:"budget,omitempty"`      // 0 - без ограничения
+	Spent      Money    `json:"spent"`
+	Active     bool     `json:"active"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+// Award возвращает бонус акции за начисление accrual или 0, если правила не выполнены.
+// Суммы в копейках; остаток бюджета ограничивает бонус
+func (c Campaign) Award(accrual Money, firstOrder bool, tier string) Money {
+	if !c.Active || accrual < c.MinAccrual || (c.FirstOrder && !firstOrder) {
+		return 0
+	}
+	if len(c.Segment) > 0 {
+		inSegment := false
+		for _, s := range c.Segment {
+			if s == tier {
+				inSegment = true
+				break
+			}
+		}
+		if !inSegment {
+			return 0
+		}
+	}
+
+	award := c.Bonus
+	if c.Multiplier > 1 {
+		award += Money(math.Round(float64(accrual) * (c.Multiplier - 1)))
+	}
+	if c.Budget > 0 && award > c.Budget-c.Spent {
+		award = c.Budget - c.Spent
+	}
+
+	return max(award, 0)
+}
+
+type ReferralStatus string
+
+const (
+	ReferralStatusPending  ReferralStatus = "PENDING"  // приглашенный еще не сделал обработанный заказ
+	ReferralStatusRewarded ReferralStatus = "REWARDED" // бонусы начислены обоим
+	ReferralStatusRejected ReferralStatus = "REJECTED" // бонусы не положены по лимитам
+)
+
+// Referral - приглашение пользователя по коду другого пользователя
+type Referral struct {
+	ReferrerID int64          `json:"-"`
+	RefereeID  int64          `json:"-"`
+	Login      string         `json:"login"` // логин приглашенного, частично скрытый
+	Status     ReferralStatus `json:"status"`
+	Reason     string         `json:"reason,omitempty"`
+	Reward     Money          `json:"reward,omitempty"` // бонус пригласившему
+	Order      string         `json:"-"`
+	CreatedAt  string         `json:"created_at"`
+	RewardedAt string         `json:"rewarded_at,omitempty"`
+}
+
+// ReferralPolicy - бонусы за приглашение в копейках и лимит награждений на пригласившего
+type ReferralPolicy struct {
+	ReferrerReward Money
+	RefereeReward  Money
+	MaxRewards     int // 0 - без ограничения
+}
+
+type TransferDirection string
+
+const (
+	TransferDirectionIn  TransferDirection = "IN"
+	TransferDirectionOut TransferDirection = "OUT"
+)
+
+// Transfer - перевод баллов между пользователями
+type Transfer struct {
+	ID         int64             `json:"id"`
+	TenantID   string            `json:"-"`
+	FromUserID int64             `json:"-"`
+	ToUserID   int64             `json:"-"`
+	From       string            `json:"from,omitempty"` // логин отправителя
+	To         string            `json:"to,omitempty"`   // логин получателя
+	Sum        Money             `json:"sum"`
+	Comment    string            `json:"comment,omitempty"`
+	Direction  TransferDirection `json:"direction,omitempty"`
+	CreatedAt  string            `json:"created_at"`
+}
+
+// TransferPolicy - лимит переводов отправителя за сутки в копейках (0 - без ограничения)
+type TransferPolicy struct {
+	DailyLimit Money
+}
+
+// VoucherBatch - выпуск подарочных сертификатов. Codes заполняется только при
+// выпуске, счетчики погашения - в отчете
+type VoucherBatch struct {
+	ID            int64    `json:"id"`
+	TenantID      string   `json:"-"`
+	Name          string   `json:"name"`
+	Value         Money    `json:"value"`
+	Count         int      `json:"count"`
+	ExpiresAt     string   `json:"expires_at"`
+	CreatedAt     string   `json:"created_at,omitempty"`
+	Codes         []string `json:"codes,omitempty"`
+	Redeemed      int      `json:"redeemed"`
+	RedeemedValue Money    `json:"redeemed_value"`
+	Expired       int      `json:"expired"`
+}
+
+// Voucher - погашенный пользователем сертификат
+type Voucher struct {
+	ID         int64  `json:"-"`
+	BatchID    int64  `json:"-"`
+	Code       string `json:"code"`
+	Value      Money  `json:"value"`
+	RedeemedAt string `json:"redeemed_at"`
+}
+
+// области доступа партнерского API-ключа
+const (
+	PartnerScopeOrdersWrite     = "orders:write"
+	PartnerScopeOrdersRead      = "orders:read"
+	PartnerScopeWithdrawalsRead = "withdrawals:read"
+	PartnerScopeReportsRead     = "reports:read"
+)
+
+// PartnerScopes - все области доступа партнера
+var PartnerScopes = []string{
+	PartnerScopeOrdersWrite,
+	PartnerScopeOrdersRead,
+	PartnerScopeWithdrawalsRead,
+	PartnerScopeReportsRead,
+}
+
+// Partner - магазин-партнер. APIKey заполняется только при выпуске ключа
+type Partner struct {
+	ID        int64    `json:"id"`
+	TenantID  string   `json:"-"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	KeyPrefix string   `json:"key_prefix"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+	APIKey    string   `json:"api_key,omitempty"`
+}
+
+func (p Partner) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PartnerReport - начисления и списания по заказам партнера за период
+type PartnerReport struct {
+	PartnerID   int64  `json:"partner_id"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Orders      int    `json:"orders"`
+	Processed   int    `json:"processed"`
+	Accrued     Money  `json:"accrued"`
+	Redemptions int    `json:"redemptions"`
+	Redeemed    Money  `json:"redeemed"`
+	Reversed    Money  `json:"reversed"`
+}
+
+// DefaultTenantID - программа лояльности, к которой относятся запросы без явной программы
+const DefaultTenantID = "default"
+
+// Tenant - отдельная программа лояльности (бренд) в общей инсталляции
+type Tenant struct {
+	ID                   string   `json:"id"`
+	Hosts                []string `json:"hosts"`                  // имена хостов, по которым определяется программа
+	AccrualSystemAddress string   `json:"accrual_system_address"` // пусто - общий адрес из конфигурации
+	SecretKey            string   `json:"secret_key"`             // ключ подписи JWT программы
+
+	// доступ к служебным API программы: пустой токен или список ключей отключает
+	// соответствующий API. У каждой программы свои, чтобы мерчант или администратор
+	// одной программы не могли действовать в другой
+	AdminToken      string   `json:"admin_token"`
+	MerchantAPIKeys []string `json:"merchant_api_keys"`
+}
+
+// группы маршрутов с собственным ограничением частоты запросов
+const (
+	RateLimitRouteDefault  = "default" // все запросы клиента вместе
+	RateLimitRouteRegister = "register"
+	RateLimitRouteLogin    = "login"
+	RateLimitRouteOrders   = "orders"
+	RateLimitRouteWithdraw = "withdraw"
+)
+
+// RateLimitRoutes - группы маршрутов, для которых можно задать ограничение
+var RateLimitRoutes = []string{
+	RateLimitRouteDefault,
+	RateLimitRouteRegister,
+	RateLimitRouteLogin,
+	RateLimitRouteOrders,
+	RateLimitRouteWithdraw,
+}
+
+// RateLimit - корзина токенов группы маршрутов: Requests запросов за Per,
+// подряд без ожидания - не больше Burst
+type RateLimit struct {
+	Route    string
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+// Interval - за сколько в корзину возвращается один токен
+func (l RateLimit) Interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+// LoginScope - по чему считаются неудачные попытки входа
+type LoginScope string
+
+const (
+	LoginScopeLogin LoginScope = "login"
+	LoginScopeIP    LoginScope = "ip"
+)
+
+// LoginAttempts - неудачные попытки входа с одним логином или с одного IP.
+// Считаются и для несуществующих логинов, чтобы ответ не выдавал, есть ли такой пользователь
+type LoginAttempts struct {
+	TenantID      string     `json:"-"`
+	Scope         LoginScope `json:"scope"`
+	Value         string     `json:"value"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   time.Time  `json:"locked_until"` // нулевое время - блокировки нет
+}
+
+// Locked сообщает, заблокирован ли вход в момент now
+func (a LoginAttempts) Locked(now time.Time) bool {
+	return a.LockedUntil.After(now)
+}
+
+// LoginPolicy - защита входа от перебора паролей: после каждой неудачи с логином следующая
+// попытка возможна только через Delay, удваивающийся с каждой неудачей до MaxDelay, а после
+// MaxFailures неудач по логину или MaxIPFailures с одного IP вход блокируется на Lockout.
+// Паузы по IP нет: за общим NAT ошибки одного пользователя не должны задерживать остальных.
+// Неудачи старше Window забываются (0 в лимите - без блокировки)
+type LoginPolicy struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Window        time.Duration
+	Lockout       time.Duration
+	Delay         time.Duration
+	MaxDelay      time.Duration
+}
+
+// RetryAfter возвращает, через сколько после now разрешена следующая попытка (0 - сразу)
+func (p LoginPolicy) RetryAfter(a LoginAttempts, now time.Time) time.Duration {
+	if a.Locked(now) {
+		return a.LockedUntil.Sub(now)
+	}
+	if a.Scope == LoginScopeIP || a.Failures == 0 || !a.LockedUntil.IsZero() || now.Sub(a.LastFailureAt) >= p.Window {
+		return 0
+	}
+	if next := a.LastFailureAt.Add(p.delay(a.Failures)); next.After(now) {
+		return next.Sub(now)
+	}
+
+	return 0
+}
+
+// Fail возвращает попытки после еще одной неудачи в момент now и признак того,
+// что эта неудача привела к блокировке
+func (p LoginPolicy) Fail(a LoginAttempts, now time.Time) (LoginAttempts, bool) {
+	// после блокировки и после окна счет начинается заново
+	if !a.LockedUntil.IsZero() && !a.Locked(now) || now.Sub(a.LastFailureAt) >= p.Window {
+		a.Failures = 0
+		a.LockedUntil = time.Time{}
+	}
+	a.Failures++
+	a.LastFailureAt = now
+
+	limit := p.MaxFailures
+	if a.Scope == LoginScopeIP {
+		limit = p.MaxIPFailures
+	}
+	if limit > 0 && a.Failures >= limit && a.LockedUntil.IsZero() {
+		a.LockedUntil = now.Add(p.Lockout)
+		return a, true
+	}
+
+	return a, false
+}
+
+// delay - пауза после failures неудач подряд
+func (p LoginPolicy) delay(failures int) time.Duration {
+	delay := p.Delay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.MaxDelay)
+}
+
+// события журнала аудита
+const (
+	AuditEventLoginLocked   = "LOGIN_LOCKED"
+	AuditEventLoginUnlocked = "LOGIN_UNLOCKED"
+	AuditEventTOTPEnabled   = "TOTP_ENABLED"
+	AuditEventTOTPDisabled  = "TOTP_DISABLED"
+	AuditEventRecoveryUsed  = "RECOVERY_CODE_USED"
+
+	AuditEventPasswordChanged    = "PASSWORD_CHANGED"
+	AuditEventPasswordResetAsked = "PASSWORD_RESET_REQUESTED"
+	AuditEventPasswordReset      = "PASSWORD_RESET"
+	AuditEventEmailChanged       = "EMAIL_CHANGED"
+
+	AuditEventDataExported   = "DATA_EXPORTED"
+	AuditEventAccountDeleted = "ACCOUNT_DELETED"
+)
+
+// AuditEvent - запись журнала аудита событий безопасности
+type AuditEvent struct {
+	ID        int64  `json:"id"`
+	TenantID  string `json:"-"`
+	Event     string `json:"event"`
+	Actor     string `json:"actor"` // кто вызвал событие: system, admin, user
+	UserID    int64  `json:"user_id,omitempty"`
+	Login     string `json:"login,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	Details   string `json:"details,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// TOTP - второй фактор пользователя: секрет генератора одноразовых кодов (RFC 6238).
+// Секрет хранится как есть: в отличие от пароля, он нужен для проверки кода
+type TOTP struct {
+	UserID            int64
+	TenantID          string
+	Login             string
+	Secret            string // base32 без выравнивания
+	Confirmed         bool   // второй фактор включен после подтверждения первым кодом
+	LastStep          int64  // последний принятый шаг времени
+	RecoveryCodesLeft int
 }
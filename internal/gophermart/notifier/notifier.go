@@ -0,0 +1,57 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message - уведомление пользователю
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// Notifier доставляет уведомления пользователям: почтой, SMS и т.п.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Log пишет уведомления в журнал приложения, для локального запуска. Журнал здесь -
+// канал доставки, поэтому текст (в нем бывают токены сброса пароля) не маскируется
+type Log struct{}
+
+func (Log) Notify(ctx context.Context, msg Message) error {
+	logger.FromContext(ctx).Infoln("Notification:", "to", logger.Login(msg.To), "subject", msg.Subject, "body", msg.Body)
+
+	return nil
+}
+
+// File дописывает уведомления в файл Path по одному JSON на строку, для локального запуска
+// и автотестов
+type File struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (f *File) Notify(_ context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// уведомления содержат токены сброса пароля: файл доступен только владельцу
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(struct {
+		Message
+		SentAt string `json:"sent_at"`
+	}{msg, time.Now().UTC().Format(time.RFC3339)})
+}
@@ -0,0 +1,38 @@
+package gophermart
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"sync"
+	"time"
+)
+
+// ExpirePoints периодически сжигает партии баллов, срок которых истек
+func ExpirePoints(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if app.PointsTTLMonths <= 0 {
+		return
+	}
+
+	logger.Log.Infoln("Starting points expiry sweeper")
+	ticker := time.NewTicker(app.PointsExpirySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			expired, err := api.Repo.Store.ExpireLots(ctx, time.Now().AddDate(0, -app.PointsTTLMonths, 0))
+			if err != nil {
+				logger.Log.Errorln("failed ExpireLots()=", err)
+				continue
+			}
+			if expired > 0 {
+				logger.Log.Infoln("Expired points lots:", expired)
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package gophermart
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/middleware"
+	"sync"
+	"time"
+)
+
+// SweepRateLimits периодически удаляет полные корзины ограничения частоты запросов
+func SweepRateLimits(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	limiter := middleware.GetRateLimiter()
+	if limiter == nil {
+		return
+	}
+
+	logger.Log.Infoln("Starting rate limits sweeper")
+	ticker := time.NewTicker(app.RateLimitSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := limiter.Sweep(ctx); err != nil {
+				logger.Log.Errorln("failed Sweep()=", err)
+			}
+		}
+	}
+}
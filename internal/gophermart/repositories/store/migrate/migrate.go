@@ -0,0 +1,321 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration - одна версия схемы, собранная из пары файлов
+// <version>_<name>.up.sql и <version>_<name>.down.sql
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status - состояние миграции в БД
+type Status struct {
+	Version   int64  `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt string `json:"applied_at,omitempty"`
+}
+
+// Migrator применяет и откатывает миграции.
+// Все операции выполняются на одном закрепленном соединении, чтобы
+// Lock и Unlock (например, advisory lock в PostgreSQL) действовали в одной сессии.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+	// Table - таблица с примененными версиями, например gophermart.schema_migrations
+	Table string
+	// Prepare выполняется до создания Table (например, CREATE SCHEMA)
+	Prepare []string
+	// Lock и Unlock защищают от одновременного запуска миграций несколькими репликами
+	Lock   func(ctx context.Context, conn *sql.Conn) error
+	Unlock func(ctx context.Context, conn *sql.Conn) error
+}
+
+// Load читает миграции из каталога dir файловой системы fsys
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: expected .up.sql or .down.sql suffix", entry.Name())
+		}
+		name = strings.TrimSuffix(name, "."+direction)
+
+		rawVersion, title, _ := strings.Cut(name, "_")
+		version, err := strconv.ParseInt(rawVersion, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version: %w", entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d: name mismatch %q and %q", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s: missing up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up применяет все еще не примененные миграции и возвращает их количество
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var count int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		for _, migration := range m.Migrations {
+			applied, err := m.apply(ctx, conn, migration)
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			if applied {
+				count++
+			}
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Down откатывает steps последних примененных миграций и возвращает их количество
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var count int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if err := m.revert(ctx, conn, migration); err != nil {
+				return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+			}
+			count++
+		}
+		return nil
+	})
+
+	return count, err
+}
+
+// Status возвращает состояние всех известных миграций
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.prepare(ctx, conn); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		appliedAt, ok := applied[migration.Version]
+		statuses = append(statuses, Status{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+
+	return statuses, nil
+}
+
+// Pending возвращает количество еще не примененных миграций
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending int
+	for _, status := range statuses {
+		if !status.Applied {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.Lock != nil {
+		if err := m.Lock(ctx, conn); err != nil {
+			return fmt.Errorf("acquire migration lock: %w", err)
+		}
+		defer m.Unlock(context.Background(), conn)
+	}
+
+	if err := m.prepare(ctx, conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) prepare(ctx context.Context, conn *sql.Conn) error {
+	for _, query := range m.Prepare {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			version BIGINT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at VARCHAR(50) NOT NULL
+		)
+	`, m.Table))
+
+	return err
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]string, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT version, applied_at FROM %s`, m.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]string)
+	for rows.Next() {
+		var version int64
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration) (bool, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	// в случае неуспешного коммита все изменения транзакции будут отменены
+	defer tx.Rollback()
+
+	// версию проверяем внутри транзакции: если Lock не задан, конкурент
+	// мог применить ее между чтением списка и началом транзакции
+	var exists int
+	err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE version = $1`, m.Table), migration.Version).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (version, name, applied_at) VALUES($1, $2, $3)`, m.Table),
+		migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if migration.Down == "" {
+		return fmt.Errorf("missing down file")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// в случае неуспешного коммита все изменения транзакции будут отменены
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE version = $1`, m.Table), migration.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
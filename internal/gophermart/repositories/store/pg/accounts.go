@@ -0,0 +1,112 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) GetLedger(ctx context.Context, userID int64) ([]models.LedgerEntry, error) {
+	var entries []models.LedgerEntry
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT id, operation, amount, "order", reason, ref, created_at
+				FROM gophermart.ledger
+					WHERE user_id = $1
+					ORDER BY created_at DESC, id DESC
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		entries = nil
+		for rows.Next() {
+			e := models.LedgerEntry{UserID: userID}
+			var amount models.Money
+			var order, reason, ref pgtype.Text
+			var createdAt time.Time
+			err = rows.Scan(&e.ID, &e.Operation, &amount, &order, &reason, &ref, &createdAt)
+			if err != nil {
+				return err
+			}
+			e.Amount = models.Money(amount.Get())
+			e.Order = order.String
+			e.Reason = reason.String
+			e.Ref = ref.String
+			e.CreatedAt = createdAt.Format(time.RFC3339)
+			entries = append(entries, e)
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, userID int64) error {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var tenantID, login string
+	err = tx.QueryRow(ctx, `
+		SELECT tenant_id, login FROM gophermart.users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE
+	`, userID).Scan(&tenantID, &login)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return api.ErrNotFound
+	case err != nil:
+		return err
+	}
+
+	// пустой пароль не совпадает ни с одним хэшем, новая версия отзывает токены
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.users
+			SET login = $2, password = '', email = NULL, invite_code = NULL,
+				token_version = token_version + 1, deleted_at = NOW()
+					WHERE id = $1
+	`, userID, models.DeletedLoginPrefix+strconv.FormatInt(userID, 10))
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM gophermart.totp WHERE user_id = $1`,
+		`DELETE FROM gophermart.recovery_codes WHERE user_id = $1`,
+		`DELETE FROM gophermart.password_resets WHERE user_id = $1`,
+	} {
+		if _, err := tx.Exec(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	// логин и адреса не остаются и в служебных записях
+	_, err = tx.Exec(ctx, `
+		DELETE FROM gophermart.login_attempts WHERE tenant_id = $1 AND scope = $2 AND value = $3
+	`, tenantID, models.LoginScopeLogin, login)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.audit_log SET login = NULL, ip = NULL
+			WHERE user_id = $1 OR tenant_id = $2 AND login = $3
+	`, userID, tenantID, login)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,221 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const campaignColumns = `id, name, starts_at, ends_at, first_order, min_accrual, segment,
+	multiplier, bonus, budget, spent, active, created_at`
+
+func (s *Store) CreateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	row := s.Pool.QueryRow(ctx, `
+		INSERT INTO gophermart.campaigns (name, starts_at, ends_at, first_order, min_accrual, segment, multiplier, bonus, budget, active, tenant_id)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+				RETURNING `+campaignColumns,
+		campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.FirstOrder, campaign.MinAccrual,
+		strings.Join(campaign.Segment, ","), campaign.Multiplier, campaign.Bonus, campaign.Budget, campaign.Active, campaign.TenantID)
+
+	return scanCampaign(row)
+}
+
+func (s *Store) GetCampaigns(ctx context.Context, tenantID string) ([]models.Campaign, error) {
+	rows, err := s.Pool.Query(ctx, `
+		SELECT `+campaignColumns+` FROM gophermart.campaigns
+			WHERE deleted_at IS NULL AND tenant_id = $1
+				ORDER BY starts_at DESC, id DESC
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []models.Campaign
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return campaigns, nil
+}
+
+func (s *Store) GetCampaign(ctx context.Context, tenantID string, id int64) (*models.Campaign, error) {
+	row := s.Pool.QueryRow(ctx, `
+		SELECT `+campaignColumns+` FROM gophermart.campaigns
+			WHERE id = $1 AND deleted_at IS NULL AND tenant_id = $2
+	`, id, tenantID)
+
+	return scanCampaign(row)
+}
+
+func (s *Store) UpdateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	// потраченная часть бюджета не меняется: ее ведет только начисление бонусов
+	row := s.Pool.QueryRow(ctx, `
+		UPDATE gophermart.campaigns
+			SET name = $2, starts_at = $3, ends_at = $4, first_order = $5, min_accrual = $6,
+				segment = $7, multiplier = $8, bonus = $9, budget = $10, active = $11
+					WHERE id = $1 AND deleted_at IS NULL AND tenant_id = $12
+						RETURNING `+campaignColumns,
+		campaign.ID, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.FirstOrder, campaign.MinAccrual,
+		strings.Join(campaign.Segment, ","), campaign.Multiplier, campaign.Bonus, campaign.Budget, campaign.Active, campaign.TenantID)
+
+	return scanCampaign(row)
+}
+
+func (s *Store) DeleteCampaign(ctx context.Context, tenantID string, id int64) error {
+	// акция только помечается удаленной: на нее ссылаются начисленные бонусы
+	tag, err := s.Pool.Exec(ctx, `
+		UPDATE gophermart.campaigns SET deleted_at = NOW(), active = FALSE
+			WHERE id = $1 AND deleted_at IS NULL AND tenant_id = $2
+	`, id, tenantID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+// awardCampaigns начисляет бонусы действующих акций за заказ в рамках транзакции tx
+// и возвращает их сумму. Вызывается до отметки заказа зачисленным, чтобы признак
+// первого заказа был верным
+func awardCampaigns(ctx context.Context, tx pgx.Tx, order models.Order) (models.Money, error) {
+	// блокировка акций не дает параллельным начислениям превысить бюджет
+	rows, err := tx.Query(ctx, `
+		SELECT `+campaignColumns+` FROM gophermart.campaigns
+			WHERE active AND deleted_at IS NULL AND starts_at <= NOW() AND ends_at > NOW()
+				AND (budget = 0 OR spent < budget) AND tenant_id = $1
+					ORDER BY id
+						FOR UPDATE
+	`, order.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	campaigns, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Campaign, error) {
+		campaign, err := scanCampaignCents(row)
+		if err != nil {
+			return models.Campaign{}, err
+		}
+		return *campaign, nil
+	})
+	if err != nil || len(campaigns) == 0 {
+		return 0, err
+	}
+
+	var firstOrder bool
+	err = tx.QueryRow(ctx, `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM gophermart.orders
+				WHERE user_id = $1 AND credited_at IS NOT NULL AND number != $2
+		)
+	`, order.UserID, order.Number).Scan(&firstOrder)
+	if err != nil {
+		return 0, err
+	}
+	var tier string
+	err = tx.QueryRow(ctx, `
+		SELECT tier FROM gophermart.user_tiers WHERE user_id = $1
+	`, order.UserID).Scan(&tier)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, err
+	}
+
+	var awarded models.Money
+	for _, campaign := range campaigns {
+		award := campaign.Award(order.Accrual, firstOrder, tier)
+		if award <= 0 {
+			continue
+		}
+
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO gophermart.campaign_awards (campaign_id, user_id, "order", amount) VALUES($1, $2, $3, $4)
+				ON CONFLICT (campaign_id, "order") DO NOTHING
+		`, campaign.ID, order.UserID, order.Number, award)
+		if err != nil {
+			return 0, err
+		}
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE gophermart.campaigns SET spent = spent + $1
+				WHERE id = $2
+		`, award, campaign.ID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE gophermart.balance SET current = current + $1
+				WHERE user_id = $2
+		`, award, order.UserID)
+		if err != nil {
+			return 0, err
+		}
+		if err := creditLot(ctx, tx, order.UserID, models.LotSourceCampaign, order.Number, award, nil); err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO gophermart.ledger (user_id, operation, amount, "order", reason, ref) VALUES($1, $2, $3, $4, $5, $6)
+		`, order.UserID, models.LedgerOperationCampaignBonus, award, order.Number, campaign.Name, strconv.FormatInt(campaign.ID, 10))
+		if err != nil {
+			return 0, err
+		}
+		awarded += award
+	}
+
+	return awarded, nil
+}
+
+// scanCampaign читает акцию с суммами в баллах
+func scanCampaign(row pgx.Row) (*models.Campaign, error) {
+	campaign, err := scanCampaignCents(row)
+	if err != nil {
+		return nil, err
+	}
+	campaign.MinAccrual = models.Money(campaign.MinAccrual.Get())
+	campaign.Bonus = models.Money(campaign.Bonus.Get())
+	campaign.Budget = models.Money(campaign.Budget.Get())
+	campaign.Spent = models.Money(campaign.Spent.Get())
+
+	return campaign, nil
+}
+
+// scanCampaignCents читает акцию с суммами в копейках, как они хранятся
+func scanCampaignCents(row pgx.Row) (*models.Campaign, error) {
+	var campaign models.Campaign
+	var segment string
+	var startsAt, endsAt, createdAt time.Time
+	err := row.Scan(&campaign.ID, &campaign.Name, &startsAt, &endsAt, &campaign.FirstOrder, &campaign.MinAccrual,
+		&segment, &campaign.Multiplier, &campaign.Bonus, &campaign.Budget, &campaign.Spent, &campaign.Active, &createdAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if segment != "" {
+		campaign.Segment = strings.Split(segment, ",")
+	}
+	campaign.StartsAt = startsAt.Format(time.RFC3339)
+	campaign.EndsAt = endsAt.Format(time.RFC3339)
+	campaign.CreatedAt = createdAt.Format(time.RFC3339)
+
+	return &campaign, nil
+}
@@ -0,0 +1,260 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+func (s *Store) AuthorizeHold(ctx context.Context, hold models.Hold) (*models.Hold, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// по номеру заказа резерв может быть только один, повтор возвращает уже созданный
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO gophermart.holds ("order", user_id, sum, status, expires_at, tenant_id) VALUES($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (tenant_id, "order") DO NOTHING
+	`, hold.Order, hold.UserID, hold.Sum, models.HoldStateHeld, hold.ExpiresAt, hold.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		existing, err := getHold(ctx, tx, hold.TenantID, hold.Order)
+		if err != nil {
+			return nil, err
+		}
+		if existing.UserID != hold.UserID || existing.Sum != models.Money(hold.Sum.Get()) {
+			return nil, api.ErrIdempotencyMismatch
+		}
+		return existing, nil
+	}
+
+	// заказ уже оплачен баллами напрямую
+	var paid bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM gophermart.withdrawals WHERE "order" = $1 AND tenant_id = $2)
+	`, hold.Order, hold.TenantID).Scan(&paid)
+	if err != nil {
+		return nil, err
+	}
+	if paid {
+		return nil, api.ErrIdempotencyMismatch
+	}
+
+	tag, err = tx.Exec(ctx, `
+		UPDATE gophermart.balance
+			SET current = current - $1, reserved = reserved + $1
+				WHERE user_id = $2 AND current - $1 >= 0
+	`, hold.Sum, hold.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, api.ErrNotEnoughMoney
+	}
+
+	// баллы уходят из партий уже при резерве, чтобы сгорание не задело удержанные
+	earnedAt, err := consumeLots(ctx, tx, hold.UserID, hold.Sum)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.holds SET earned_at = $1
+			WHERE "order" = $2 AND user_id = $3
+	`, earnedAt, hold.Order, hold.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := getHold(ctx, tx, hold.TenantID, hold.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	return created, tx.Commit(ctx)
+}
+
+func (s *Store) CaptureHold(ctx context.Context, userID int64, order string) (*models.Hold, bool, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	hold, sum, err := lockHold(ctx, tx, userID, order, models.HoldStateCaptured)
+	if err != nil || hold.Status == models.HoldStateCaptured {
+		return hold, false, err
+	}
+
+	// списание то же, что и обычное, но баллы берутся из резерва
+	err = setWithdrawal(ctx, tx, models.Withdrawal{
+		Order:    order,
+		UserID:   userID,
+		TenantID: hold.TenantID,
+		Sum:      sum,
+	}, fromReserved)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := updateHoldStatus(ctx, tx, userID, order, models.HoldStateCaptured); err != nil {
+		return nil, false, err
+	}
+	hold.Status = models.HoldStateCaptured
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, false, err
+	}
+
+	return hold, true, nil
+}
+
+func (s *Store) VoidHold(ctx context.Context, userID int64, order string) (*models.Hold, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	hold, sum, err := lockHold(ctx, tx, userID, order, models.HoldStateVoided)
+	if err != nil || hold.Status == models.HoldStateVoided {
+		return hold, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.balance SET current = current + $1, reserved = reserved - $1
+			WHERE user_id = $2
+	`, sum, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := releaseLot(ctx, tx, userID, order); err != nil {
+		return nil, err
+	}
+
+	if err := updateHoldStatus(ctx, tx, userID, order, models.HoldStateVoided); err != nil {
+		return nil, err
+	}
+	hold.Status = models.HoldStateVoided
+
+	return hold, tx.Commit(ctx)
+}
+
+func (s *Store) ExpireHolds(ctx context.Context) (int64, error) {
+	// SKIP LOCKED не дает фоновой задаче ждать резервы, которые прямо сейчас
+	// захватываются или отменяются, а реплики не истекают один резерв дважды
+	var expired int64
+	err := s.Pool.QueryRow(ctx, `
+		WITH expired AS (
+			UPDATE gophermart.holds SET status = $1
+				WHERE (tenant_id, "order") IN (
+					SELECT tenant_id, "order" FROM gophermart.holds
+						WHERE status = $2 AND expires_at <= NOW()
+							FOR UPDATE SKIP LOCKED
+				)
+					RETURNING user_id, "order", sum, earned_at
+		), released AS (
+			UPDATE gophermart.balance
+				SET current = balance.current + e.sum, reserved = balance.reserved - e.sum
+					FROM (SELECT user_id, SUM(sum) AS sum FROM expired GROUP BY user_id) e
+						WHERE balance.user_id = e.user_id
+		), lots AS (
+			INSERT INTO gophermart.lots (user_id, source, "order", amount, remaining, earned_at)
+				SELECT user_id, $3, "order", sum, sum, COALESCE(earned_at, NOW()) FROM expired
+		)
+		SELECT COUNT(*) FROM expired
+	`, models.HoldStateExpired, models.HoldStateHeld, models.LotSourceRelease).Scan(&expired)
+
+	return expired, err
+}
+
+// lockHold блокирует активный резерв пользователя и возвращает его с суммой в копейках.
+// Если резерв уже в состоянии final, он возвращается как есть - это повтор запроса
+func lockHold(ctx context.Context, tx pgx.Tx, userID int64, order string, final models.HoldState) (*models.Hold, models.Money, error) {
+	var sum models.Money
+	var tenantID, status string
+	var expiresAt, createdAt time.Time
+	var expired bool
+	err := tx.QueryRow(ctx, `
+		SELECT tenant_id, sum, status, expires_at, created_at, expires_at <= NOW() FROM gophermart.holds
+			WHERE "order" = $1 AND user_id = $2
+				FOR UPDATE
+	`, order, userID).Scan(&tenantID, &sum, &status, &expiresAt, &createdAt, &expired)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, 0, api.ErrNotFound
+	case err != nil:
+		return nil, 0, err
+	}
+
+	hold := &models.Hold{
+		Order:     order,
+		UserID:    userID,
+		TenantID:  tenantID,
+		Sum:       models.Money(sum.Get()),
+		Status:    models.HoldState(status),
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}
+	if hold.Status == final {
+		return hold, sum, nil
+	}
+	if hold.Status != models.HoldStateHeld || expired {
+		return nil, 0, api.ErrHoldClosed
+	}
+
+	return hold, sum, nil
+}
+
+// releaseLot возвращает баллы резерва партией с датой израсходованных им партий
+func releaseLot(ctx context.Context, tx pgx.Tx, userID int64, order string) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO gophermart.lots (user_id, source, "order", amount, remaining, earned_at)
+			SELECT user_id, $2, "order", sum, sum, COALESCE(earned_at, NOW()) FROM gophermart.holds
+				WHERE "order" = $1 AND user_id = $3
+	`, order, models.LotSourceRelease, userID)
+
+	return err
+}
+
+func updateHoldStatus(ctx context.Context, tx pgx.Tx, userID int64, order string, status models.HoldState) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE gophermart.holds SET status = $1
+			WHERE "order" = $2 AND user_id = $3
+	`, status, order, userID)
+
+	return err
+}
+
+func getHold(ctx context.Context, tx pgx.Tx, tenantID, order string) (*models.Hold, error) {
+	var userID int64
+	var sum models.Money
+	var status string
+	var expiresAt, createdAt time.Time
+	err := tx.QueryRow(ctx, `
+		SELECT user_id, sum, status, expires_at, created_at FROM gophermart.holds
+			WHERE "order" = $1 AND tenant_id = $2
+	`, order, tenantID).Scan(&userID, &sum, &status, &expiresAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Hold{
+		Order:     order,
+		UserID:    userID,
+		TenantID:  tenantID,
+		Sum:       models.Money(sum.Get()),
+		Status:    models.HoldState(status),
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}, nil
+}
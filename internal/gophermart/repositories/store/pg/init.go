@@ -3,135 +3,100 @@ package pg
 import (
 	"context"
 	"database/sql"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"embed"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/migrate"
 	"time"
 )
 
-// Bootstrap подготавливает БД к работе, создавая необходимые таблицы и индексы
-func Bootstrap(ctx context.Context, conn *sql.DB) error {
-	// запускаем транзакцию
-	tx, err := conn.BeginTx(ctx, nil)
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLockKey - ключ advisory lock, под которым реплики применяют миграции по очереди
+const migrationLockKey = 7_140_917_265
+
+// NewMigrator создает мигратор схемы gophermart со встроенными миграциями
+func NewMigrator(conn *sql.DB) (*migrate.Migrator, error) {
+	list, err := migrate.Load(migrations, "migrations")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// в случае неуспешного коммита все изменения транзакции будут отменены
-	defer tx.Rollback()
-
-	// создаем схему
-	tx.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS gophermart`)
-	tx.ExecContext(ctx, `SET search_path TO gophermart`)
-
-	// создаем таблицы и индексы
-	tx.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS users (
-			id BIGSERIAL PRIMARY KEY,
-			login VARCHAR(50) NOT NULL,
-		    password VARCHAR(100) NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
-	tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS user_idx ON users (login)`)
-	// orders:
-	tx.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS orders (
-		    number VARCHAR(50) NOT NULL,
-			user_id BIGINT NOT NULL,
-			accrual BIGINT,
-			status VARCHAR(25) NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
-	tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS order_idx ON orders (number)`)
-	// balance:
-	tx.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS balance (
-			user_id BIGINT NOT NULL,
-			current BIGINT NOT NULL,
-			withdrawn BIGINT NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
-			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
-	tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS balance_idx ON balance (user_id)`)
-	// withdrawals:
-	tx.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS withdrawals (
-			"order" VARCHAR(50) NOT NULL,
-		    user_id BIGINT NOT NULL,
-			"sum" BIGINT NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
-		)
-	`)
-	tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_idx ON withdrawals ("order")`)
-
-	// триггер для поля updated_at
-	tx.ExecContext(ctx, `
-		CREATE OR REPLACE FUNCTION updated_at()
-		RETURNS TRIGGER AS $$
-		BEGIN
-			NEW.updated_at = now();
-			RETURN NEW;
-		END;
-		$$ language 'plpgsql';
-	`)
-	tx.ExecContext(ctx, `
-		DO
-		$$BEGIN
-			CREATE TRIGGER orders_updated_at
-				BEFORE UPDATE
-				ON
-					gophermart.orders
-				FOR EACH ROW
-			EXECUTE PROCEDURE updated_at();
-		EXCEPTION
-		   WHEN duplicate_object THEN
-			  NULL;
-		END;$$;
-	`)
-	tx.ExecContext(ctx, `
-		DO
-		$$BEGIN
-			CREATE TRIGGER balance_updated_at
-				BEFORE UPDATE
-				ON
-					gophermart.balance
-				FOR EACH ROW
-			EXECUTE PROCEDURE updated_at();
-		EXCEPTION
-		   WHEN duplicate_object THEN
-			  NULL;
-		END;$$;
-	`)
-
-	// коммитим транзакцию
-	return tx.Commit()
+	return &migrate.Migrator{
+		DB:         conn,
+		Migrations: list,
+		Table:      "gophermart.schema_migrations",
+		Prepare:    []string{`CREATE SCHEMA IF NOT EXISTS gophermart`},
+		Lock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey)
+			return err
+		},
+		Unlock: func(ctx context.Context, conn *sql.Conn) error {
+			_, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+			return err
+		},
+	}, nil
 }
 
-func OpenDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", dsn)
+// PoolConfig разбирает DSN и применяет к нему настройки пула из конфигурации
+func PoolConfig(dsn string, app config.AppConfig) (*pgxpool.Config, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
+	if app.StoreMaxConns > 0 {
+		cfg.MaxConns = app.StoreMaxConns
+	}
+	cfg.MinConns = app.StoreMinConns
+	if app.StoreMaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = app.StoreMaxConnLifetime
+	}
+	if app.StoreMaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = app.StoreMaxConnIdleTime
+	}
+
+	// кеш подготовленных выражений живет в каждом соединении пула,
+	// при нулевой емкости каждый запрос описывается заново без кеширования
+	cfg.ConnConfig.StatementCacheCapacity = app.StoreStatementCacheCapacity
+	if app.StoreStatementCacheCapacity == 0 {
+		cfg.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	}
+
+	return cfg, nil
+}
+
+func OpenDB(ctx context.Context, cfg *pgxpool.Config) (*pgxpool.Pool, error) {
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pool.Ping(ctx)
 	if err != nil {
+		pool.Close()
 		return nil, err
 	}
 
-	return db, nil
+	return pool, nil
 }
 
-func ConnectToDB(dsn string) (*sql.DB, error) {
+func ConnectToDB(ctx context.Context, dsn string, app config.AppConfig) (*pgxpool.Pool, error) {
+	cfg, err := PoolConfig(dsn, app)
+	if err != nil {
+		return nil, err
+	}
+
 	// ретраи для переподключения к базе при старте
 	// 1s, 3s, 5s
 	backoff := [3]int{1, 3, 5}
 	var cnt = 0
 
 	for {
-		connection, err := OpenDB(dsn)
+		connection, err := OpenDB(ctx, cfg)
 		if err != nil {
 			logger.Log.Infoln("Postgres not yet ready...")
 			cnt++
@@ -0,0 +1,232 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+const loginAttemptColumns = `tenant_id, scope, value, failures, last_failure_at, locked_until`
+
+func (s *Store) GetLoginAttempts(ctx context.Context, tenantID, login, ip string) ([]models.LoginAttempts, error) {
+	rows, err := s.Pool.Query(ctx, `
+		SELECT `+loginAttemptColumns+` FROM gophermart.login_attempts
+			WHERE tenant_id = $1 AND (scope = $2 AND value = $3 OR scope = $4 AND value = $5)
+	`, tenantID, models.LoginScopeLogin, login, models.LoginScopeIP, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempts
+	for rows.Next() {
+		a, err := scanLoginAttempts(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, *a)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, tenantID, login, ip string, policy models.LoginPolicy) error {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// счетчики блокируются в одном порядке (ip < login), параллельные неудачи
+	// с одним логином или IP не теряются и не ждут друг друга по кругу
+	now := time.Now()
+	for _, key := range []models.LoginAttempts{
+		{TenantID: tenantID, Scope: models.LoginScopeIP, Value: ip},
+		{TenantID: tenantID, Scope: models.LoginScopeLogin, Value: login},
+	} {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO gophermart.login_attempts (tenant_id, scope, value, last_failure_at) VALUES($1, $2, $3, $4)
+				ON CONFLICT (tenant_id, scope, value) DO NOTHING
+		`, key.TenantID, key.Scope, key.Value, now)
+		if err != nil {
+			return err
+		}
+		current, err := scanLoginAttempts(tx.QueryRow(ctx, `
+			SELECT `+loginAttemptColumns+` FROM gophermart.login_attempts
+				WHERE tenant_id = $1 AND scope = $2 AND value = $3
+					FOR UPDATE
+		`, key.TenantID, key.Scope, key.Value))
+		if err != nil {
+			return err
+		}
+
+		attempts, locked := policy.Fail(*current, now)
+		var lockedUntil *time.Time
+		if !attempts.LockedUntil.IsZero() {
+			lockedUntil = &attempts.LockedUntil
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE gophermart.login_attempts SET failures = $4, last_failure_at = $5, locked_until = $6
+				WHERE tenant_id = $1 AND scope = $2 AND value = $3
+		`, attempts.TenantID, attempts.Scope, attempts.Value, attempts.Failures, attempts.LastFailureAt, lockedUntil)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			err = insertAuditEvent(ctx, tx, models.AuditEvent{
+				TenantID: tenantID,
+				Event:    models.AuditEventLoginLocked,
+				Actor:    "system",
+				Login:    login,
+				IP:       ip,
+				Details: fmt.Sprintf("%s locked after %d failed attempts until %s",
+					attempts.Scope, attempts.Failures, attempts.LockedUntil.UTC().Format(time.RFC3339)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *Store) ResetLoginAttempts(ctx context.Context, tenantID string, scope models.LoginScope, value string) (bool, error) {
+	var locked bool
+	err := s.Pool.QueryRow(ctx, `
+		DELETE FROM gophermart.login_attempts
+			WHERE tenant_id = $1 AND scope = $2 AND value = $3
+				RETURNING COALESCE(locked_until > NOW(), FALSE)
+	`, tenantID, scope, value).Scan(&locked)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return locked, nil
+}
+
+func (s *Store) GetLoginLockouts(ctx context.Context, tenantID string) ([]models.LoginAttempts, error) {
+	var lockouts []models.LoginAttempts
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT `+loginAttemptColumns+` FROM gophermart.login_attempts
+				WHERE tenant_id = $1 AND locked_until > NOW()
+					ORDER BY locked_until
+		`, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		lockouts = nil
+		for rows.Next() {
+			a, err := scanLoginAttempts(rows)
+			if err != nil {
+				return err
+			}
+			lockouts = append(lockouts, *a)
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+func (s *Store) DeleteStaleLoginAttempts(ctx context.Context, failedBefore time.Time) (int64, error) {
+	tag, err := s.Pool.Exec(ctx, `
+		DELETE FROM gophermart.login_attempts
+			WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until <= NOW())
+	`, failedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+func (s *Store) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	return insertAuditEvent(ctx, s.Pool, event)
+}
+
+func (s *Store) GetAuditEvents(ctx context.Context, tenantID, event string, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT id, event, actor, user_id, login, ip, details, created_at FROM gophermart.audit_log
+				WHERE tenant_id = $1 AND ($2 = '' OR event = $2)
+					ORDER BY id DESC
+						LIMIT $3
+		`, tenantID, event, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		events = nil
+		for rows.Next() {
+			var e models.AuditEvent
+			var userID pgtype.Int8
+			var login, ip, details pgtype.Text
+			var createdAt time.Time
+			err = rows.Scan(&e.ID, &e.Event, &e.Actor, &userID, &login, &ip, &details, &createdAt)
+			if err != nil {
+				return err
+			}
+			e.TenantID = tenantID
+			e.UserID = userID.Int64
+			e.Login = login.String
+			e.IP = ip.String
+			e.Details = details.String
+			e.CreatedAt = createdAt.Format(time.RFC3339)
+			events = append(events, e)
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func insertAuditEvent(ctx context.Context, q querier, event models.AuditEvent) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO gophermart.audit_log (tenant_id, event, actor, user_id, login, ip, details)
+			VALUES($1, $2, $3, NULLIF($4::BIGINT, 0), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))
+	`, event.TenantID, event.Event, event.Actor, event.UserID, event.Login, event.IP, event.Details)
+
+	return err
+}
+
+func scanLoginAttempts(row pgx.Row) (*models.LoginAttempts, error) {
+	var a models.LoginAttempts
+	var lockedUntil pgtype.Timestamptz
+	err := row.Scan(&a.TenantID, &a.Scope, &a.Value, &a.Failures, &a.LastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	a.LockedUntil = lockedUntil.Time
+
+	return &a, nil
+}
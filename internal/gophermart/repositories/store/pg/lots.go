@@ -0,0 +1,232 @@
+package pg
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+func (s *Store) GetExpiringLots(ctx context.Context, userID int64, earnedBefore time.Time) ([]models.Lot, error) {
+	var lots []models.Lot
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT id, source, "order", remaining, earned_at FROM gophermart.lots
+				WHERE user_id = $1 AND remaining > 0 AND earned_at <= $2
+					ORDER BY earned_at, id
+		`, userID, earnedBefore)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		lots = nil
+		for rows.Next() {
+			var id int64
+			var source string
+			var order pgtype.Text
+			var remaining models.Money
+			var earnedAt time.Time
+			err = rows.Scan(&id, &source, &order, &remaining, &earnedAt)
+			if err != nil {
+				return err
+			}
+			lots = append(lots, models.Lot{
+				ID:       id,
+				UserID:   userID,
+				Source:   models.LotSource(source),
+				Order:    order.String,
+				Amount:   models.Money(remaining.Get()),
+				EarnedAt: earnedAt.Format(time.RFC3339),
+			})
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+func (s *Store) GetExpiringBalances(ctx context.Context, tenantID string, earnedBefore time.Time) ([]models.Balance, error) {
+	var balances []models.Balance
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT l.user_id, SUM(l.remaining) FROM gophermart.lots l
+				JOIN gophermart.users u ON u.id = l.user_id
+					WHERE l.remaining > 0 AND l.earned_at <= $1 AND u.tenant_id = $2
+					GROUP BY l.user_id
+					ORDER BY l.user_id
+		`, earnedBefore, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		balances = nil
+		for rows.Next() {
+			var userID int64
+			var expiring models.Money
+			if err = rows.Scan(&userID, &expiring); err != nil {
+				return err
+			}
+			balances = append(balances, models.Balance{
+				UserID:   userID,
+				TenantID: tenantID,
+				Expiring: models.Money(expiring.Get()),
+			})
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (s *Store) ExpireLots(ctx context.Context, earnedBefore time.Time) (int64, error) {
+	rows, err := s.Pool.Query(ctx, `
+		SELECT DISTINCT user_id FROM gophermart.lots
+			WHERE remaining > 0 AND earned_at <= $1
+	`, earnedBefore)
+	if err != nil {
+		return 0, err
+	}
+	users, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, err
+	}
+
+	// каждый пользователь сгорает отдельной транзакцией: ошибка по одному
+	// не откатывает остальных, а блокировки держатся недолго
+	var expired int64
+	for _, userID := range users {
+		n, err := s.expireUserLots(ctx, userID, earnedBefore)
+		if err != nil {
+			return expired, err
+		}
+		expired += n
+	}
+
+	return expired, nil
+}
+
+func (s *Store) expireUserLots(ctx context.Context, userID int64, earnedBefore time.Time) (int64, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// баланс блокируется раньше партий - в том же порядке, что и при списании,
+	// иначе фоновая задача и списание могли бы взаимно заблокироваться
+	_, err = tx.Exec(ctx, `
+		SELECT 1 FROM gophermart.balance WHERE user_id = $1 FOR UPDATE
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var expired int64
+	err = tx.QueryRow(ctx, `
+		WITH expired AS (
+			UPDATE gophermart.lots SET remaining = 0, expired_at = NOW()
+				FROM (
+					SELECT id, remaining FROM gophermart.lots
+						WHERE user_id = $1 AND remaining > 0 AND earned_at <= $2
+							FOR UPDATE
+				) AS old
+					WHERE lots.id = old.id
+						RETURNING lots.user_id, lots.source, lots."order", old.remaining
+		), logged AS (
+			INSERT INTO gophermart.ledger (user_id, operation, amount, "order", ref)
+				SELECT user_id, $3, remaining, "order", source FROM expired
+		), debited AS (
+			UPDATE gophermart.balance
+				SET current = GREATEST(balance.current - e.sum, 0)
+					FROM (SELECT SUM(remaining) AS sum FROM expired) e
+						WHERE balance.user_id = $1 AND e.sum IS NOT NULL
+		)
+		SELECT COUNT(*) FROM expired
+	`, userID, earnedBefore, models.LedgerOperationExpiry).Scan(&expired)
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, tx.Commit(ctx)
+}
+
+// creditLot заводит партию начисленных баллов. Без earnedAt партия считается
+// начисленной сейчас
+func creditLot(ctx context.Context, tx pgx.Tx, userID int64, source models.LotSource, order string, amount models.Money, earnedAt *time.Time) error {
+	if amount <= 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO gophermart.lots (user_id, source, "order", amount, remaining, earned_at)
+			VALUES($1, $2, NULLIF($3, ''), $4, $4, COALESCE($5, NOW()))
+	`, userID, source, order, amount, earnedAt)
+
+	return err
+}
+
+// consumeLots расходует партии пользователя в порядке начисления и возвращает
+// дату самой старой из затронутых. Баланс к этому моменту уже заблокирован
+// списанием в той же транзакции
+func consumeLots(ctx context.Context, tx pgx.Tx, userID int64, amount models.Money) (*time.Time, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, remaining, earned_at FROM gophermart.lots
+			WHERE user_id = $1 AND remaining > 0
+				ORDER BY earned_at, id
+					FOR UPDATE
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	type take struct {
+		id     int64
+		amount int64
+	}
+	var takes []take
+	var oldest *time.Time
+	left := int64(amount)
+	for rows.Next() && left > 0 {
+		var id, remaining int64
+		var earnedAt time.Time
+		if err := rows.Scan(&id, &remaining, &earnedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if oldest == nil {
+			oldest = &earnedAt
+		}
+		n := min(remaining, left)
+		takes = append(takes, take{id: id, amount: n})
+		left -= n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range takes {
+		_, err := tx.Exec(ctx, `
+			UPDATE gophermart.lots SET remaining = remaining - $1
+				WHERE id = $2
+		`, t.amount, t.id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return oldest, nil
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS gophermart.withdrawals;
+DROP TABLE IF EXISTS gophermart.balance;
+DROP TABLE IF EXISTS gophermart.orders;
+DROP TABLE IF EXISTS gophermart.users;
+DROP FUNCTION IF EXISTS gophermart.updated_at();
@@ -0,0 +1,73 @@
+-- users:
+CREATE TABLE IF NOT EXISTS gophermart.users (
+    id BIGSERIAL PRIMARY KEY,
+    login VARCHAR(50) NOT NULL,
+    password VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS user_idx ON gophermart.users (login);
+
+-- orders:
+CREATE TABLE IF NOT EXISTS gophermart.orders (
+    number VARCHAR(50) NOT NULL,
+    user_id BIGINT NOT NULL,
+    accrual BIGINT,
+    status VARCHAR(25) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS order_idx ON gophermart.orders (number);
+
+-- balance:
+CREATE TABLE IF NOT EXISTS gophermart.balance (
+    user_id BIGINT NOT NULL,
+    current BIGINT NOT NULL,
+    withdrawn BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS balance_idx ON gophermart.balance (user_id);
+
+-- withdrawals:
+CREATE TABLE IF NOT EXISTS gophermart.withdrawals (
+    "order" VARCHAR(50) NOT NULL,
+    user_id BIGINT NOT NULL,
+    "sum" BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_idx ON gophermart.withdrawals ("order");
+
+-- триггер для поля updated_at
+CREATE OR REPLACE FUNCTION gophermart.updated_at()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = now();
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DO
+$$BEGIN
+    CREATE TRIGGER orders_updated_at
+        BEFORE UPDATE
+        ON
+            gophermart.orders
+        FOR EACH ROW
+    EXECUTE PROCEDURE gophermart.updated_at();
+EXCEPTION
+   WHEN duplicate_object THEN
+      NULL;
+END;$$;
+
+DO
+$$BEGIN
+    CREATE TRIGGER balance_updated_at
+        BEFORE UPDATE
+        ON
+            gophermart.balance
+        FOR EACH ROW
+    EXECUTE PROCEDURE gophermart.updated_at();
+EXCEPTION
+   WHEN duplicate_object THEN
+      NULL;
+END;$$;
@@ -0,0 +1,10 @@
+-- ключи идемпотентности списаний: повтор запроса с тем же ключом
+-- возвращает исходный результат, а не списывает баллы повторно
+CREATE TABLE IF NOT EXISTS gophermart.idempotency_keys (
+    user_id BIGINT NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    "order" VARCHAR(50) NOT NULL,
+    "sum" BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
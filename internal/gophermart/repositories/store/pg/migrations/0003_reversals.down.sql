@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.ledger;
+ALTER TABLE gophermart.withdrawals DROP COLUMN IF EXISTS reversed;
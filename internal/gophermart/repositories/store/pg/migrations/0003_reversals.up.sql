@@ -0,0 +1,15 @@
+-- сколько баллов уже возвращено по списанию
+ALTER TABLE gophermart.withdrawals ADD COLUMN IF NOT EXISTS reversed BIGINT NOT NULL DEFAULT 0;
+
+-- история операций с балансом помимо начислений и списаний
+CREATE TABLE IF NOT EXISTS gophermart.ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    operation VARCHAR(25) NOT NULL,
+    amount BIGINT NOT NULL,
+    "order" VARCHAR(50),
+    reason VARCHAR(255),
+    ref VARCHAR(100),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS ledger_user_idx ON gophermart.ledger (user_id, created_at);
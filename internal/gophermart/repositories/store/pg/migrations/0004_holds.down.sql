@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.holds;
+ALTER TABLE gophermart.balance DROP COLUMN IF EXISTS reserved;
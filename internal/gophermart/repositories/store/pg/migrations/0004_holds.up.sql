@@ -0,0 +1,28 @@
+-- баллы, зарезервированные под неподтвержденные покупки
+ALTER TABLE gophermart.balance ADD COLUMN IF NOT EXISTS reserved BIGINT NOT NULL DEFAULT 0;
+
+-- резервы: HELD -> CAPTURED (списание) | VOIDED (отмена) | EXPIRED (истек срок)
+CREATE TABLE IF NOT EXISTS gophermart.holds (
+    "order" VARCHAR(50) NOT NULL,
+    user_id BIGINT NOT NULL,
+    "sum" BIGINT NOT NULL,
+    status VARCHAR(25) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS hold_idx ON gophermart.holds ("order");
+CREATE INDEX IF NOT EXISTS hold_expires_idx ON gophermart.holds (expires_at) WHERE status = 'HELD';
+
+DO
+$$BEGIN
+    CREATE TRIGGER holds_updated_at
+        BEFORE UPDATE
+        ON
+            gophermart.holds
+        FOR EACH ROW
+    EXECUTE PROCEDURE gophermart.updated_at();
+EXCEPTION
+   WHEN duplicate_object THEN
+      NULL;
+END;$$;
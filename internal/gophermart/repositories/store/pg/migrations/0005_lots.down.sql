@@ -0,0 +1,3 @@
+ALTER TABLE gophermart.holds DROP COLUMN IF EXISTS earned_at;
+ALTER TABLE gophermart.withdrawals DROP COLUMN IF EXISTS earned_at;
+DROP TABLE IF EXISTS gophermart.lots;
@@ -0,0 +1,25 @@
+-- партии начисленных баллов: сгорают через заданный срок после earned_at,
+-- списания расходуют их в порядке начисления (FIFO)
+CREATE TABLE IF NOT EXISTS gophermart.lots (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    source VARCHAR(25) NOT NULL,
+    "order" VARCHAR(50),
+    amount BIGINT NOT NULL,
+    remaining BIGINT NOT NULL,
+    earned_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expired_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS lots_user_idx ON gophermart.lots (user_id, earned_at, id) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS lots_earned_idx ON gophermart.lots (earned_at) WHERE remaining > 0;
+
+-- дата самой старой партии, из которой списаны баллы: при возврате
+-- баллы получают ее же, чтобы отмена покупки не продлевала их срок
+ALTER TABLE gophermart.withdrawals ADD COLUMN IF NOT EXISTS earned_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE gophermart.holds ADD COLUMN IF NOT EXISTS earned_at TIMESTAMP WITH TIME ZONE;
+
+-- текущие остатки становятся партиями, начисленными в момент миграции
+INSERT INTO gophermart.lots (user_id, source, amount, remaining)
+    SELECT user_id, 'MIGRATION', current, current FROM gophermart.balance
+        WHERE current > 0;
+UPDATE gophermart.holds SET earned_at = NOW() WHERE status = 'HELD';
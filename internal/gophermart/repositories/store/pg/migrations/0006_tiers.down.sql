@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS gophermart.user_tiers;
+DROP INDEX IF EXISTS gophermart.withdrawal_user_idx;
+ALTER TABLE gophermart.orders DROP COLUMN IF EXISTS credited_at;
+ALTER TABLE gophermart.orders DROP COLUMN IF EXISTS tier;
+ALTER TABLE gophermart.orders DROP COLUMN IF EXISTS bonus;
@@ -0,0 +1,29 @@
+-- надбавка уровня лояльности сверх начисления accrual и время зачисления на баланс
+ALTER TABLE gophermart.orders ADD COLUMN IF NOT EXISTS bonus BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE gophermart.orders ADD COLUMN IF NOT EXISTS tier VARCHAR(50);
+ALTER TABLE gophermart.orders ADD COLUMN IF NOT EXISTS credited_at TIMESTAMP WITH TIME ZONE;
+UPDATE gophermart.orders SET credited_at = updated_at WHERE status = 'PROCESSED' AND accrual > 0;
+CREATE INDEX IF NOT EXISTS order_credited_idx ON gophermart.orders (user_id, credited_at) WHERE credited_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS withdrawal_user_idx ON gophermart.withdrawals (user_id, created_at);
+
+-- текущий уровень пользователя и сумма, по которой он присвоен
+CREATE TABLE IF NOT EXISTS gophermart.user_tiers (
+    user_id BIGINT PRIMARY KEY,
+    tier VARCHAR(50) NOT NULL,
+    qualifying BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+DO
+$$BEGIN
+    CREATE TRIGGER user_tiers_updated_at
+        BEFORE UPDATE
+        ON
+            gophermart.user_tiers
+        FOR EACH ROW
+    EXECUTE PROCEDURE gophermart.updated_at();
+EXCEPTION
+   WHEN duplicate_object THEN
+      NULL;
+END;$$;
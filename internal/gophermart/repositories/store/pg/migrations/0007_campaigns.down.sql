@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.campaign_awards;
+DROP TABLE IF EXISTS gophermart.campaigns;
@@ -0,0 +1,42 @@
+-- промо-акции: бонусные баллы сверх начисления по правилам и в пределах бюджета
+CREATE TABLE IF NOT EXISTS gophermart.campaigns (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    first_order BOOLEAN NOT NULL DEFAULT FALSE,
+    min_accrual BIGINT NOT NULL DEFAULT 0,
+    segment TEXT NOT NULL DEFAULT '',
+    multiplier DOUBLE PRECISION NOT NULL DEFAULT 0,
+    bonus BIGINT NOT NULL DEFAULT 0,
+    budget BIGINT NOT NULL DEFAULT 0,
+    spent BIGINT NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    deleted_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS campaign_period_idx ON gophermart.campaigns (starts_at, ends_at) WHERE active AND deleted_at IS NULL;
+
+-- начисленные по акциям бонусы: по заказу акция срабатывает не больше раза
+CREATE TABLE IF NOT EXISTS gophermart.campaign_awards (
+    campaign_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    "order" VARCHAR(50) NOT NULL,
+    amount BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (campaign_id, "order")
+);
+
+DO
+$$BEGIN
+    CREATE TRIGGER campaigns_updated_at
+        BEFORE UPDATE
+        ON
+            gophermart.campaigns
+        FOR EACH ROW
+    EXECUTE PROCEDURE gophermart.updated_at();
+EXCEPTION
+   WHEN duplicate_object THEN
+      NULL;
+END;$$;
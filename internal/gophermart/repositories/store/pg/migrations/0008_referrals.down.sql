@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.referrals;
+ALTER TABLE gophermart.users DROP COLUMN IF EXISTS invite_code;
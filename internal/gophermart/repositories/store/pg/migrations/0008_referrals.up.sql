@@ -0,0 +1,19 @@
+-- личный код приглашения пользователя
+ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS invite_code VARCHAR(16);
+UPDATE gophermart.users SET invite_code = upper(substr(md5(random()::text || id::text), 1, 8))
+    WHERE invite_code IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS user_invite_idx ON gophermart.users (invite_code);
+
+-- приглашения: PENDING -> REWARDED (первый заказ обработан) | REJECTED (сработал лимит)
+CREATE TABLE IF NOT EXISTS gophermart.referrals (
+    referee_id BIGINT PRIMARY KEY,
+    referrer_id BIGINT NOT NULL,
+    status VARCHAR(25) NOT NULL,
+    reason VARCHAR(255),
+    referrer_reward BIGINT NOT NULL DEFAULT 0,
+    referee_reward BIGINT NOT NULL DEFAULT 0,
+    "order" VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    rewarded_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX IF NOT EXISTS referral_referrer_idx ON gophermart.referrals (referrer_id, created_at);
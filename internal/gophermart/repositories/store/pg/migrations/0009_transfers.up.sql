@@ -0,0 +1,11 @@
+-- переводы баллов между пользователями
+CREATE TABLE IF NOT EXISTS gophermart.transfers (
+    id BIGSERIAL PRIMARY KEY,
+    from_user_id BIGINT NOT NULL,
+    to_user_id BIGINT NOT NULL,
+    "sum" BIGINT NOT NULL,
+    comment VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS transfer_from_idx ON gophermart.transfers (from_user_id, created_at);
+CREATE INDEX IF NOT EXISTS transfer_to_idx ON gophermart.transfers (to_user_id, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.vouchers;
+DROP TABLE IF EXISTS gophermart.voucher_batches;
@@ -0,0 +1,20 @@
+-- выпуски подарочных сертификатов: номинал и срок действия общие на выпуск
+CREATE TABLE IF NOT EXISTS gophermart.voucher_batches (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    value BIGINT NOT NULL,
+    count INTEGER NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- одноразовые коды сертификатов, хранится только хэш кода
+CREATE TABLE IF NOT EXISTS gophermart.vouchers (
+    id BIGSERIAL PRIMARY KEY,
+    batch_id BIGINT NOT NULL REFERENCES gophermart.voucher_batches (id),
+    code_hash CHAR(64) NOT NULL,
+    redeemed_by BIGINT,
+    redeemed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS voucher_code_hash_idx ON gophermart.vouchers (code_hash);
+CREATE INDEX IF NOT EXISTS voucher_batch_idx ON gophermart.vouchers (batch_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS gophermart.order_partner_idx;
+ALTER TABLE gophermart.orders DROP COLUMN IF EXISTS partner_id;
+DROP TABLE IF EXISTS gophermart.partners;
@@ -0,0 +1,30 @@
+-- партнеры: магазины, которые регистрируют заказы за покупателей по API-ключу.
+-- Хранится только хэш ключа, префикс - чтобы ключ можно было узнать в списке
+CREATE TABLE IF NOT EXISTS gophermart.partners (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    key_prefix VARCHAR(16) NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS partner_key_hash_idx ON gophermart.partners (key_hash);
+
+-- партнер, зарегистрировавший заказ
+ALTER TABLE gophermart.orders ADD COLUMN IF NOT EXISTS partner_id BIGINT;
+CREATE INDEX IF NOT EXISTS order_partner_idx ON gophermart.orders (partner_id, created_at) WHERE partner_id IS NOT NULL;
+
+DO
+$$BEGIN
+    CREATE TRIGGER partners_updated_at
+        BEFORE UPDATE
+        ON
+            gophermart.partners
+        FOR EACH ROW
+    EXECUTE PROCEDURE gophermart.updated_at();
+EXCEPTION
+   WHEN duplicate_object THEN
+      NULL;
+END;$$;
@@ -0,0 +1,19 @@
+ALTER TABLE gophermart.partners DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE gophermart.voucher_batches DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE gophermart.campaigns DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS gophermart.hold_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS hold_idx ON gophermart.holds ("order");
+ALTER TABLE gophermart.holds DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS gophermart.withdrawal_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_idx ON gophermart.withdrawals ("order");
+ALTER TABLE gophermart.withdrawals DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS gophermart.order_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS order_idx ON gophermart.orders (number);
+ALTER TABLE gophermart.orders DROP COLUMN IF EXISTS tenant_id;
+
+DROP INDEX IF EXISTS gophermart.user_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS user_idx ON gophermart.users (login);
+ALTER TABLE gophermart.users DROP COLUMN IF EXISTS tenant_id;
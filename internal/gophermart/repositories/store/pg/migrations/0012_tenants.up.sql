@@ -0,0 +1,22 @@
+-- программы лояльности разных брендов в одной инсталляции: существующие данные
+-- относятся к программе по умолчанию, логины и номера заказов уникальны внутри программы
+ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS gophermart.user_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS user_tenant_idx ON gophermart.users (tenant_id, login);
+
+ALTER TABLE gophermart.orders ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS gophermart.order_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS order_tenant_idx ON gophermart.orders (tenant_id, number);
+
+ALTER TABLE gophermart.withdrawals ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS gophermart.withdrawal_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_tenant_idx ON gophermart.withdrawals (tenant_id, "order");
+
+ALTER TABLE gophermart.holds ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS gophermart.hold_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS hold_tenant_idx ON gophermart.holds (tenant_id, "order");
+
+-- акции, сертификаты и партнеры принадлежат одной программе
+ALTER TABLE gophermart.campaigns ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+ALTER TABLE gophermart.voucher_batches ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
+ALTER TABLE gophermart.partners ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(50) NOT NULL DEFAULT 'default';
@@ -0,0 +1,7 @@
+-- общие для всех реплик корзины ограничения частоты запросов: корзина хранится
+-- моментом tat, к которому она наполнилась бы при обслуживании всех принятых запросов
+CREATE UNLOGGED TABLE IF NOT EXISTS gophermart.rate_limits (
+    key VARCHAR(255) PRIMARY KEY,
+    tat TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_limit_tat_idx ON gophermart.rate_limits (tat);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.audit_log;
+DROP TABLE IF EXISTS gophermart.login_attempts;
@@ -0,0 +1,25 @@
+-- неудачные попытки входа по логину и по IP; считаются и для несуществующих логинов
+CREATE TABLE IF NOT EXISTS gophermart.login_attempts (
+    tenant_id VARCHAR(50) NOT NULL,
+    scope VARCHAR(10) NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (tenant_id, scope, value)
+);
+CREATE INDEX IF NOT EXISTS login_attempt_locked_idx ON gophermart.login_attempts (tenant_id, locked_until) WHERE locked_until IS NOT NULL;
+
+-- журнал аудита событий безопасности
+CREATE TABLE IF NOT EXISTS gophermart.audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    tenant_id VARCHAR(50) NOT NULL DEFAULT 'default',
+    event VARCHAR(50) NOT NULL,
+    actor VARCHAR(50) NOT NULL,
+    user_id BIGINT,
+    login VARCHAR(255),
+    ip VARCHAR(64),
+    details VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS audit_log_tenant_idx ON gophermart.audit_log (tenant_id, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS gophermart.recovery_codes;
+DROP TABLE IF EXISTS gophermart.totp;
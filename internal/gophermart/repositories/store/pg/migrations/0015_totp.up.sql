@@ -0,0 +1,17 @@
+-- второй фактор TOTP: секрет действует после подтверждения первым кодом,
+-- last_step - последний принятый шаг времени, повтор кода не принимается
+CREATE TABLE IF NOT EXISTS gophermart.totp (
+    user_id BIGINT PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- одноразовые коды восстановления на случай потери устройства; хранится только хэш
+CREATE TABLE IF NOT EXISTS gophermart.recovery_codes (
+    user_id BIGINT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS gophermart.password_resets;
+ALTER TABLE gophermart.users DROP COLUMN IF EXISTS token_version;
+ALTER TABLE gophermart.users DROP COLUMN IF EXISTS email;
@@ -0,0 +1,14 @@
+-- канал восстановления доступа и версия токенов: смена пароля увеличивает версию,
+-- и выданные раньше токены перестают действовать
+ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS email VARCHAR(255);
+ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS token_version BIGINT NOT NULL DEFAULT 0;
+
+-- одноразовые токены сброса пароля; хранится только хэш
+CREATE TABLE IF NOT EXISTS gophermart.password_resets (
+    token_hash CHAR(64) PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS password_reset_user_idx ON gophermart.password_resets (user_id);
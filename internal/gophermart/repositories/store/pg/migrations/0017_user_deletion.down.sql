@@ -0,0 +1 @@
+ALTER TABLE gophermart.users DROP COLUMN IF EXISTS deleted_at;
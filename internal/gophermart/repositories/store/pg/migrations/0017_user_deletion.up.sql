@@ -0,0 +1,3 @@
+-- удаленные пользователи: логин обезличен, вход невозможен, финансовые записи
+-- (заказы, списания, история баланса) хранятся для бухгалтерского учета
+ALTER TABLE gophermart.users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1 @@
+ALTER TABLE gophermart.withdrawals DROP CONSTRAINT IF EXISTS withdrawal_sum_check;
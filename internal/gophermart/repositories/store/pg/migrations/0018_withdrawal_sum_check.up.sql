@@ -0,0 +1,3 @@
+-- списание всегда уменьшает баланс: запись с неположительной суммой начислила бы баллы.
+-- Такие записи, если они есть, нужно исправить до миграции
+ALTER TABLE gophermart.withdrawals ADD CONSTRAINT withdrawal_sum_check CHECK ("sum" > 0);
@@ -0,0 +1,203 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strings"
+	"time"
+)
+
+const partnerColumns = `id, name, scopes, key_prefix, active, created_at, tenant_id`
+
+func (s *Store) CreatePartner(ctx context.Context, partner models.Partner, keyHash string) (*models.Partner, error) {
+	row := s.Pool.QueryRow(ctx, `
+		INSERT INTO gophermart.partners (name, scopes, key_prefix, key_hash, active, tenant_id) VALUES($1, $2, $3, $4, $5, $6)
+			RETURNING `+partnerColumns,
+		partner.Name, strings.Join(partner.Scopes, ","), partner.KeyPrefix, keyHash, partner.Active, partner.TenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) GetPartners(ctx context.Context, tenantID string) ([]models.Partner, error) {
+	rows, err := s.Pool.Query(ctx, `
+		SELECT `+partnerColumns+` FROM gophermart.partners
+			WHERE tenant_id = $1
+				ORDER BY id
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partners []models.Partner
+	for rows.Next() {
+		partner, err := scanPartner(rows)
+		if err != nil {
+			return nil, err
+		}
+		partners = append(partners, *partner)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return partners, nil
+}
+
+func (s *Store) GetPartner(ctx context.Context, tenantID string, id int64) (*models.Partner, error) {
+	row := s.Pool.QueryRow(ctx, `
+		SELECT `+partnerColumns+` FROM gophermart.partners
+			WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) GetPartnerByKeyHash(ctx context.Context, keyHash string) (*models.Partner, error) {
+	row := s.Pool.QueryRow(ctx, `
+		SELECT `+partnerColumns+` FROM gophermart.partners
+			WHERE key_hash = $1 AND active
+	`, keyHash)
+
+	return scanPartner(row)
+}
+
+func (s *Store) UpdatePartner(ctx context.Context, partner models.Partner) (*models.Partner, error) {
+	row := s.Pool.QueryRow(ctx, `
+		UPDATE gophermart.partners SET name = $2, scopes = $3, active = $4
+			WHERE id = $1 AND tenant_id = $5
+				RETURNING `+partnerColumns,
+		partner.ID, partner.Name, strings.Join(partner.Scopes, ","), partner.Active, partner.TenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) SetPartnerKey(ctx context.Context, tenantID string, id int64, keyPrefix, keyHash string) (*models.Partner, error) {
+	// прежний ключ перестает действовать сразу
+	row := s.Pool.QueryRow(ctx, `
+		UPDATE gophermart.partners SET key_prefix = $2, key_hash = $3
+			WHERE id = $1 AND tenant_id = $4
+				RETURNING `+partnerColumns,
+		id, keyPrefix, keyHash, tenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) GetPartnerOrder(ctx context.Context, partnerID int64, number string) (*models.Order, error) {
+	var accrual pgtype.Int8
+	var bonus models.Money
+	var status string
+	var createdAt time.Time
+	err := s.Pool.QueryRow(ctx, `
+		SELECT accrual, bonus, status, created_at FROM gophermart.orders
+			WHERE number = $2 AND partner_id = $1
+	`, partnerID, number).Scan(&accrual, &bonus, &status, &createdAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	money := models.Money(accrual.Int64)
+	return &models.Order{
+		Number:    number,
+		Accrual:   models.Money(money.Get()),
+		Bonus:     models.Money(bonus.Get()),
+		PartnerID: partnerID,
+		Status:    models.OrderState(status),
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *Store) GetPartnerWithdrawal(ctx context.Context, partnerID int64, order string) (*models.Withdrawal, error) {
+	// списание относится к партнеру, если оплачен зарегистрированный им заказ
+	var sum, reversed models.Money
+	var createdAt time.Time
+	err := s.Pool.QueryRow(ctx, `
+		SELECT w.sum, w.reversed, w.created_at
+			FROM gophermart.withdrawals w
+				JOIN gophermart.orders o ON o.number = w."order" AND o.tenant_id = w.tenant_id
+					WHERE w."order" = $2 AND o.partner_id = $1
+	`, partnerID, order).Scan(&sum, &reversed, &createdAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	return &models.Withdrawal{
+		Order:     order,
+		Sum:       models.Money(sum.Get()),
+		Reversed:  models.Money(reversed.Get()),
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *Store) GetPartnerReport(ctx context.Context, partnerID int64, from, to time.Time) (*models.PartnerReport, error) {
+	report := models.PartnerReport{
+		PartnerID: partnerID,
+		From:      from.Format(time.RFC3339),
+		To:        to.Format(time.RFC3339),
+	}
+	err := s.read(ctx, func(q querier) error {
+		var accrued, redeemed, reversed models.Money
+		err := q.QueryRow(ctx, `
+			SELECT
+				COUNT(*) FILTER (WHERE created_at >= $2 AND created_at < $3),
+				COUNT(*) FILTER (WHERE created_at >= $2 AND created_at < $3 AND status = $4),
+				COALESCE(SUM(COALESCE(accrual, 0) + bonus) FILTER (WHERE credited_at >= $2 AND credited_at < $3), 0)
+					FROM gophermart.orders
+						WHERE partner_id = $1
+		`, partnerID, from, to, models.OrderStateProcessed).Scan(&report.Orders, &report.Processed, &accrued)
+		if err != nil {
+			return err
+		}
+		err = q.QueryRow(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(w.sum), 0), COALESCE(SUM(w.reversed), 0)
+				FROM gophermart.withdrawals w
+					JOIN gophermart.orders o ON o.number = w."order" AND o.tenant_id = w.tenant_id
+						WHERE o.partner_id = $1 AND w.created_at >= $2 AND w.created_at < $3
+		`, partnerID, from, to).Scan(&report.Redemptions, &redeemed, &reversed)
+		if err != nil {
+			return err
+		}
+		report.Accrued = models.Money(accrued.Get())
+		report.Redeemed = models.Money(redeemed.Get())
+		report.Reversed = models.Money(reversed.Get())
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+func scanPartner(row pgx.Row) (*models.Partner, error) {
+	var partner models.Partner
+	var scopes string
+	var createdAt time.Time
+	err := row.Scan(&partner.ID, &partner.Name, &scopes, &partner.KeyPrefix, &partner.Active, &createdAt, &partner.TenantID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	partner.Scopes = []string{}
+	if scopes != "" {
+		partner.Scopes = strings.Split(scopes, ",")
+	}
+	partner.CreatedAt = createdAt.Format(time.RFC3339)
+
+	return &partner, nil
+}
@@ -0,0 +1,121 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+const userColumns = `id, tenant_id, login, email, token_version, created_at`
+
+func (s *Store) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+	// версия токенов читается только с основного сервера: на реплике отзыв
+	// токенов мог еще не появиться
+	row := s.Pool.QueryRow(ctx, `
+		SELECT `+userColumns+` FROM gophermart.users WHERE id = $1
+	`, userID)
+
+	return scanUser(row)
+}
+
+func (s *Store) SetEmail(ctx context.Context, userID int64, email string) error {
+	tag, err := s.Pool.Exec(ctx, `
+		UPDATE gophermart.users SET email = NULLIF($2, '') WHERE id = $1
+	`, userID, email)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) ChangePassword(ctx context.Context, userID int64, oldPassword, newPassword string) (*models.User, error) {
+	// новая версия отзывает все выданные раньше токены
+	row := s.Pool.QueryRow(ctx, `
+		UPDATE gophermart.users SET password = $3, token_version = token_version + 1
+			WHERE id = $1 AND password = $2
+				RETURNING `+userColumns,
+		userID, oldPassword, newPassword)
+
+	return scanUser(row)
+}
+
+func (s *Store) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// действует только последний запрошенный токен
+	_, err = tx.Exec(ctx, `DELETE FROM gophermart.password_resets WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.password_resets (token_hash, user_id, expires_at) VALUES($1, $2, $3)
+	`, tokenHash, userID, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *Store) ResetPassword(ctx context.Context, tokenHash, password string) (*models.User, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var userID int64
+	err = tx.QueryRow(ctx, `
+		UPDATE gophermart.password_resets SET used_at = NOW()
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+				RETURNING user_id
+	`, tokenHash).Scan(&userID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	user, err := scanUser(tx.QueryRow(ctx, `
+		UPDATE gophermart.users SET password = $2, token_version = token_version + 1
+			WHERE id = $1
+				RETURNING `+userColumns,
+		userID, password))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit(ctx)
+}
+
+func scanUser(row pgx.Row) (*models.User, error) {
+	var user models.User
+	var email pgtype.Text
+	var createdAt time.Time
+	err := row.Scan(&user.ID, &user.TenantID, &user.Login, &email, &user.TokenVersion, &createdAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	user.Email = email.String
+	user.CreatedAt = createdAt.Format(time.RFC3339)
+
+	return &user, nil
+}
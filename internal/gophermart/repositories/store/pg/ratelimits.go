@@ -0,0 +1,48 @@
+package pg
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+func (s *Store) TakeRateToken(ctx context.Context, key string, limit models.RateLimit) (time.Duration, error) {
+	// корзина хранится как tat (theoretical arrival time, алгоритм GCRA): каждый принятый
+	// запрос сдвигает его на интервал токена, запрос принимается, пока tat опережает
+	// текущее время не больше чем на емкость корзины. Отказ строку не меняет
+	interval := limit.Interval().Seconds()
+	capacity := interval * float64(limit.Burst)
+
+	var taken bool
+	var retryAfter float64
+	err := s.Pool.QueryRow(ctx, `
+		WITH taken AS (
+			INSERT INTO gophermart.rate_limits (key, tat) VALUES($1, NOW() + $2::float8 * INTERVAL '1 second')
+				ON CONFLICT (key) DO
+					UPDATE SET tat = GREATEST(rate_limits.tat, NOW()) + $2::float8 * INTERVAL '1 second'
+						WHERE GREATEST(rate_limits.tat, NOW()) + $2::float8 * INTERVAL '1 second' <= NOW() + $3::float8 * INTERVAL '1 second'
+							RETURNING tat
+		)
+		SELECT EXISTS (SELECT 1 FROM taken),
+			COALESCE((
+				SELECT EXTRACT(EPOCH FROM tat - NOW())::float8 + $2::float8 - $3::float8 FROM gophermart.rate_limits
+					WHERE key = $1
+			), 0)
+	`, key, interval, capacity).Scan(&taken, &retryAfter)
+	if err != nil || taken {
+		return 0, err
+	}
+
+	return time.Duration(max(retryAfter, 0) * float64(time.Second)), nil
+}
+
+func (s *Store) DeleteIdleRateBuckets(ctx context.Context) (int64, error) {
+	tag, err := s.Pool.Exec(ctx, `
+		DELETE FROM gophermart.rate_limits WHERE tat < NOW()
+	`)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,205 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) GetUserIDByInviteCode(ctx context.Context, tenantID, code string) (int64, error) {
+	var id int64
+	err := s.Pool.QueryRow(ctx, `
+		SELECT id FROM gophermart.users WHERE invite_code = $1 AND tenant_id = $2
+	`, code, tenantID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, api.ErrNotFound
+	}
+
+	return id, err
+}
+
+func (s *Store) GetInviteCode(ctx context.Context, userID int64) (string, error) {
+	var code pgtype.Text
+	err := s.Pool.QueryRow(ctx, `
+		SELECT invite_code FROM gophermart.users WHERE id = $1
+	`, userID).Scan(&code)
+
+	return code.String, err
+}
+
+func (s *Store) CountReferrals(ctx context.Context, referrerID int64, since time.Time) (int64, error) {
+	var count int64
+	err := s.Pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM gophermart.referrals
+			WHERE referrer_id = $1 AND created_at >= $2
+	`, referrerID, since).Scan(&count)
+
+	return count, err
+}
+
+func (s *Store) CreateReferral(ctx context.Context, referral models.Referral) error {
+	_, err := s.Pool.Exec(ctx, `
+		INSERT INTO gophermart.referrals (referee_id, referrer_id, status, reason) VALUES($1, $2, $3, NULLIF($4, ''))
+			ON CONFLICT (referee_id) DO NOTHING
+	`, referral.RefereeID, referral.ReferrerID, referral.Status, referral.Reason)
+
+	return err
+}
+
+func (s *Store) GetReferrals(ctx context.Context, referrerID int64) ([]models.Referral, error) {
+	var referrals []models.Referral
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT r.referee_id, u.login, r.status, r.reason, r.referrer_reward, r.created_at, r.rewarded_at
+				FROM gophermart.referrals r
+					JOIN gophermart.users u ON u.id = r.referee_id
+						WHERE r.referrer_id = $1
+							ORDER BY r.created_at DESC, r.referee_id DESC
+		`, referrerID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		referrals = nil
+		for rows.Next() {
+			var refereeID int64
+			var login, status string
+			var reason pgtype.Text
+			var reward models.Money
+			var createdAt time.Time
+			var rewardedAt pgtype.Timestamptz
+			err = rows.Scan(&refereeID, &login, &status, &reason, &reward, &createdAt, &rewardedAt)
+			if err != nil {
+				return err
+			}
+			referral := models.Referral{
+				ReferrerID: referrerID,
+				RefereeID:  refereeID,
+				Login:      login,
+				Status:     models.ReferralStatus(status),
+				Reason:     reason.String,
+				Reward:     models.Money(reward.Get()),
+				CreatedAt:  createdAt.Format(time.RFC3339),
+			}
+			if rewardedAt.Valid {
+				referral.RewardedAt = rewardedAt.Time.Format(time.RFC3339)
+			}
+			referrals = append(referrals, referral)
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return referrals, nil
+}
+
+func (s *Store) RewardReferral(ctx context.Context, userID int64, order string, policy models.ReferralPolicy) (*models.Referral, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	referral := models.Referral{RefereeID: userID, Order: order}
+	err = tx.QueryRow(ctx, `
+		SELECT referrer_id FROM gophermart.referrals
+			WHERE referee_id = $1 AND status = $2
+				FOR UPDATE
+	`, userID, models.ReferralStatusPending).Scan(&referral.ReferrerID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if policy.MaxRewards > 0 {
+		// блокировка пригласившего не дает параллельным наградам обойти лимит
+		_, err = tx.Exec(ctx, `
+			SELECT 1 FROM gophermart.users WHERE id = $1 FOR UPDATE
+		`, referral.ReferrerID)
+		if err != nil {
+			return nil, err
+		}
+		var rewarded int
+		err = tx.QueryRow(ctx, `
+			SELECT COUNT(*) FROM gophermart.referrals
+				WHERE referrer_id = $1 AND status = $2
+		`, referral.ReferrerID, models.ReferralStatusRewarded).Scan(&rewarded)
+		if err != nil {
+			return nil, err
+		}
+		if rewarded >= policy.MaxRewards {
+			referral.Status = models.ReferralStatusRejected
+			referral.Reason = "referrer reward limit reached"
+			_, err = tx.Exec(ctx, `
+				UPDATE gophermart.referrals SET status = $2, reason = $3, "order" = $4
+					WHERE referee_id = $1
+			`, userID, referral.Status, referral.Reason, order)
+			if err != nil {
+				return nil, err
+			}
+			return &referral, tx.Commit(ctx)
+		}
+	}
+
+	// балансы блокируются по возрастанию id, чтобы параллельные начисления
+	// двум пользователям не ждали друг друга
+	rewards := []struct {
+		userID, otherID int64
+		amount          models.Money
+		reason          string
+	}{
+		{referral.ReferrerID, userID, policy.ReferrerReward, "referrer"},
+		{userID, referral.ReferrerID, policy.RefereeReward, "referee"},
+	}
+	if rewards[1].userID < rewards[0].userID {
+		rewards[0], rewards[1] = rewards[1], rewards[0]
+	}
+	for _, reward := range rewards {
+		if reward.amount <= 0 {
+			continue
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO gophermart.balance (user_id, current, withdrawn) VALUES($1, $2, 0)
+				ON CONFLICT (user_id) DO
+					UPDATE SET current = gophermart.balance.current + $2
+		`, reward.userID, reward.amount)
+		if err != nil {
+			return nil, err
+		}
+		if err := creditLot(ctx, tx, reward.userID, models.LotSourceReferral, order, reward.amount, nil); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO gophermart.ledger (user_id, operation, amount, "order", reason, ref) VALUES($1, $2, $3, $4, $5, $6)
+		`, reward.userID, models.LedgerOperationReferralBonus, reward.amount, order, reward.reason, strconv.FormatInt(reward.otherID, 10))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	referral.Status = models.ReferralStatusRewarded
+	referral.Reward = models.Money(policy.ReferrerReward.Get())
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.referrals
+			SET status = $2, referrer_reward = $3, referee_reward = $4, "order" = $5, rewarded_at = NOW()
+				WHERE referee_id = $1
+	`, userID, referral.Status, policy.ReferrerReward, policy.RefereeReward, order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &referral, tx.Commit(ctx)
+}
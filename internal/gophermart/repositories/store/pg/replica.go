@@ -0,0 +1,131 @@
+package pg
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"sync/atomic"
+	"time"
+)
+
+// replicaLagCheckInterval - как часто проверяется отставание реплик
+const replicaLagCheckInterval = 5 * time.Second
+
+// querier - общий интерфейс пула и транзакции, достаточный для чтения
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// replica - пул соединений к реплике и признак того, что она пригодна для чтения
+type replica struct {
+	pool    *pgxpool.Pool
+	healthy atomic.Bool
+}
+
+// openReplicas подключается к репликам. Недоступная при старте реплика
+// не мешает запуску: чтение просто уйдет на основной сервер
+func (s *Store) openReplicas(ctx context.Context, app config.AppConfig) {
+	for _, dsn := range app.StoreReplicaURIs {
+		cfg, err := PoolConfig(dsn, app)
+		if err != nil {
+			logger.Log.Errorln("failed PoolConfig() for replica:", err)
+			continue
+		}
+		pool, err := OpenDB(ctx, cfg)
+		if err != nil {
+			logger.Log.Errorln("Replica is not available, reads will use primary:", err)
+			continue
+		}
+		r := &replica{pool: pool}
+		s.replicas = append(s.replicas, r)
+	}
+	if len(s.replicas) == 0 {
+		return
+	}
+
+	logger.Log.Infoln("Connected to replicas:", len(s.replicas))
+	s.checkReplicas(ctx, app.StoreReplicaMaxLag)
+	go s.watchReplicas(ctx, app.StoreReplicaMaxLag)
+}
+
+// watchReplicas периодически проверяет отставание реплик до остановки приложения
+func (s *Store) watchReplicas(ctx context.Context, maxLag time.Duration) {
+	ticker := time.NewTicker(replicaLagCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkReplicas(ctx, maxLag)
+		}
+	}
+}
+
+func (s *Store) checkReplicas(ctx context.Context, maxLag time.Duration) {
+	for _, r := range s.replicas {
+		// если реплика догнала основной сервер, отставание считаем нулевым,
+		// иначе время последней проигранной транзакции росло бы на простаивающей базе.
+		// Совпадение позиций ничего не значит, если реплика не получает WAL: без связи
+		// с основным сервером она непригодна при любом отставании. Без роли
+		// pg_read_all_stats статус приемника не виден, тогда достаточно того, что он запущен
+		var (
+			streaming bool
+			lag       float64
+		)
+		err := r.pool.QueryRow(ctx, `
+			SELECT
+				NOT pg_is_in_recovery() OR EXISTS (
+					SELECT 1 FROM pg_stat_wal_receiver WHERE COALESCE(status, 'streaming') = 'streaming'
+				),
+				CASE
+					WHEN NOT pg_is_in_recovery() THEN 0
+					WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+					ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+				END
+		`).Scan(&streaming, &lag)
+		healthy := err == nil && streaming && (maxLag <= 0 || time.Duration(lag*float64(time.Second)) <= maxLag)
+		if r.healthy.Swap(healthy) != healthy {
+			logger.Log.Infoln("Replica state changed:", "healthy", healthy, "streaming", streaming, "lag", lag, "err", err)
+		}
+	}
+}
+
+// reader возвращает пригодную реплику по кругу или nil, если таких нет
+func (s *Store) reader() *replica {
+	n := len(s.replicas)
+	for i := 0; i < n; i++ {
+		r := s.replicas[int(s.next.Add(1))%n]
+		if r.healthy.Load() {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// read выполняет запрос только на чтение на реплике,
+// а при ее ошибке или отсутствии - на основном сервере
+func (s *Store) read(ctx context.Context, fn func(q querier) error) error {
+	if r := s.reader(); r != nil {
+		err := fn(r.pool)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		logger.FromContext(ctx).Warnln("Replica read failed, falling back to primary:", err)
+	}
+
+	return fn(s.Pool)
+}
+
+func (s *Store) closeReplicas() {
+	for _, r := range s.replicas {
+		r.pool.Close()
+	}
+}
@@ -3,97 +3,172 @@ package pg
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/migrate"
+	"sync/atomic"
+	"time"
 )
 
+// uniqueViolation - код ошибки PostgreSQL при нарушении уникального индекса
+const uniqueViolation = "23505"
+
 type Store struct {
-	Conn *sql.DB
+	Pool *pgxpool.Pool
+	// db - database/sql поверх того же пула для мигратора
+	db *sql.DB
+
+	// реплики только для чтения и счетчик для их перебора по кругу
+	replicas []*replica
+	next     atomic.Uint32
 }
 
 func (s *Store) Initialize(ctx context.Context, app config.AppConfig) error {
-	var err error
-	if s.Conn, err = ConnectToDB(app.StoreDatabaseURI); err != nil {
+	if err := s.Open(ctx, app); err != nil {
 		return err
 	}
 
-	if err = Bootstrap(ctx, s.Conn); err != nil {
+	// миграции применяются при старте, ошибка любой из них прерывает запуск
+	migrator, err := s.Migrator()
+	if err != nil {
 		return err
 	}
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infoln("Applied migrations:", applied)
 
 	return nil
 }
 
-func (s *Store) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		INSERT INTO gophermart.users (login, password, created_at) VALUES($1, $2, $3)
-			ON CONFLICT (login) DO NOTHING
-				RETURNING id, login, password, created_at
-	`)
-	if err != nil {
-		return nil, err
+func (s *Store) Open(ctx context.Context, app config.AppConfig) error {
+	var err error
+	if s.Pool, err = ConnectToDB(ctx, app.StoreDatabaseURI, app); err != nil {
+		return err
 	}
-	defer stmt.Close()
+	s.db = stdlib.OpenDBFromPool(s.Pool)
+	s.openReplicas(ctx, app)
+
+	return nil
+}
+
+func (s *Store) Migrator() (*migrate.Migrator, error) {
+	// мигратор работает через database/sql поверх того же пула
+	return NewMigrator(s.db)
+}
+
+func (s *Store) Close() error {
+	s.closeReplicas()
+	err := s.db.Close()
+	s.Pool.Close()
+	return err
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.Pool.Ping(ctx)
+}
 
+func (s *Store) Stats() store.Stats {
+	stat := s.Pool.Stat()
+	return store.Stats{
+		MaxConns:                int64(stat.MaxConns()),
+		TotalConns:              int64(stat.TotalConns()),
+		IdleConns:               int64(stat.IdleConns()),
+		AcquiredConns:           int64(stat.AcquiredConns()),
+		AcquireCount:            stat.AcquireCount(),
+		EmptyAcquireCount:       stat.EmptyAcquireCount(),
+		CanceledAcquireCount:    stat.CanceledAcquireCount(),
+		AcquireDuration:         stat.AcquireDuration(),
+		NewConnsCount:           stat.NewConnsCount(),
+		MaxLifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     stat.MaxIdleDestroyCount(),
+	}
+}
+
+func (s *Store) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
 	var id int64
-	var login, password, createdAt string
-	err = stmt.QueryRowContext(ctx, user.Login, user.Password, user.CreatedAt).Scan(&id, &login, &password, &createdAt)
+	var login, password string
+	var createdAt time.Time
+	err := s.Pool.QueryRow(ctx, `
+		INSERT INTO gophermart.users (login, password, created_at, invite_code, tenant_id, email)
+			VALUES($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''))
+				ON CONFLICT (tenant_id, login) DO NOTHING
+					RETURNING id, login, password, created_at
+	`, user.Login, user.Password, user.CreatedAt, user.InviteCode, user.TenantID, user.Email).Scan(&id, &login, &password, &createdAt)
+	var pgErr *pgconn.PgError
 	switch {
-	case err == sql.ErrNoRows:
+	case errors.Is(err, pgx.ErrNoRows):
 		return nil, api.ErrDuplicate
+	case errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == "user_invite_idx":
+		// занятый логин не дает ошибки (ON CONFLICT), это совпал случайный код приглашения
+		return nil, api.ErrInviteCodeTaken
 	case err != nil:
 		return nil, err
 	default:
 		user.ID = id
 		user.Password = password
-		user.CreatedAt = createdAt
+		user.CreatedAt = createdAt.Format(time.RFC3339)
 		return &user, nil
 	}
 }
 
 func (s *Store) GetIDUserByAuth(ctx context.Context, user models.User) (int64, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
+	var res int64
+	err := s.Pool.QueryRow(ctx, `
 		SELECT id FROM gophermart.users
-			WHERE login = $1 AND password = $2
-	`)
-	if err != nil {
+			WHERE login = $1 AND password = $2 AND tenant_id = $3
+	`, user.Login, user.Password, user.TenantID).Scan(&res)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return 0, api.ErrNotFound
+	case err != nil:
 		return 0, err
 	}
 
-	var res int64
-	err = stmt.QueryRowContext(ctx, user.Login, user.Password).Scan(&res)
-	if err != nil {
-		return 0, err
+	return res, nil
+}
+
+func (s *Store) GetUserIDByLogin(ctx context.Context, tenantID, login string) (int64, error) {
+	var id int64
+	err := s.Pool.QueryRow(ctx, `
+		SELECT id FROM gophermart.users WHERE login = $1 AND tenant_id = $2 AND deleted_at IS NULL
+	`, login, tenantID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, api.ErrNotFound
 	}
-	defer stmt.Close()
 
-	return res, nil
+	return id, err
 }
 
 func (s *Store) CreateOrder(ctx context.Context, order models.Order) (string, int64, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
+	var userDB int64
+	var numberDB string
+	var createdAtDB time.Time
+	err := s.Pool.QueryRow(ctx, `
 		WITH cte AS (
-			INSERT INTO gophermart.orders (number, user_id, status, created_at) VALUES($1, $2, $3, $4)
-				ON CONFLICT (number)
+			INSERT INTO gophermart.orders (number, user_id, status, created_at, partner_id, tenant_id)
+				VALUES($1, $2, $3, $4, NULLIF($5::bigint, 0), $6)
+				ON CONFLICT (tenant_id, number)
 					DO NOTHING RETURNING number, user_id, created_at
 		)
 		SELECT * FROM cte
 		UNION
 			SELECT number, user_id, created_at
 				FROM gophermart.orders
-					WHERE number = $1 and user_id != $2
-	`)
-	if err != nil {
-		return "", 0, err
-	}
-	defer stmt.Close()
-
-	var userDB int64
-	var numberDB, createdAtDB string
-	err = stmt.QueryRowContext(ctx, order.Number, order.UserID, order.Status, order.CreatedAt).Scan(&numberDB, &userDB, &createdAtDB)
+					WHERE number = $1 and user_id != $2 AND tenant_id = $6
+	`, order.Number, order.UserID, order.Status, order.CreatedAt, order.PartnerID, order.TenantID).Scan(&numberDB, &userDB, &createdAtDB)
 	switch {
-	case err == sql.ErrNoRows: // owner duplicate
+	case errors.Is(err, pgx.ErrNoRows): // owner duplicate
 		return "", 0, api.ErrDuplicate
 	case err != nil:
 		return "", 0, err
@@ -103,41 +178,43 @@ func (s *Store) CreateOrder(ctx context.Context, order models.Order) (string, in
 }
 
 func (s *Store) GetOrders(ctx context.Context, userID int64) ([]models.Order, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		SELECT number, accrual, status, created_at
-			FROM gophermart.orders
-				WHERE user_id = $1
-				ORDER BY created_at DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-
-	rows, err := stmt.QueryContext(ctx, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
 	var orders []models.Order
-	for rows.Next() {
-		var accrual sql.NullInt64
-		var number, status, createdAt string
-		err = rows.Scan(&number, &accrual, &status, &createdAt)
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT number, accrual, bonus, status, created_at
+				FROM gophermart.orders
+					WHERE user_id = $1
+					ORDER BY created_at DESC
+		`, userID)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		defer rows.Close()
+
+		orders = nil
+		for rows.Next() {
+			var accrual pgtype.Int8
+			var bonus models.Money
+			var number, status string
+			var createdAt time.Time
+			err = rows.Scan(&number, &accrual, &bonus, &status, &createdAt)
+			if err != nil {
+				return err
+			}
+			money := models.Money(accrual.Int64)
+			orders = append(orders, models.Order{
+				Number:    number,
+				Accrual:   models.Money(money.Get()),
+				Bonus:     models.Money(bonus.Get()),
+				Status:    models.OrderState(status),
+				CreatedAt: createdAt.Format(time.RFC3339),
+			})
 		}
-		money := models.Money(accrual.Int64)
-		orders = append(orders, models.Order{
-			Number:    number,
-			Accrual:   models.Money(money.Get()),
-			Status:    models.OrderState(status),
-			CreatedAt: createdAt,
-		})
-	}
 
-	// необходимо проверить ошибки уровня курсора
-	if err := rows.Err(); err != nil {
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -145,161 +222,364 @@ func (s *Store) GetOrders(ctx context.Context, userID int64) ([]models.Order, er
 }
 
 func (s *Store) GetBalance(ctx context.Context, userID int64) (*models.Balance, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		SELECT user_id, current, withdrawn FROM gophermart.balance
-			WHERE user_id = $1
-	`)
+	var userDB int64
+	var current, withdrawn, reserved models.Money
+	err := s.read(ctx, func(q querier) error {
+		err := q.QueryRow(ctx, `
+			SELECT user_id, current, withdrawn, reserved FROM gophermart.balance
+				WHERE user_id = $1
+		`, userID).Scan(&userDB, &current, &withdrawn, &reserved)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
 
-	var userDB int64
-	var current, withdrawn models.Money
-	err = stmt.QueryRowContext(ctx, userID).Scan(&userDB, &current, &withdrawn)
-	balance := models.Balance{
+	return &models.Balance{
 		UserID:    userDB,
 		Current:   models.Money(current.Get()),
 		Withdrawn: models.Money(withdrawn.Get()),
-	}
-	switch {
-	case err == sql.ErrNoRows:
-		return &balance, nil
-	case err != nil:
-		return nil, err
-	default:
-		return &balance, nil
-	}
+		Reserved:  models.Money(reserved.Get()),
+	}, nil
 }
 
 func (s *Store) SetBalance(ctx context.Context, balance models.Balance, userID int64) error {
-	row := s.Conn.QueryRowContext(ctx, `
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
 		INSERT INTO gophermart.balance (user_id, current, withdrawn) VALUES($1, $2, $3)
 			ON CONFLICT (user_id) DO
 				UPDATE SET current = gophermart.balance.current + $2
 	`, userID, balance.Current, balance.Withdrawn)
+	if err != nil {
+		return err
+	}
 
-	if err := row.Err(); err != nil {
+	if err := creditLot(ctx, tx, userID, models.LotSourceAccrual, "", balance.Current, nil); err != nil {
 		return err
 	}
 
-	return nil
+	return tx.Commit(ctx)
 }
 
 func (s *Store) UpdateOrder(ctx context.Context, order models.Order) error {
-	row := s.Conn.QueryRowContext(ctx, `
+	_, err := s.Pool.Exec(ctx, `
 		UPDATE gophermart.orders SET accrual = $1, status = $2
-			WHERE number = $3
-	`, order.Accrual, order.Status, order.Number)
-
-	if err := row.Err(); err != nil {
-		return err
-	}
+			WHERE number = $3 AND user_id = $4
+	`, order.Accrual, order.Status, order.Number, order.UserID)
 
-	return nil
+	return err
 }
 
-func (s *Store) UpdateBalanceAndOrder(ctx context.Context, order models.Order) error {
-	tx, err := s.Conn.BeginTx(ctx, nil)
+func (s *Store) UpdateBalanceAndOrder(ctx context.Context, order models.Order) (models.Money, error) {
+	tx, err := s.Pool.Begin(ctx)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	_, err = tx.ExecContext(ctx, `
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
 		INSERT INTO gophermart.balance (user_id, current, withdrawn) VALUES($1, $2, $3)
 			ON CONFLICT (user_id) DO
 				UPDATE SET current = gophermart.balance.current + $2
-	`, order.UserID, order.Accrual, 0)
+	`, order.UserID, order.Accrual+order.Bonus, 0)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		UPDATE gophermart.orders SET accrual = $1, status = $2
-			WHERE number = $3
-	`, order.Accrual, order.Status, order.Number)
+	if err := creditLot(ctx, tx, order.UserID, models.LotSourceAccrual, order.Number, order.Accrual+order.Bonus, nil); err != nil {
+		return 0, err
+	}
+
+	credited := order.Accrual + order.Bonus
+	if order.Accrual > 0 && order.Status == models.OrderStateProcessed {
+		awarded, err := awardCampaigns(ctx, tx, order)
+		if err != nil {
+			return 0, err
+		}
+		credited += awarded
+	}
+
+	// credited_at отмечает зачисление на баланс: по нему считаются уровни лояльности
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.orders
+			SET accrual = $1, status = $2, bonus = $4, tier = NULLIF($5, ''),
+				credited_at = CASE WHEN $1 > 0 THEN NOW() ELSE credited_at END
+					WHERE number = $3 AND user_id = $6
+	`, order.Accrual, order.Status, order.Number, order.Bonus, order.Tier, order.UserID)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	if order.Bonus > 0 {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO gophermart.ledger (user_id, operation, amount, "order", reason) VALUES($1, $2, $3, $4, $5)
+		`, order.UserID, models.LedgerOperationTierBonus, order.Bonus, order.Number, order.Tier)
+		if err != nil {
+			return 0, err
+		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	return models.Money(credited.Get()), nil
 }
 
 func (s *Store) SetWithdrawal(ctx context.Context, withdrawal models.Withdrawal) error {
-	tx, err := s.Conn.BeginTx(ctx, nil)
+	tx, err := s.Pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 
-	defer tx.Rollback()
+	defer tx.Rollback(ctx)
 
-	row, err := tx.ExecContext(ctx, `
-		WITH cte AS (
-			SELECT user_id FROM gophermart.balance
-				WHERE current - $1 >= 0
+	if err := setWithdrawal(ctx, tx, withdrawal, fromCurrent); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *Store) WithdrawalDone(ctx context.Context, withdrawal models.Withdrawal) (bool, error) {
+	// только основной сервер: реплика может еще не знать о только что выполненном списании
+	var done bool
+	err := s.Pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM gophermart.withdrawals
+				WHERE "order" = $1 AND tenant_id = $2 AND user_id = $3 AND sum = $4
 		)
+	`, withdrawal.Order, withdrawal.TenantID, withdrawal.UserID, withdrawal.Sum).Scan(&done)
+
+	return done, err
+}
+
+// withdrawalSource - столбец баланса, из которого списываются баллы
+type withdrawalSource string
+
+const (
+	fromCurrent  withdrawalSource = "current"  // доступные баллы, партии расходуются при списании
+	fromReserved withdrawalSource = "reserved" // резерв: партии израсходованы при его создании
+)
+
+// setWithdrawal списывает баллы из source и записывает списание в рамках транзакции tx
+func setWithdrawal(ctx context.Context, tx pgx.Tx, withdrawal models.Withdrawal, source withdrawalSource) error {
+	if err := insertWithdrawal(ctx, tx, withdrawal); err != nil {
+		return err
+	}
+
+	// списание и запись о нем фиксируются одной транзакцией
+	tag, err := tx.Exec(ctx, `
 		UPDATE gophermart.balance
-			SET current = current - $1, withdrawn = withdrawn + $1
-				FROM cte
-					WHERE balance.user_id = cte.user_id AND balance.user_id = $2
-						RETURNING current
+			SET `+string(source)+` = `+string(source)+` - $1, withdrawn = withdrawn + $1
+				WHERE user_id = $2 AND `+string(source)+` - $1 >= 0
 	`, withdrawal.Sum, withdrawal.UserID)
 	if err != nil {
 		return err
 	}
-	affected, err := row.RowsAffected()
+	if tag.RowsAffected() == 0 {
+		return api.ErrNotEnoughMoney
+	}
+
+	var earnedAt *time.Time
+	switch source {
+	case fromCurrent:
+		earnedAt, err = consumeLots(ctx, tx, withdrawal.UserID, withdrawal.Sum)
+	case fromReserved:
+		err = tx.QueryRow(ctx, `
+			SELECT earned_at FROM gophermart.holds
+				WHERE "order" = $1 AND user_id = $2 AND tenant_id = $3
+		`, withdrawal.Order, withdrawal.UserID, withdrawal.TenantID).Scan(&earnedAt)
+	}
 	if err != nil {
 		return err
 	}
-	if affected == 0 {
-		return api.ErrNotEnoughMoney
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.withdrawals SET earned_at = $1
+			WHERE "order" = $2 AND user_id = $3 AND tenant_id = $4
+	`, earnedAt, withdrawal.Order, withdrawal.UserID, withdrawal.TenantID)
+
+	return err
+}
+
+// insertWithdrawal записывает списание без изменения баланса. Повтор по ключу
+// идемпотентности или номеру заказа возвращает api.ErrDuplicate
+func insertWithdrawal(ctx context.Context, tx pgx.Tx, withdrawal models.Withdrawal) error {
+	// ключ идемпотентности: конкурентный запрос с тем же ключом ждет
+	// на уникальном индексе, пока первая транзакция не завершится
+	if withdrawal.IdempotencyKey != "" {
+		tag, err := tx.Exec(ctx, `
+			INSERT INTO gophermart.idempotency_keys (user_id, key, "order", sum) VALUES($1, $2, $3, $4)
+				ON CONFLICT (user_id, key) DO NOTHING
+		`, withdrawal.UserID, withdrawal.IdempotencyKey, withdrawal.Order, withdrawal.Sum)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			var same bool
+			err = tx.QueryRow(ctx, `
+				SELECT "order" = $3 AND sum = $4 FROM gophermart.idempotency_keys
+					WHERE user_id = $1 AND key = $2
+			`, withdrawal.UserID, withdrawal.IdempotencyKey, withdrawal.Order, withdrawal.Sum).Scan(&same)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return api.ErrIdempotencyMismatch
+			}
+			// повтор уже выполненного запроса
+			return api.ErrDuplicate
+		}
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO gophermart.withdrawals ("order", user_id, sum) VALUES($1, $2, $3)
-			ON CONFLICT ("order") DO NOTHING
-	`, withdrawal.Order, withdrawal.UserID, withdrawal.Sum)
+	// номер заказа сам по себе ключ идемпотентности: списание по нему возможно только одно
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO gophermart.withdrawals ("order", user_id, sum, tenant_id) VALUES($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, "order") DO NOTHING
+	`, withdrawal.Order, withdrawal.UserID, withdrawal.Sum, withdrawal.TenantID)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		var same bool
+		err = tx.QueryRow(ctx, `
+			SELECT user_id = $2 AND sum = $3 FROM gophermart.withdrawals
+				WHERE "order" = $1 AND tenant_id = $4
+		`, withdrawal.Order, withdrawal.UserID, withdrawal.Sum, withdrawal.TenantID).Scan(&same)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return api.ErrIdempotencyMismatch
+		}
+		// повтор уже выполненного запроса
+		return api.ErrDuplicate
+	}
 
-	return tx.Commit()
+	return nil
 }
 
-func (s *Store) GetWithdrawals(ctx context.Context, userID int64) ([]models.Withdrawal, error) {
-	stmt, err := s.Conn.PrepareContext(ctx, `
-		SELECT "order", sum, created_at
-			FROM gophermart.withdrawals
-				WHERE user_id = $1
-				ORDER BY created_at DESC
-	`)
+func (s *Store) ReverseWithdrawal(ctx context.Context, reversal models.Reversal) (*models.Withdrawal, error) {
+	tx, err := s.Pool.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.QueryContext(ctx, userID)
+	defer tx.Rollback(ctx)
+
+	// блокируем списание, чтобы параллельные возвраты не превысили его сумму
+	var userID int64
+	var sum, reversed models.Money
+	var createdAt time.Time
+	var earnedAt *time.Time
+	err = tx.QueryRow(ctx, `
+		SELECT user_id, sum, reversed, created_at, earned_at FROM gophermart.withdrawals
+			WHERE "order" = $1 AND tenant_id = $2
+				FOR UPDATE
+	`, reversal.Order, reversal.TenantID).Scan(&userID, &sum, &reversed, &createdAt, &earnedAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	// без суммы возвращаем весь остаток
+	if reversal.Sum == 0 {
+		reversal.Sum = sum - reversed
+	}
+	if reversal.Sum <= 0 || reversed+reversal.Sum > sum {
+		return nil, api.ErrReversalExceeded
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.withdrawals SET reversed = reversed + $1
+			WHERE "order" = $2 AND tenant_id = $3
+	`, reversal.Sum, reversal.Order, reversal.TenantID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.balance
+			SET current = current + $1, withdrawn = withdrawn - $1
+				WHERE user_id = $2
+	`, reversal.Sum, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// возвращенные баллы сгорают не позже, чем сгорели бы списанные
+	if err := creditLot(ctx, tx, userID, models.LotSourceReversal, reversal.Order, reversal.Sum, earnedAt); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.ledger (user_id, operation, amount, "order", reason, ref) VALUES($1, $2, $3, $4, $5, $6)
+	`, userID, models.LedgerOperationReversal, reversal.Sum, reversal.Order, reversal.Reason, reversal.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	reversed += reversal.Sum
+	return &models.Withdrawal{
+		Order:     reversal.Order,
+		UserID:    userID,
+		Sum:       models.Money(sum.Get()),
+		Reversed:  models.Money(reversed.Get()),
+		CreatedAt: createdAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *Store) GetWithdrawals(ctx context.Context, userID int64) ([]models.Withdrawal, error) {
 	var withdrawals []models.Withdrawal
-	for rows.Next() {
-		var order, createdAt string
-		var sum models.Money
-		err = rows.Scan(&order, &sum, &createdAt)
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT "order", sum, reversed, created_at
+				FROM gophermart.withdrawals
+					WHERE user_id = $1
+					ORDER BY created_at DESC
+		`, userID)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		defer rows.Close()
+
+		withdrawals = nil
+		for rows.Next() {
+			var order string
+			var sum, reversed models.Money
+			var createdAt time.Time
+			err = rows.Scan(&order, &sum, &reversed, &createdAt)
+			if err != nil {
+				return err
+			}
+			withdrawals = append(withdrawals, models.Withdrawal{
+				Order:     order,
+				Sum:       models.Money(sum.Get()),
+				Reversed:  models.Money(reversed.Get()),
+				CreatedAt: createdAt.Format(time.RFC3339),
+			})
 		}
-		withdrawals = append(withdrawals, models.Withdrawal{
-			Order:     order,
-			Sum:       models.Money(sum.Get()),
-			CreatedAt: createdAt,
-		})
-	}
 
-	// необходимо проверить ошибки уровня курсора
-	if err := rows.Err(); err != nil {
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
 		return nil, err
 	}
 
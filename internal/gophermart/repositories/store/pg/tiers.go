@@ -0,0 +1,109 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+// qualifyingQuery возвращает запрос сумм по пользователям за окно с $1
+func qualifyingQuery(basis models.TierBasis) string {
+	if basis == models.TierBasisSpent {
+		// возвраты уменьшают потраченную сумму
+		return `
+			SELECT user_id, SUM(sum - reversed) AS total FROM gophermart.withdrawals
+				WHERE created_at >= $1
+					GROUP BY user_id`
+	}
+
+	return `
+		SELECT user_id, SUM(accrual + bonus) AS total FROM gophermart.orders
+			WHERE credited_at >= $1
+				GROUP BY user_id`
+}
+
+func (s *Store) GetQualifyingTotal(ctx context.Context, userID int64, basis models.TierBasis, since time.Time) (models.Money, error) {
+	var total models.Money
+	err := s.Pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(q.total), 0) FROM (`+qualifyingQuery(basis)+`) q
+			WHERE q.user_id = $2
+	`, since, userID).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+
+	return models.Money(total.Get()), nil
+}
+
+func (s *Store) GetQualifyingTotals(ctx context.Context, basis models.TierBasis, since time.Time) ([]models.UserTier, error) {
+	// в выборку попадают все, у кого есть баланс: окно сдвигается,
+	// и уровень может понизиться без новых операций
+	rows, err := s.Pool.Query(ctx, `
+		SELECT b.user_id, COALESCE(t.tier, ''), COALESCE(q.total, 0)
+			FROM gophermart.balance b
+				LEFT JOIN gophermart.user_tiers t ON t.user_id = b.user_id
+				LEFT JOIN (`+qualifyingQuery(basis)+`) q ON q.user_id = b.user_id
+			ORDER BY b.user_id
+	`, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tiers []models.UserTier
+	for rows.Next() {
+		var userID int64
+		var tier string
+		var total models.Money
+		if err = rows.Scan(&userID, &tier, &total); err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, models.UserTier{
+			UserID:     userID,
+			Tier:       tier,
+			Qualifying: models.Money(total.Get()),
+		})
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tiers, nil
+}
+
+func (s *Store) GetUserTier(ctx context.Context, userID int64) (*models.UserTier, error) {
+	var tier string
+	var qualifying models.Money
+	var updatedAt time.Time
+	err := s.Pool.QueryRow(ctx, `
+		SELECT tier, qualifying, updated_at FROM gophermart.user_tiers
+			WHERE user_id = $1
+	`, userID).Scan(&tier, &qualifying, &updatedAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return &models.UserTier{UserID: userID}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return &models.UserTier{
+		UserID:     userID,
+		Tier:       tier,
+		Qualifying: models.Money(qualifying.Get()),
+		UpdatedAt:  updatedAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *Store) SetUserTier(ctx context.Context, tier models.UserTier) error {
+	_, err := s.Pool.Exec(ctx, `
+		INSERT INTO gophermart.user_tiers (user_id, tier, qualifying) VALUES($1, $2, $3)
+			ON CONFLICT (user_id) DO
+				UPDATE SET tier = $2, qualifying = $3
+	`, tier.UserID, tier.Tier, tier.Qualifying)
+
+	return err
+}
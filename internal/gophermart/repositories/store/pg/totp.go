@@ -0,0 +1,150 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+)
+
+func (s *Store) CreateTOTP(ctx context.Context, totp models.TOTP, recoveryHashes []string) (*models.TOTP, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// неподтвержденный секрет заменяется новым вместе с кодами восстановления,
+	// включенный второй фактор сначала нужно отключить
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO gophermart.totp (user_id, secret) VALUES($1, $2)
+			ON CONFLICT (user_id) DO
+				UPDATE SET secret = $2, last_step = 0, created_at = NOW()
+					WHERE gophermart.totp.confirmed_at IS NULL
+	`, totp.UserID, totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, api.ErrDuplicate
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, totp.UserID, recoveryHashes); err != nil {
+		return nil, err
+	}
+	created, err := getTOTP(ctx, tx, totp.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return created, tx.Commit(ctx)
+}
+
+func (s *Store) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	// секрет читается только с основного сервера: реплика может не знать
+	// о только что принятом шаге и пропустить повтор кода
+	return getTOTP(ctx, s.Pool, userID)
+}
+
+func (s *Store) ConfirmTOTP(ctx context.Context, userID int64, step int64) error {
+	tag, err := s.Pool.Exec(ctx, `
+		UPDATE gophermart.totp SET confirmed_at = NOW(), last_step = $2
+			WHERE user_id = $1 AND confirmed_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	// шаг принимается один раз: перехваченный код нельзя предъявить повторно
+	tag, err := s.Pool.Exec(ctx, `
+		UPDATE gophermart.totp SET last_step = $2
+			WHERE user_id = $1 AND last_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	tag, err := s.Pool.Exec(ctx, `
+		UPDATE gophermart.recovery_codes SET used_at = NOW()
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteTOTP(ctx context.Context, userID int64) error {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM gophermart.totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return api.ErrNotFound
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// replaceRecoveryCodes заменяет коды восстановления пользователя новыми
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64, hashes []string) error {
+	_, err := tx.Exec(ctx, `DELETE FROM gophermart.recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.recovery_codes (user_id, code_hash)
+			SELECT $1, unnest($2::text[])
+	`, userID, hashes)
+
+	return err
+}
+
+func getTOTP(ctx context.Context, q querier, userID int64) (*models.TOTP, error) {
+	totp := models.TOTP{UserID: userID}
+	err := q.QueryRow(ctx, `
+		SELECT u.tenant_id, u.login, t.secret, t.confirmed_at IS NOT NULL, t.last_step,
+			(SELECT COUNT(*) FROM gophermart.recovery_codes c WHERE c.user_id = t.user_id AND c.used_at IS NULL)
+				FROM gophermart.totp t
+					JOIN gophermart.users u ON u.id = t.user_id
+						WHERE t.user_id = $1
+	`, userID).Scan(&totp.TenantID, &totp.Login, &totp.Secret, &totp.Confirmed, &totp.LastStep, &totp.RecoveryCodesLeft)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	return &totp, nil
+}
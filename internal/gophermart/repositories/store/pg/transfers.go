@@ -0,0 +1,169 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) Transfer(ctx context.Context, transfer models.Transfer, policy models.TransferPolicy) (*models.Transfer, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		SELECT id FROM gophermart.users WHERE login = $1 AND tenant_id = $2 AND deleted_at IS NULL
+	`, transfer.To, transfer.TenantID).Scan(&transfer.ToUserID)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if transfer.ToUserID == transfer.FromUserID {
+		return nil, api.ErrSelfTransfer
+	}
+
+	// у получателя баланса может еще не быть
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.balance (user_id, current, withdrawn) VALUES($1, 0, 0)
+			ON CONFLICT (user_id) DO NOTHING
+	`, transfer.ToUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// балансы блокируются по возрастанию id: встречные переводы между
+	// одними и теми же пользователями не ждут друг друга
+	_, err = tx.Exec(ctx, `
+		SELECT 1 FROM gophermart.balance
+			WHERE user_id IN ($1, $2)
+				ORDER BY user_id
+					FOR UPDATE
+	`, transfer.FromUserID, transfer.ToUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.DailyLimit > 0 {
+		var sent models.Money
+		err = tx.QueryRow(ctx, `
+			SELECT COALESCE(SUM("sum"), 0) FROM gophermart.transfers
+				WHERE from_user_id = $1 AND created_at >= NOW() - INTERVAL '1 day'
+		`, transfer.FromUserID).Scan(&sent)
+		if err != nil {
+			return nil, err
+		}
+		if sent+transfer.Sum > policy.DailyLimit {
+			return nil, api.ErrTransferLimit
+		}
+	}
+
+	res, err := tx.Exec(ctx, `
+		UPDATE gophermart.balance SET current = current - $1
+			WHERE user_id = $2 AND current - $1 >= 0
+	`, transfer.Sum, transfer.FromUserID)
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected() == 0 {
+		return nil, api.ErrNotEnoughMoney
+	}
+
+	// получатель наследует дату самой старой из списанных партий,
+	// иначе перевод продлевал бы срок жизни баллов
+	earnedAt, err := consumeLots(ctx, tx, transfer.FromUserID, transfer.Sum)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE gophermart.balance SET current = current + $1
+			WHERE user_id = $2
+	`, transfer.Sum, transfer.ToUserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := creditLot(ctx, tx, transfer.ToUserID, models.LotSourceTransfer, "", transfer.Sum, earnedAt); err != nil {
+		return nil, err
+	}
+
+	var createdAt time.Time
+	err = tx.QueryRow(ctx, `
+		INSERT INTO gophermart.transfers (from_user_id, to_user_id, "sum", comment) VALUES($1, $2, $3, NULLIF($4, ''))
+			RETURNING id, created_at
+	`, transfer.FromUserID, transfer.ToUserID, transfer.Sum, transfer.Comment).Scan(&transfer.ID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := strconv.FormatInt(transfer.ID, 10)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.ledger (user_id, operation, amount, reason, ref)
+			VALUES($1, $2, $5, NULLIF($6, ''), $7), ($3, $4, $5, NULLIF($6, ''), $7)
+	`, transfer.FromUserID, models.LedgerOperationTransferOut, transfer.ToUserID, models.LedgerOperationTransferIn,
+		transfer.Sum, transfer.Comment, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.Sum = models.Money(transfer.Sum.Get())
+	transfer.Direction = models.TransferDirectionOut
+	transfer.CreatedAt = createdAt.Format(time.RFC3339)
+
+	return &transfer, tx.Commit(ctx)
+}
+
+func (s *Store) GetTransfers(ctx context.Context, userID int64) ([]models.Transfer, error) {
+	var transfers []models.Transfer
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT t.id, t.from_user_id, f.login, t.to_user_id, r.login, t."sum", t.comment, t.created_at
+				FROM gophermart.transfers t
+					JOIN gophermart.users f ON f.id = t.from_user_id
+					JOIN gophermart.users r ON r.id = t.to_user_id
+						WHERE t.from_user_id = $1 OR t.to_user_id = $1
+							ORDER BY t.created_at DESC, t.id DESC
+		`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		transfers = nil
+		for rows.Next() {
+			var t models.Transfer
+			var sum models.Money
+			var comment pgtype.Text
+			var createdAt time.Time
+			err = rows.Scan(&t.ID, &t.FromUserID, &t.From, &t.ToUserID, &t.To, &sum, &comment, &createdAt)
+			if err != nil {
+				return err
+			}
+			t.Sum = models.Money(sum.Get())
+			t.Comment = comment.String
+			t.CreatedAt = createdAt.Format(time.RFC3339)
+			t.Direction = models.TransferDirectionIn
+			if t.FromUserID == userID {
+				t.Direction = models.TransferDirectionOut
+			}
+			transfers = append(transfers, t)
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
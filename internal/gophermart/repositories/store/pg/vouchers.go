@@ -0,0 +1,162 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) CreateVoucherBatch(ctx context.Context, batch models.VoucherBatch, hashes []string) (*models.VoucherBatch, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	var createdAt time.Time
+	err = tx.QueryRow(ctx, `
+		INSERT INTO gophermart.voucher_batches (name, value, count, expires_at, tenant_id) VALUES($1, $2, $3, $4, $5)
+			RETURNING id, created_at
+	`, batch.Name, batch.Value, len(hashes), batch.ExpiresAt, batch.TenantID).Scan(&batch.ID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.vouchers (batch_id, code_hash)
+			SELECT $1, unnest($2::text[])
+	`, batch.ID, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	batch.Value = models.Money(batch.Value.Get())
+	batch.Count = len(hashes)
+	batch.CreatedAt = createdAt.Format(time.RFC3339)
+
+	return &batch, tx.Commit(ctx)
+}
+
+func (s *Store) GetVoucherBatches(ctx context.Context, tenantID string) ([]models.VoucherBatch, error) {
+	var batches []models.VoucherBatch
+	err := s.read(ctx, func(q querier) error {
+		rows, err := q.Query(ctx, `
+			SELECT b.id, b.name, b.value, b.count, b.expires_at, b.created_at,
+				COUNT(v.redeemed_at),
+				COUNT(v.id) FILTER (WHERE v.redeemed_at IS NULL AND b.expires_at <= NOW())
+					FROM gophermart.voucher_batches b
+						LEFT JOIN gophermart.vouchers v ON v.batch_id = b.id
+						WHERE b.tenant_id = $1
+							GROUP BY b.id
+								ORDER BY b.created_at DESC, b.id DESC
+		`, tenantID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		batches = nil
+		for rows.Next() {
+			var b models.VoucherBatch
+			var value models.Money
+			var expiresAt, createdAt time.Time
+			err = rows.Scan(&b.ID, &b.Name, &value, &b.Count, &expiresAt, &createdAt, &b.Redeemed, &b.Expired)
+			if err != nil {
+				return err
+			}
+			b.Value = models.Money(value.Get())
+			b.RedeemedValue = b.Value * models.Money(b.Redeemed)
+			b.ExpiresAt = expiresAt.Format(time.RFC3339)
+			b.CreatedAt = createdAt.Format(time.RFC3339)
+			batches = append(batches, b)
+		}
+
+		// необходимо проверить ошибки уровня курсора
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return batches, nil
+}
+
+func (s *Store) RedeemVoucher(ctx context.Context, tenantID string, userID int64, hash string) (*models.Voucher, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	// блокировка кода не дает погасить его дважды параллельными запросами,
+	// в том числе с разных реплик приложения
+	var voucher models.Voucher
+	var value models.Money
+	var expiresAt time.Time
+	var redeemedBy pgtype.Int8
+	var redeemedAt pgtype.Timestamptz
+	err = tx.QueryRow(ctx, `
+		SELECT v.id, v.batch_id, b.value, b.expires_at, v.redeemed_by, v.redeemed_at
+			FROM gophermart.vouchers v
+				JOIN gophermart.voucher_batches b ON b.id = v.batch_id
+					WHERE v.code_hash = $1 AND b.tenant_id = $2
+						FOR UPDATE OF v
+	`, hash, tenantID).Scan(&voucher.ID, &voucher.BatchID, &value, &expiresAt, &redeemedBy, &redeemedAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	voucher.Value = models.Money(value.Get())
+
+	if redeemedAt.Valid {
+		if redeemedBy.Int64 != userID {
+			return nil, api.ErrVoucherRedeemed
+		}
+		// повтор погашения тем же пользователем
+		voucher.RedeemedAt = redeemedAt.Time.Format(time.RFC3339)
+		return &voucher, api.ErrDuplicate
+	}
+	if !expiresAt.After(time.Now()) {
+		return nil, api.ErrVoucherExpired
+	}
+
+	var now time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE gophermart.vouchers SET redeemed_by = $2, redeemed_at = NOW()
+			WHERE id = $1
+				RETURNING redeemed_at
+	`, voucher.ID, userID).Scan(&now)
+	if err != nil {
+		return nil, err
+	}
+	voucher.RedeemedAt = now.Format(time.RFC3339)
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.balance (user_id, current, withdrawn) VALUES($1, $2, 0)
+			ON CONFLICT (user_id) DO
+				UPDATE SET current = gophermart.balance.current + $2
+	`, userID, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := creditLot(ctx, tx, userID, models.LotSourceVoucher, "", value, nil); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO gophermart.ledger (user_id, operation, amount, ref) VALUES($1, $2, $3, $4)
+	`, userID, models.LedgerOperationVoucher, value, strconv.FormatInt(voucher.ID, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	return &voucher, tx.Commit(ctx)
+}
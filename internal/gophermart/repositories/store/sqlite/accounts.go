@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+)
+
+func (s *Store) GetLedger(ctx context.Context, userID int64) ([]models.LedgerEntry, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT id, operation, amount, "order", reason, ref, created_at
+			FROM ledger
+				WHERE user_id = $1
+				ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.LedgerEntry
+	for rows.Next() {
+		e := models.LedgerEntry{UserID: userID}
+		var amount models.Money
+		var order, reason, ref sql.NullString
+		err = rows.Scan(&e.ID, &e.Operation, &amount, &order, &reason, &ref, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Amount = models.Money(amount.Get())
+		e.Order = order.String
+		e.Reason = reason.String
+		e.Ref = ref.String
+		entries = append(entries, e)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, userID int64) error {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	var tenantID, login string
+	err = tx.QueryRowContext(ctx, `
+		SELECT tenant_id, login FROM users WHERE id = $1 AND deleted_at IS NULL
+	`, userID).Scan(&tenantID, &login)
+	switch {
+	case err == sql.ErrNoRows:
+		return api.ErrNotFound
+	case err != nil:
+		return err
+	}
+
+	// пустой пароль не совпадает ни с одним хэшем, новая версия отзывает токены
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+			SET login = $2, password = '', email = NULL, invite_code = NULL,
+				token_version = token_version + 1, deleted_at = `+nowUTC+`
+					WHERE id = $1
+	`, userID, models.DeletedLoginPrefix+strconv.FormatInt(userID, 10))
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM totp WHERE user_id = $1`,
+		`DELETE FROM recovery_codes WHERE user_id = $1`,
+		`DELETE FROM password_resets WHERE user_id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return err
+		}
+	}
+
+	// логин и адреса не остаются и в служебных записях
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM login_attempts WHERE tenant_id = $1 AND scope = $2 AND value = $3
+	`, tenantID, models.LoginScopeLogin, login)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE audit_log SET login = NULL, ip = NULL
+			WHERE user_id = $1 OR tenant_id = $2 AND login = $3
+	`, userID, tenantID, login)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
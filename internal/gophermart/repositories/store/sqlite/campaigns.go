@@ -0,0 +1,230 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"strings"
+)
+
+// scanner - общий интерфейс sql.Row и sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+const campaignColumns = `id, name, starts_at, ends_at, first_order, min_accrual, segment,
+	multiplier, bonus, budget, spent, active, created_at`
+
+func (s *Store) CreateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		INSERT INTO campaigns (name, starts_at, ends_at, first_order, min_accrual, segment, multiplier, bonus, budget, active, tenant_id)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+				RETURNING `+campaignColumns,
+		campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.FirstOrder, campaign.MinAccrual,
+		strings.Join(campaign.Segment, ","), campaign.Multiplier, campaign.Bonus, campaign.Budget, campaign.Active, campaign.TenantID)
+
+	return scanCampaign(row)
+}
+
+func (s *Store) GetCampaigns(ctx context.Context, tenantID string) ([]models.Campaign, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+			WHERE deleted_at IS NULL AND tenant_id = $1
+				ORDER BY starts_at DESC, id DESC
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var campaigns []models.Campaign
+	for rows.Next() {
+		campaign, err := scanCampaign(rows)
+		if err != nil {
+			return nil, err
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return campaigns, nil
+}
+
+func (s *Store) GetCampaign(ctx context.Context, tenantID string, id int64) (*models.Campaign, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+			WHERE id = $1 AND deleted_at IS NULL AND tenant_id = $2
+	`, id, tenantID)
+
+	return scanCampaign(row)
+}
+
+func (s *Store) UpdateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	// потраченная часть бюджета не меняется: ее ведет только начисление бонусов
+	row := s.Conn.QueryRowContext(ctx, `
+		UPDATE campaigns
+			SET name = $2, starts_at = $3, ends_at = $4, first_order = $5, min_accrual = $6,
+				segment = $7, multiplier = $8, bonus = $9, budget = $10, active = $11
+					WHERE id = $1 AND deleted_at IS NULL AND tenant_id = $12
+						RETURNING `+campaignColumns,
+		campaign.ID, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.FirstOrder, campaign.MinAccrual,
+		strings.Join(campaign.Segment, ","), campaign.Multiplier, campaign.Bonus, campaign.Budget, campaign.Active, campaign.TenantID)
+
+	return scanCampaign(row)
+}
+
+func (s *Store) DeleteCampaign(ctx context.Context, tenantID string, id int64) error {
+	// акция только помечается удаленной: на нее ссылаются начисленные бонусы
+	res, err := s.Conn.ExecContext(ctx, `
+		UPDATE campaigns SET deleted_at = `+nowUTC+`, active = FALSE
+			WHERE id = $1 AND deleted_at IS NULL AND tenant_id = $2
+	`, id, tenantID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+// awardCampaigns начисляет бонусы действующих акций за заказ в рамках транзакции tx
+// и возвращает их сумму. Вызывается до отметки заказа зачисленным, чтобы признак
+// первого заказа был верным
+func awardCampaigns(ctx context.Context, tx *sql.Tx, order models.Order) (models.Money, error) {
+	// BEGIN IMMEDIATE (см. DSN) не дает параллельным начислениям превысить бюджет
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+campaignColumns+` FROM campaigns
+			WHERE active AND deleted_at IS NULL AND starts_at <= `+nowUTC+` AND ends_at > `+nowUTC+`
+				AND (budget = 0 OR spent < budget) AND tenant_id = $1
+					ORDER BY id
+	`, order.TenantID)
+	if err != nil {
+		return 0, err
+	}
+	var campaigns []models.Campaign
+	for rows.Next() {
+		campaign, err := scanCampaignCents(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		campaigns = append(campaigns, *campaign)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(campaigns) == 0 {
+		return 0, err
+	}
+
+	var firstOrder bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (
+			SELECT 1 FROM orders
+				WHERE user_id = $1 AND credited_at IS NOT NULL AND number != $2
+		)
+	`, order.UserID, order.Number).Scan(&firstOrder)
+	if err != nil {
+		return 0, err
+	}
+	var tier string
+	err = tx.QueryRowContext(ctx, `
+		SELECT tier FROM user_tiers WHERE user_id = $1
+	`, order.UserID).Scan(&tier)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var awarded models.Money
+	for _, campaign := range campaigns {
+		award := campaign.Award(order.Accrual, firstOrder, tier)
+		if award <= 0 {
+			continue
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO campaign_awards (campaign_id, user_id, "order", amount) VALUES($1, $2, $3, $4)
+				ON CONFLICT (campaign_id, "order") DO NOTHING
+		`, campaign.ID, order.UserID, order.Number, award)
+		if err != nil {
+			return 0, err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE campaigns SET spent = spent + $1
+				WHERE id = $2
+		`, award, campaign.ID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE balance SET current = current + $1
+				WHERE user_id = $2
+		`, award, order.UserID)
+		if err != nil {
+			return 0, err
+		}
+		if err := creditLot(ctx, tx, order.UserID, models.LotSourceCampaign, order.Number, award, sql.NullString{}); err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO ledger (user_id, operation, amount, "order", reason, ref) VALUES($1, $2, $3, $4, $5, $6)
+		`, order.UserID, models.LedgerOperationCampaignBonus, award, order.Number, campaign.Name, strconv.FormatInt(campaign.ID, 10))
+		if err != nil {
+			return 0, err
+		}
+		awarded += award
+	}
+
+	return awarded, nil
+}
+
+// scanCampaign читает акцию с суммами в баллах
+func scanCampaign(row scanner) (*models.Campaign, error) {
+	campaign, err := scanCampaignCents(row)
+	if err != nil {
+		return nil, err
+	}
+	campaign.MinAccrual = models.Money(campaign.MinAccrual.Get())
+	campaign.Bonus = models.Money(campaign.Bonus.Get())
+	campaign.Budget = models.Money(campaign.Budget.Get())
+	campaign.Spent = models.Money(campaign.Spent.Get())
+
+	return campaign, nil
+}
+
+// scanCampaignCents читает акцию с суммами в копейках, как они хранятся
+func scanCampaignCents(row scanner) (*models.Campaign, error) {
+	var campaign models.Campaign
+	var segment string
+	err := row.Scan(&campaign.ID, &campaign.Name, &campaign.StartsAt, &campaign.EndsAt, &campaign.FirstOrder, &campaign.MinAccrual,
+		&segment, &campaign.Multiplier, &campaign.Bonus, &campaign.Budget, &campaign.Spent, &campaign.Active, &campaign.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if segment != "" {
+		campaign.Segment = strings.Split(segment, ",")
+	}
+
+	return &campaign, nil
+}
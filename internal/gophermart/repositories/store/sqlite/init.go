@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/migrate"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"strings"
+)
+
+// pragmas выставляются для каждого соединения из пула:
+// WAL позволяет читать параллельно с записью, busy_timeout заставляет
+// конкурирующих писателей ждать освобождения блокировки вместо SQLITE_BUSY
+var pragmas = []string{
+	"journal_mode(WAL)",
+	"busy_timeout(5000)",
+	"foreign_keys(1)",
+	"synchronous(NORMAL)",
+}
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// NewMigrator создает мигратор со встроенными миграциями.
+// Блокировка не нужна: SQLite работает на одном узле, а миграции
+// выполняются в транзакциях BEGIN IMMEDIATE (см. DSN)
+func NewMigrator(conn *sql.DB) (*migrate.Migrator, error) {
+	list, err := migrate.Load(migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrate.Migrator{
+		DB:         conn,
+		Migrations: list,
+		Table:      "schema_migrations",
+	}, nil
+}
+
+// DSN дополняет путь к файлу БД параметрами драйвера: прагмами и режимом
+// BEGIN IMMEDIATE, чтобы пишущие транзакции сразу брали блокировку на запись
+func DSN(dsn string) string {
+	if dsn == "" {
+		dsn = "gophermart.db"
+	}
+	if !strings.HasPrefix(dsn, "file:") {
+		dsn = "file:" + dsn
+	}
+
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		query = url.Values{}
+	}
+	if _, ok := query["_pragma"]; !ok {
+		query["_pragma"] = pragmas
+	}
+	if query.Get("_txlock") == "" {
+		query.Set("_txlock", "immediate")
+	}
+
+	return path + "?" + query.Encode()
+}
+
+func OpenDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", DSN(dsn))
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Log.Infoln("Opened SQLite database")
+
+	return db, nil
+}
@@ -0,0 +1,236 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+const loginAttemptColumns = `tenant_id, scope, value, failures, last_failure_at, locked_until`
+
+func (s *Store) GetLoginAttempts(ctx context.Context, tenantID, login, ip string) ([]models.LoginAttempts, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT `+loginAttemptColumns+` FROM login_attempts
+			WHERE tenant_id = $1 AND (scope = $2 AND value = $3 OR scope = $4 AND value = $5)
+	`, tenantID, models.LoginScopeLogin, login, models.LoginScopeIP, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempts
+	for rows.Next() {
+		a, err := scanLoginAttempts(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, *a)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attempts, nil
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, tenantID, login, ip string, policy models.LoginPolicy) error {
+	// BEGIN IMMEDIATE (см. DSN) блокирует базу целиком: параллельные неудачи
+	// с одним логином не теряются
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, key := range []models.LoginAttempts{
+		{TenantID: tenantID, Scope: models.LoginScopeLogin, Value: login},
+		{TenantID: tenantID, Scope: models.LoginScopeIP, Value: ip},
+	} {
+		row := tx.QueryRowContext(ctx, `
+			SELECT `+loginAttemptColumns+` FROM login_attempts
+				WHERE tenant_id = $1 AND scope = $2 AND value = $3
+		`, key.TenantID, key.Scope, key.Value)
+		current, err := scanLoginAttempts(row)
+		switch {
+		case err == sql.ErrNoRows:
+			current = &key
+		case err != nil:
+			return err
+		}
+
+		attempts, locked := policy.Fail(*current, now)
+		var lockedUntil sql.NullString
+		if !attempts.LockedUntil.IsZero() {
+			lockedUntil = sql.NullString{String: formatTime(attempts.LockedUntil), Valid: true}
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO login_attempts (`+loginAttemptColumns+`) VALUES($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (tenant_id, scope, value) DO
+					UPDATE SET failures = $4, last_failure_at = $5, locked_until = $6
+		`, attempts.TenantID, attempts.Scope, attempts.Value, attempts.Failures, formatTime(attempts.LastFailureAt), lockedUntil)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			err = insertAuditEvent(ctx, tx, models.AuditEvent{
+				TenantID: tenantID,
+				Event:    models.AuditEventLoginLocked,
+				Actor:    "system",
+				Login:    login,
+				IP:       ip,
+				Details: fmt.Sprintf("%s locked after %d failed attempts until %s",
+					attempts.Scope, attempts.Failures, formatTime(attempts.LockedUntil)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ResetLoginAttempts(ctx context.Context, tenantID string, scope models.LoginScope, value string) (bool, error) {
+	var lockedUntil sql.NullString
+	err := s.Conn.QueryRowContext(ctx, `
+		DELETE FROM login_attempts
+			WHERE tenant_id = $1 AND scope = $2 AND value = $3
+				RETURNING locked_until
+	`, tenantID, scope, value).Scan(&lockedUntil)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return lockedUntil.Valid && lockedUntil.String > formatTime(time.Now()), nil
+}
+
+func (s *Store) GetLoginLockouts(ctx context.Context, tenantID string) ([]models.LoginAttempts, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT `+loginAttemptColumns+` FROM login_attempts
+			WHERE tenant_id = $1 AND locked_until > $2
+				ORDER BY locked_until
+	`, tenantID, formatTime(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockouts []models.LoginAttempts
+	for rows.Next() {
+		a, err := scanLoginAttempts(rows)
+		if err != nil {
+			return nil, err
+		}
+		lockouts = append(lockouts, *a)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lockouts, nil
+}
+
+func (s *Store) DeleteStaleLoginAttempts(ctx context.Context, failedBefore time.Time) (int64, error) {
+	res, err := s.Conn.ExecContext(ctx, `
+		DELETE FROM login_attempts
+			WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until <= $2)
+	`, formatTime(failedBefore), formatTime(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (s *Store) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) GetAuditEvents(ctx context.Context, tenantID, event string, limit int) ([]models.AuditEvent, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT id, event, actor, user_id, login, ip, details, created_at FROM audit_log
+			WHERE tenant_id = $1 AND ($2 = '' OR event = $2)
+				ORDER BY id DESC
+					LIMIT $3
+	`, tenantID, event, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var e models.AuditEvent
+		var userID sql.NullInt64
+		var login, ip, details sql.NullString
+		err = rows.Scan(&e.ID, &e.Event, &e.Actor, &userID, &login, &ip, &details, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.TenantID = tenantID
+		e.UserID = userID.Int64
+		e.Login = login.String
+		e.IP = ip.String
+		e.Details = details.String
+		events = append(events, e)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, event models.AuditEvent) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_log (tenant_id, event, actor, user_id, login, ip, details)
+			VALUES($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''))
+	`, event.TenantID, event.Event, event.Actor, event.UserID, event.Login, event.IP, event.Details)
+
+	return err
+}
+
+func scanLoginAttempts(row scanner) (*models.LoginAttempts, error) {
+	var a models.LoginAttempts
+	var lastFailureAt string
+	var lockedUntil sql.NullString
+	err := row.Scan(&a.TenantID, &a.Scope, &a.Value, &a.Failures, &lastFailureAt, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if a.LastFailureAt, err = time.Parse(time.RFC3339, lastFailureAt); err != nil {
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		if a.LockedUntil, err = time.Parse(time.RFC3339, lockedUntil.String); err != nil {
+			return nil, err
+		}
+	}
+
+	return &a, nil
+}
@@ -0,0 +1,201 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+func (s *Store) GetExpiringLots(ctx context.Context, userID int64, earnedBefore time.Time) ([]models.Lot, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT id, source, "order", remaining, earned_at FROM lots
+			WHERE user_id = $1 AND remaining > 0 AND earned_at <= $2
+				ORDER BY earned_at, id
+	`, userID, formatTime(earnedBefore))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lots []models.Lot
+	for rows.Next() {
+		var id int64
+		var source, earnedAt string
+		var order sql.NullString
+		var remaining models.Money
+		err = rows.Scan(&id, &source, &order, &remaining, &earnedAt)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, models.Lot{
+			ID:       id,
+			UserID:   userID,
+			Source:   models.LotSource(source),
+			Order:    order.String,
+			Amount:   models.Money(remaining.Get()),
+			EarnedAt: earnedAt,
+		})
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+func (s *Store) GetExpiringBalances(ctx context.Context, tenantID string, earnedBefore time.Time) ([]models.Balance, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT l.user_id, SUM(l.remaining) FROM lots l
+			JOIN users u ON u.id = l.user_id
+				WHERE l.remaining > 0 AND l.earned_at <= $1 AND u.tenant_id = $2
+				GROUP BY l.user_id
+				ORDER BY l.user_id
+	`, formatTime(earnedBefore), tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []models.Balance
+	for rows.Next() {
+		var userID int64
+		var expiring models.Money
+		if err = rows.Scan(&userID, &expiring); err != nil {
+			return nil, err
+		}
+		balances = append(balances, models.Balance{
+			UserID:   userID,
+			TenantID: tenantID,
+			Expiring: models.Money(expiring.Get()),
+		})
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (s *Store) ExpireLots(ctx context.Context, earnedBefore time.Time) (int64, error) {
+	// BEGIN IMMEDIATE (см. DSN) блокирует базу целиком, поэтому все пользователи
+	// сгорают одной транзакцией без риска разойтись со списаниями
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	cutoff := formatTime(earnedBefore)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger (user_id, operation, amount, "order", ref)
+			SELECT user_id, $2, remaining, "order", source FROM lots
+				WHERE remaining > 0 AND earned_at <= $1
+	`, cutoff, models.LedgerOperationExpiry)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE balance
+			SET current = MAX(current - e.sum, 0)
+				FROM (
+					SELECT user_id, SUM(remaining) AS sum FROM lots
+						WHERE remaining > 0 AND earned_at <= $1
+							GROUP BY user_id
+				) AS e
+					WHERE balance.user_id = e.user_id
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE lots SET remaining = 0, expired_at = `+nowUTC+`
+			WHERE remaining > 0 AND earned_at <= $1
+	`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	expired, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return expired, tx.Commit()
+}
+
+// creditLot заводит партию начисленных баллов. Без earnedAt партия считается
+// начисленной сейчас
+func creditLot(ctx context.Context, tx *sql.Tx, userID int64, source models.LotSource, order string, amount models.Money, earnedAt sql.NullString) error {
+	if amount <= 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO lots (user_id, source, "order", amount, remaining, earned_at)
+			VALUES($1, $2, NULLIF($3, ''), $4, $4, COALESCE($5, `+nowUTC+`))
+	`, userID, source, order, amount, earnedAt)
+
+	return err
+}
+
+// consumeLots расходует партии пользователя в порядке начисления и возвращает
+// дату самой старой из затронутых
+func consumeLots(ctx context.Context, tx *sql.Tx, userID int64, amount models.Money) (sql.NullString, error) {
+	var oldest sql.NullString
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, remaining, earned_at FROM lots
+			WHERE user_id = $1 AND remaining > 0
+				ORDER BY earned_at, id
+	`, userID)
+	if err != nil {
+		return oldest, err
+	}
+
+	type take struct {
+		id     int64
+		amount int64
+	}
+	var takes []take
+	left := int64(amount)
+	for rows.Next() && left > 0 {
+		var id, remaining int64
+		var earnedAt string
+		if err := rows.Scan(&id, &remaining, &earnedAt); err != nil {
+			rows.Close()
+			return oldest, err
+		}
+		if !oldest.Valid {
+			oldest = sql.NullString{String: earnedAt, Valid: true}
+		}
+		n := min(remaining, left)
+		takes = append(takes, take{id: id, amount: n})
+		left -= n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return oldest, err
+	}
+
+	for _, t := range takes {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE lots SET remaining = remaining - $1
+				WHERE id = $2
+		`, t.amount, t.id)
+		if err != nil {
+			return oldest, err
+		}
+	}
+
+	return oldest, nil
+}
+
+// formatTime приводит время к формату, в котором хранятся даты (RFC3339, UTC)
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
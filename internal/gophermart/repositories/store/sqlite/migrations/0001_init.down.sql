@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS balance_updated_at;
+DROP TRIGGER IF EXISTS orders_updated_at;
+DROP TABLE IF EXISTS withdrawals;
+DROP TABLE IF EXISTS balance;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
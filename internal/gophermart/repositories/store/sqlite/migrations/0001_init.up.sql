@@ -0,0 +1,51 @@
+-- users:
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    login TEXT NOT NULL,
+    password TEXT NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS user_idx ON users (login);
+
+-- orders:
+CREATE TABLE IF NOT EXISTS orders (
+    number TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    accrual INTEGER,
+    status TEXT NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS order_idx ON orders (number);
+
+-- balance:
+CREATE TABLE IF NOT EXISTS balance (
+    user_id INTEGER NOT NULL,
+    current INTEGER NOT NULL,
+    withdrawn INTEGER NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS balance_idx ON balance (user_id);
+
+-- withdrawals:
+CREATE TABLE IF NOT EXISTS withdrawals (
+    "order" TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    "sum" INTEGER NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_idx ON withdrawals ("order");
+
+-- триггеры для поля updated_at
+CREATE TRIGGER IF NOT EXISTS orders_updated_at
+    AFTER UPDATE ON orders FOR EACH ROW
+BEGIN
+    UPDATE orders SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER IF NOT EXISTS balance_updated_at
+    AFTER UPDATE ON balance FOR EACH ROW
+BEGIN
+    UPDATE balance SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,10 @@
+-- ключи идемпотентности списаний: повтор запроса с тем же ключом
+-- возвращает исходный результат, а не списывает баллы повторно
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    "order" TEXT NOT NULL,
+    "sum" INTEGER NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS ledger;
+ALTER TABLE withdrawals DROP COLUMN reversed;
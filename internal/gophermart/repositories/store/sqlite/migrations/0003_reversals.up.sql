@@ -0,0 +1,15 @@
+-- сколько баллов уже возвращено по списанию
+ALTER TABLE withdrawals ADD COLUMN reversed INTEGER NOT NULL DEFAULT 0;
+
+-- история операций с балансом помимо начислений и списаний
+CREATE TABLE IF NOT EXISTS ledger (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    operation TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    "order" TEXT,
+    reason TEXT,
+    ref TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE INDEX IF NOT EXISTS ledger_user_idx ON ledger (user_id, created_at);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS holds_updated_at;
+DROP TABLE IF EXISTS holds;
+ALTER TABLE balance DROP COLUMN reserved;
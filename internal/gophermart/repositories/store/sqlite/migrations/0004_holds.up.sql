@@ -0,0 +1,21 @@
+-- баллы, зарезервированные под неподтвержденные покупки
+ALTER TABLE balance ADD COLUMN reserved INTEGER NOT NULL DEFAULT 0;
+
+-- резервы: HELD -> CAPTURED (списание) | VOIDED (отмена) | EXPIRED (истек срок)
+CREATE TABLE IF NOT EXISTS holds (
+    "order" TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    "sum" INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS hold_idx ON holds ("order");
+CREATE INDEX IF NOT EXISTS hold_expires_idx ON holds (expires_at) WHERE status = 'HELD';
+
+CREATE TRIGGER IF NOT EXISTS holds_updated_at
+    AFTER UPDATE ON holds FOR EACH ROW
+BEGIN
+    UPDATE holds SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,3 @@
+ALTER TABLE holds DROP COLUMN earned_at;
+ALTER TABLE withdrawals DROP COLUMN earned_at;
+DROP TABLE IF EXISTS lots;
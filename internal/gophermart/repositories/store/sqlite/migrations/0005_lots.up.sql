@@ -0,0 +1,25 @@
+-- партии начисленных баллов: сгорают через заданный срок после earned_at,
+-- списания расходуют их в порядке начисления (FIFO)
+CREATE TABLE IF NOT EXISTS lots (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    source TEXT NOT NULL,
+    "order" TEXT,
+    amount INTEGER NOT NULL,
+    remaining INTEGER NOT NULL,
+    earned_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    expired_at TEXT
+);
+CREATE INDEX IF NOT EXISTS lots_user_idx ON lots (user_id, earned_at, id) WHERE remaining > 0;
+CREATE INDEX IF NOT EXISTS lots_earned_idx ON lots (earned_at) WHERE remaining > 0;
+
+-- дата самой старой партии, из которой списаны баллы: при возврате
+-- баллы получают ее же, чтобы отмена покупки не продлевала их срок
+ALTER TABLE withdrawals ADD COLUMN earned_at TEXT;
+ALTER TABLE holds ADD COLUMN earned_at TEXT;
+
+-- текущие остатки становятся партиями, начисленными в момент миграции
+INSERT INTO lots (user_id, source, amount, remaining)
+    SELECT user_id, 'MIGRATION', current, current FROM balance
+        WHERE current > 0;
+UPDATE holds SET earned_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE status = 'HELD';
@@ -0,0 +1,7 @@
+DROP TRIGGER IF EXISTS user_tiers_updated_at;
+DROP TABLE IF EXISTS user_tiers;
+DROP INDEX IF EXISTS withdrawal_user_idx;
+DROP INDEX IF EXISTS order_credited_idx;
+ALTER TABLE orders DROP COLUMN credited_at;
+ALTER TABLE orders DROP COLUMN tier;
+ALTER TABLE orders DROP COLUMN bonus;
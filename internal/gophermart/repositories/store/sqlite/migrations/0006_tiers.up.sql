@@ -0,0 +1,22 @@
+-- надбавка уровня лояльности сверх начисления accrual и время зачисления на баланс
+ALTER TABLE orders ADD COLUMN bonus INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN tier TEXT;
+ALTER TABLE orders ADD COLUMN credited_at TEXT;
+UPDATE orders SET credited_at = updated_at WHERE status = 'PROCESSED' AND accrual > 0;
+CREATE INDEX IF NOT EXISTS order_credited_idx ON orders (user_id, credited_at) WHERE credited_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS withdrawal_user_idx ON withdrawals (user_id, created_at);
+
+-- текущий уровень пользователя и сумма, по которой он присвоен
+CREATE TABLE IF NOT EXISTS user_tiers (
+    user_id INTEGER PRIMARY KEY,
+    tier TEXT NOT NULL,
+    qualifying INTEGER NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+CREATE TRIGGER IF NOT EXISTS user_tiers_updated_at
+    AFTER UPDATE ON user_tiers FOR EACH ROW
+BEGIN
+    UPDATE user_tiers SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS campaigns_updated_at;
+DROP TABLE IF EXISTS campaign_awards;
+DROP TABLE IF EXISTS campaigns;
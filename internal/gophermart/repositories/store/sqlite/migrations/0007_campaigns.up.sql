@@ -0,0 +1,35 @@
+-- промо-акции: бонусные баллы сверх начисления по правилам и в пределах бюджета
+CREATE TABLE IF NOT EXISTS campaigns (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    starts_at TEXT NOT NULL,
+    ends_at TEXT NOT NULL,
+    first_order INTEGER NOT NULL DEFAULT 0,
+    min_accrual INTEGER NOT NULL DEFAULT 0,
+    segment TEXT NOT NULL DEFAULT '',
+    multiplier REAL NOT NULL DEFAULT 0,
+    bonus INTEGER NOT NULL DEFAULT 0,
+    budget INTEGER NOT NULL DEFAULT 0,
+    spent INTEGER NOT NULL DEFAULT 0,
+    active INTEGER NOT NULL DEFAULT 1,
+    deleted_at TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE INDEX IF NOT EXISTS campaign_period_idx ON campaigns (starts_at, ends_at) WHERE active AND deleted_at IS NULL;
+
+-- начисленные по акциям бонусы: по заказу акция срабатывает не больше раза
+CREATE TABLE IF NOT EXISTS campaign_awards (
+    campaign_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    "order" TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    PRIMARY KEY (campaign_id, "order")
+);
+
+CREATE TRIGGER IF NOT EXISTS campaigns_updated_at
+    AFTER UPDATE ON campaigns FOR EACH ROW
+BEGIN
+    UPDATE campaigns SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
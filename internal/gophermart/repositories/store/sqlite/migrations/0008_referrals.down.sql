@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS referrals;
+DROP INDEX IF EXISTS user_invite_idx;
+ALTER TABLE users DROP COLUMN invite_code;
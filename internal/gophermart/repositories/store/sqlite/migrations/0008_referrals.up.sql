@@ -0,0 +1,18 @@
+-- личный код приглашения пользователя
+ALTER TABLE users ADD COLUMN invite_code TEXT;
+UPDATE users SET invite_code = hex(randomblob(4)) WHERE invite_code IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS user_invite_idx ON users (invite_code);
+
+-- приглашения: PENDING -> REWARDED (первый заказ обработан) | REJECTED (сработал лимит)
+CREATE TABLE IF NOT EXISTS referrals (
+    referee_id INTEGER PRIMARY KEY,
+    referrer_id INTEGER NOT NULL,
+    status TEXT NOT NULL,
+    reason TEXT,
+    referrer_reward INTEGER NOT NULL DEFAULT 0,
+    referee_reward INTEGER NOT NULL DEFAULT 0,
+    "order" TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    rewarded_at TEXT
+);
+CREATE INDEX IF NOT EXISTS referral_referrer_idx ON referrals (referrer_id, created_at);
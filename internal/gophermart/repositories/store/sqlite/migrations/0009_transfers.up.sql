@@ -0,0 +1,11 @@
+-- переводы баллов между пользователями
+CREATE TABLE IF NOT EXISTS transfers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    from_user_id INTEGER NOT NULL,
+    to_user_id INTEGER NOT NULL,
+    "sum" INTEGER NOT NULL,
+    comment TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE INDEX IF NOT EXISTS transfer_from_idx ON transfers (from_user_id, created_at);
+CREATE INDEX IF NOT EXISTS transfer_to_idx ON transfers (to_user_id, created_at);
@@ -0,0 +1,20 @@
+-- выпуски подарочных сертификатов: номинал и срок действия общие на выпуск
+CREATE TABLE IF NOT EXISTS voucher_batches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    value INTEGER NOT NULL,
+    count INTEGER NOT NULL,
+    expires_at TEXT NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+-- одноразовые коды сертификатов, хранится только хэш кода
+CREATE TABLE IF NOT EXISTS vouchers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    batch_id INTEGER NOT NULL REFERENCES voucher_batches (id),
+    code_hash TEXT NOT NULL,
+    redeemed_by INTEGER,
+    redeemed_at TEXT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS voucher_code_hash_idx ON vouchers (code_hash);
+CREATE INDEX IF NOT EXISTS voucher_batch_idx ON vouchers (batch_id);
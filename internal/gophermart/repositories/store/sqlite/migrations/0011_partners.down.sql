@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS order_partner_idx;
+ALTER TABLE orders DROP COLUMN partner_id;
+DROP TRIGGER IF EXISTS partners_updated_at;
+DROP TABLE IF EXISTS partners;
@@ -0,0 +1,23 @@
+-- партнеры: магазины, которые регистрируют заказы за покупателей по API-ключу.
+-- Хранится только хэш ключа, префикс - чтобы ключ можно было узнать в списке
+CREATE TABLE IF NOT EXISTS partners (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    updated_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS partner_key_hash_idx ON partners (key_hash);
+
+-- партнер, зарегистрировавший заказ
+ALTER TABLE orders ADD COLUMN partner_id INTEGER;
+CREATE INDEX IF NOT EXISTS order_partner_idx ON orders (partner_id, created_at) WHERE partner_id IS NOT NULL;
+
+CREATE TRIGGER IF NOT EXISTS partners_updated_at
+    AFTER UPDATE ON partners FOR EACH ROW
+BEGIN
+    UPDATE partners SET updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE rowid = NEW.rowid;
+END;
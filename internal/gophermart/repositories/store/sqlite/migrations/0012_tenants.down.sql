@@ -0,0 +1,19 @@
+ALTER TABLE partners DROP COLUMN tenant_id;
+ALTER TABLE voucher_batches DROP COLUMN tenant_id;
+ALTER TABLE campaigns DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS hold_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS hold_idx ON holds ("order");
+ALTER TABLE holds DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS withdrawal_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_idx ON withdrawals ("order");
+ALTER TABLE withdrawals DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS order_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS order_idx ON orders (number);
+ALTER TABLE orders DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS user_tenant_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS user_idx ON users (login);
+ALTER TABLE users DROP COLUMN tenant_id;
@@ -0,0 +1,22 @@
+-- программы лояльности разных брендов в одной инсталляции: существующие данные
+-- относятся к программе по умолчанию, логины и номера заказов уникальны внутри программы
+ALTER TABLE users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS user_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS user_tenant_idx ON users (tenant_id, login);
+
+ALTER TABLE orders ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS order_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS order_tenant_idx ON orders (tenant_id, number);
+
+ALTER TABLE withdrawals ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS withdrawal_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_tenant_idx ON withdrawals (tenant_id, "order");
+
+ALTER TABLE holds ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+DROP INDEX IF EXISTS hold_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS hold_tenant_idx ON holds (tenant_id, "order");
+
+-- акции, сертификаты и партнеры принадлежат одной программе
+ALTER TABLE campaigns ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE voucher_batches ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE partners ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS audit_log_tenant_idx;
+DROP TABLE IF EXISTS audit_log;
+DROP INDEX IF EXISTS login_attempt_locked_idx;
+DROP TABLE IF EXISTS login_attempts;
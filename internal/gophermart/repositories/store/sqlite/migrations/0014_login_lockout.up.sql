@@ -0,0 +1,25 @@
+-- неудачные попытки входа по логину и по IP; считаются и для несуществующих логинов
+CREATE TABLE IF NOT EXISTS login_attempts (
+    tenant_id TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    value TEXT NOT NULL,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    locked_until TEXT,
+    PRIMARY KEY (tenant_id, scope, value)
+);
+CREATE INDEX IF NOT EXISTS login_attempt_locked_idx ON login_attempts (tenant_id, locked_until) WHERE locked_until IS NOT NULL;
+
+-- журнал аудита событий безопасности
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    event TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    user_id INTEGER,
+    login TEXT,
+    ip TEXT,
+    details TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE INDEX IF NOT EXISTS audit_log_tenant_idx ON audit_log (tenant_id, created_at);
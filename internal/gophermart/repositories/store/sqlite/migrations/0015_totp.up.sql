@@ -0,0 +1,17 @@
+-- второй фактор TOTP: секрет действует после подтверждения первым кодом,
+-- last_step - последний принятый шаг времени, повтор кода не принимается
+CREATE TABLE IF NOT EXISTS totp (
+    user_id INTEGER PRIMARY KEY,
+    secret TEXT NOT NULL,
+    confirmed_at TEXT,
+    last_step INTEGER NOT NULL DEFAULT 0,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+-- одноразовые коды восстановления на случай потери устройства; хранится только хэш
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at TEXT,
+    PRIMARY KEY (user_id, code_hash)
+);
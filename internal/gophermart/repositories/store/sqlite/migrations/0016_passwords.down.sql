@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS password_reset_user_idx;
+DROP TABLE IF EXISTS password_resets;
+ALTER TABLE users DROP COLUMN token_version;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,14 @@
+-- канал восстановления доступа и версия токенов: смена пароля увеличивает версию,
+-- и выданные раньше токены перестают действовать
+ALTER TABLE users ADD COLUMN email TEXT;
+ALTER TABLE users ADD COLUMN token_version INTEGER NOT NULL DEFAULT 0;
+
+-- одноразовые токены сброса пароля; хранится только хэш
+CREATE TABLE IF NOT EXISTS password_resets (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires_at TEXT NOT NULL,
+    used_at TEXT,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+CREATE INDEX IF NOT EXISTS password_reset_user_idx ON password_resets (user_id);
@@ -0,0 +1,15 @@
+CREATE TABLE withdrawals_old (
+    "order" TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    "sum" INTEGER NOT NULL,
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    reversed INTEGER NOT NULL DEFAULT 0,
+    earned_at TEXT,
+    tenant_id TEXT NOT NULL DEFAULT 'default'
+);
+INSERT INTO withdrawals_old ("order", user_id, "sum", created_at, reversed, earned_at, tenant_id)
+    SELECT "order", user_id, "sum", created_at, reversed, earned_at, tenant_id FROM withdrawals;
+DROP TABLE withdrawals;
+ALTER TABLE withdrawals_old RENAME TO withdrawals;
+CREATE INDEX IF NOT EXISTS withdrawal_user_idx ON withdrawals (user_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_tenant_idx ON withdrawals (tenant_id, "order");
@@ -0,0 +1,18 @@
+-- списание всегда уменьшает баланс: запись с неположительной суммой начислила бы баллы.
+-- Такие записи, если они есть, нужно исправить до миграции.
+-- SQLite не добавляет ограничения к существующей таблице, поэтому она пересоздается
+CREATE TABLE withdrawals_new (
+    "order" TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    "sum" INTEGER NOT NULL CHECK ("sum" > 0),
+    created_at TEXT DEFAULT (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    reversed INTEGER NOT NULL DEFAULT 0,
+    earned_at TEXT,
+    tenant_id TEXT NOT NULL DEFAULT 'default'
+);
+INSERT INTO withdrawals_new ("order", user_id, "sum", created_at, reversed, earned_at, tenant_id)
+    SELECT "order", user_id, "sum", created_at, reversed, earned_at, tenant_id FROM withdrawals;
+DROP TABLE withdrawals;
+ALTER TABLE withdrawals_new RENAME TO withdrawals;
+CREATE INDEX IF NOT EXISTS withdrawal_user_idx ON withdrawals (user_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawal_tenant_idx ON withdrawals (tenant_id, "order");
@@ -0,0 +1,191 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strings"
+	"time"
+)
+
+const partnerColumns = `id, name, scopes, key_prefix, active, created_at, tenant_id`
+
+func (s *Store) CreatePartner(ctx context.Context, partner models.Partner, keyHash string) (*models.Partner, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		INSERT INTO partners (name, scopes, key_prefix, key_hash, active, tenant_id) VALUES($1, $2, $3, $4, $5, $6)
+			RETURNING `+partnerColumns,
+		partner.Name, strings.Join(partner.Scopes, ","), partner.KeyPrefix, keyHash, partner.Active, partner.TenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) GetPartners(ctx context.Context, tenantID string) ([]models.Partner, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT `+partnerColumns+` FROM partners
+			WHERE tenant_id = $1
+				ORDER BY id
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partners []models.Partner
+	for rows.Next() {
+		partner, err := scanPartner(rows)
+		if err != nil {
+			return nil, err
+		}
+		partners = append(partners, *partner)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return partners, nil
+}
+
+func (s *Store) GetPartner(ctx context.Context, tenantID string, id int64) (*models.Partner, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		SELECT `+partnerColumns+` FROM partners
+			WHERE id = $1 AND tenant_id = $2
+	`, id, tenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) GetPartnerByKeyHash(ctx context.Context, keyHash string) (*models.Partner, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		SELECT `+partnerColumns+` FROM partners
+			WHERE key_hash = $1 AND active
+	`, keyHash)
+
+	return scanPartner(row)
+}
+
+func (s *Store) UpdatePartner(ctx context.Context, partner models.Partner) (*models.Partner, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		UPDATE partners SET name = $2, scopes = $3, active = $4
+			WHERE id = $1 AND tenant_id = $5
+				RETURNING `+partnerColumns,
+		partner.ID, partner.Name, strings.Join(partner.Scopes, ","), partner.Active, partner.TenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) SetPartnerKey(ctx context.Context, tenantID string, id int64, keyPrefix, keyHash string) (*models.Partner, error) {
+	// прежний ключ перестает действовать сразу
+	row := s.Conn.QueryRowContext(ctx, `
+		UPDATE partners SET key_prefix = $2, key_hash = $3
+			WHERE id = $1 AND tenant_id = $4
+				RETURNING `+partnerColumns,
+		id, keyPrefix, keyHash, tenantID)
+
+	return scanPartner(row)
+}
+
+func (s *Store) GetPartnerOrder(ctx context.Context, partnerID int64, number string) (*models.Order, error) {
+	var accrual sql.NullInt64
+	var bonus models.Money
+	var status, createdAt string
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT accrual, bonus, status, created_at FROM orders
+			WHERE number = $2 AND partner_id = $1
+	`, partnerID, number).Scan(&accrual, &bonus, &status, &createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	money := models.Money(accrual.Int64)
+	return &models.Order{
+		Number:    number,
+		Accrual:   models.Money(money.Get()),
+		Bonus:     models.Money(bonus.Get()),
+		PartnerID: partnerID,
+		Status:    models.OrderState(status),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (s *Store) GetPartnerWithdrawal(ctx context.Context, partnerID int64, order string) (*models.Withdrawal, error) {
+	// списание относится к партнеру, если оплачен зарегистрированный им заказ
+	var sum, reversed models.Money
+	var createdAt string
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT w.sum, w.reversed, w.created_at
+			FROM withdrawals w
+				JOIN orders o ON o.number = w."order" AND o.tenant_id = w.tenant_id
+					WHERE w."order" = $2 AND o.partner_id = $1
+	`, partnerID, order).Scan(&sum, &reversed, &createdAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	return &models.Withdrawal{
+		Order:     order,
+		Sum:       models.Money(sum.Get()),
+		Reversed:  models.Money(reversed.Get()),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (s *Store) GetPartnerReport(ctx context.Context, partnerID int64, from, to time.Time) (*models.PartnerReport, error) {
+	report := models.PartnerReport{
+		PartnerID: partnerID,
+		From:      formatTime(from),
+		To:        formatTime(to),
+	}
+	var accrued, redeemed, reversed models.Money
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT
+			COUNT(CASE WHEN created_at >= $2 AND created_at < $3 THEN 1 END),
+			COUNT(CASE WHEN created_at >= $2 AND created_at < $3 AND status = $4 THEN 1 END),
+			COALESCE(SUM(CASE WHEN credited_at >= $2 AND credited_at < $3 THEN COALESCE(accrual, 0) + bonus END), 0)
+				FROM orders
+					WHERE partner_id = $1
+	`, partnerID, report.From, report.To, models.OrderStateProcessed).Scan(&report.Orders, &report.Processed, &accrued)
+	if err != nil {
+		return nil, err
+	}
+	err = s.Conn.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(w.sum), 0), COALESCE(SUM(w.reversed), 0)
+			FROM withdrawals w
+				JOIN orders o ON o.number = w."order" AND o.tenant_id = w.tenant_id
+					WHERE o.partner_id = $1 AND w.created_at >= $2 AND w.created_at < $3
+	`, partnerID, report.From, report.To).Scan(&report.Redemptions, &redeemed, &reversed)
+	if err != nil {
+		return nil, err
+	}
+	report.Accrued = models.Money(accrued.Get())
+	report.Redeemed = models.Money(redeemed.Get())
+	report.Reversed = models.Money(reversed.Get())
+
+	return &report, nil
+}
+
+func scanPartner(row scanner) (*models.Partner, error) {
+	var partner models.Partner
+	var scopes string
+	err := row.Scan(&partner.ID, &partner.Name, &scopes, &partner.KeyPrefix, &partner.Active, &partner.CreatedAt, &partner.TenantID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	partner.Scopes = []string{}
+	if scopes != "" {
+		partner.Scopes = strings.Split(scopes, ",")
+	}
+
+	return &partner, nil
+}
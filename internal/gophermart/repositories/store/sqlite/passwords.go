@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"time"
+)
+
+const userColumns = `id, tenant_id, login, email, token_version, created_at`
+
+func (s *Store) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+	row := s.Conn.QueryRowContext(ctx, `
+		SELECT `+userColumns+` FROM users WHERE id = $1
+	`, userID)
+
+	return scanUser(row)
+}
+
+func (s *Store) SetEmail(ctx context.Context, userID int64, email string) error {
+	res, err := s.Conn.ExecContext(ctx, `
+		UPDATE users SET email = NULLIF($2, '') WHERE id = $1
+	`, userID, email)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) ChangePassword(ctx context.Context, userID int64, oldPassword, newPassword string) (*models.User, error) {
+	// новая версия отзывает все выданные раньше токены
+	row := s.Conn.QueryRowContext(ctx, `
+		UPDATE users SET password = $3, token_version = token_version + 1
+			WHERE id = $1 AND password = $2
+				RETURNING `+userColumns,
+		userID, oldPassword, newPassword)
+
+	return scanUser(row)
+}
+
+func (s *Store) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// действует только последний запрошенный токен
+	_, err = tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES($1, $2, $3)
+	`, tokenHash, userID, formatTime(expiresAt))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) ResetPassword(ctx context.Context, tokenHash, password string) (*models.User, error) {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE password_resets SET used_at = `+nowUTC+`
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+				RETURNING user_id
+	`, tokenHash, formatTime(time.Now())).Scan(&userID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	user, err := scanUser(tx.QueryRowContext(ctx, `
+		UPDATE users SET password = $2, token_version = token_version + 1
+			WHERE id = $1
+				RETURNING `+userColumns,
+		userID, password))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	var user models.User
+	var email sql.NullString
+	err := row.Scan(&user.ID, &user.TenantID, &user.Login, &email, &user.TokenVersion, &user.CreatedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	user.Email = email.String
+
+	return &user, nil
+}
@@ -0,0 +1,182 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) GetUserIDByInviteCode(ctx context.Context, tenantID, code string) (int64, error) {
+	var id int64
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT id FROM users WHERE invite_code = $1 AND tenant_id = $2
+	`, code, tenantID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, api.ErrNotFound
+	}
+
+	return id, err
+}
+
+func (s *Store) GetInviteCode(ctx context.Context, userID int64) (string, error) {
+	var code sql.NullString
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT invite_code FROM users WHERE id = $1
+	`, userID).Scan(&code)
+
+	return code.String, err
+}
+
+func (s *Store) CountReferrals(ctx context.Context, referrerID int64, since time.Time) (int64, error) {
+	var count int64
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM referrals
+			WHERE referrer_id = $1 AND created_at >= $2
+	`, referrerID, formatTime(since)).Scan(&count)
+
+	return count, err
+}
+
+func (s *Store) CreateReferral(ctx context.Context, referral models.Referral) error {
+	_, err := s.Conn.ExecContext(ctx, `
+		INSERT INTO referrals (referee_id, referrer_id, status, reason) VALUES($1, $2, $3, NULLIF($4, ''))
+			ON CONFLICT (referee_id) DO NOTHING
+	`, referral.RefereeID, referral.ReferrerID, referral.Status, referral.Reason)
+
+	return err
+}
+
+func (s *Store) GetReferrals(ctx context.Context, referrerID int64) ([]models.Referral, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT r.referee_id, u.login, r.status, r.reason, r.referrer_reward, r.created_at, r.rewarded_at
+			FROM referrals r
+				JOIN users u ON u.id = r.referee_id
+					WHERE r.referrer_id = $1
+						ORDER BY r.created_at DESC, r.referee_id DESC
+	`, referrerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var referrals []models.Referral
+	for rows.Next() {
+		var refereeID int64
+		var login, status, createdAt string
+		var reason, rewardedAt sql.NullString
+		var reward models.Money
+		err = rows.Scan(&refereeID, &login, &status, &reason, &reward, &createdAt, &rewardedAt)
+		if err != nil {
+			return nil, err
+		}
+		referrals = append(referrals, models.Referral{
+			ReferrerID: referrerID,
+			RefereeID:  refereeID,
+			Login:      login,
+			Status:     models.ReferralStatus(status),
+			Reason:     reason.String,
+			Reward:     models.Money(reward.Get()),
+			CreatedAt:  createdAt,
+			RewardedAt: rewardedAt.String,
+		})
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return referrals, nil
+}
+
+func (s *Store) RewardReferral(ctx context.Context, userID int64, order string, policy models.ReferralPolicy) (*models.Referral, error) {
+	// BEGIN IMMEDIATE (см. DSN) не дает параллельным наградам обойти лимит
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	referral := models.Referral{RefereeID: userID, Order: order}
+	err = tx.QueryRowContext(ctx, `
+		SELECT referrer_id FROM referrals
+			WHERE referee_id = $1 AND status = $2
+	`, userID, models.ReferralStatusPending).Scan(&referral.ReferrerID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if policy.MaxRewards > 0 {
+		var rewarded int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM referrals
+				WHERE referrer_id = $1 AND status = $2
+		`, referral.ReferrerID, models.ReferralStatusRewarded).Scan(&rewarded)
+		if err != nil {
+			return nil, err
+		}
+		if rewarded >= policy.MaxRewards {
+			referral.Status = models.ReferralStatusRejected
+			referral.Reason = "referrer reward limit reached"
+			_, err = tx.ExecContext(ctx, `
+				UPDATE referrals SET status = $2, reason = $3, "order" = $4
+					WHERE referee_id = $1
+			`, userID, referral.Status, referral.Reason, order)
+			if err != nil {
+				return nil, err
+			}
+			return &referral, tx.Commit()
+		}
+	}
+
+	rewards := []struct {
+		userID, otherID int64
+		amount          models.Money
+		reason          string
+	}{
+		{referral.ReferrerID, userID, policy.ReferrerReward, "referrer"},
+		{userID, referral.ReferrerID, policy.RefereeReward, "referee"},
+	}
+	for _, reward := range rewards {
+		if reward.amount <= 0 {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO balance (user_id, current, withdrawn) VALUES($1, $2, 0)
+				ON CONFLICT (user_id) DO
+					UPDATE SET current = balance.current + $2
+		`, reward.userID, reward.amount)
+		if err != nil {
+			return nil, err
+		}
+		if err := creditLot(ctx, tx, reward.userID, models.LotSourceReferral, order, reward.amount, sql.NullString{}); err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO ledger (user_id, operation, amount, "order", reason, ref) VALUES($1, $2, $3, $4, $5, $6)
+		`, reward.userID, models.LedgerOperationReferralBonus, reward.amount, order, reward.reason, strconv.FormatInt(reward.otherID, 10))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	referral.Status = models.ReferralStatusRewarded
+	referral.Reward = models.Money(policy.ReferrerReward.Get())
+	_, err = tx.ExecContext(ctx, `
+		UPDATE referrals
+			SET status = $2, referrer_reward = $3, referee_reward = $4, "order" = $5, rewarded_at = `+nowUTC+`
+				WHERE referee_id = $1
+	`, userID, referral.Status, policy.ReferrerReward, policy.RefereeReward, order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &referral, tx.Commit()
+}
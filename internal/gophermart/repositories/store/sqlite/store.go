@@ -0,0 +1,590 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/migrate"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strings"
+)
+
+type Store struct {
+	Conn *sql.DB
+}
+
+func (s *Store) Initialize(ctx context.Context, app config.AppConfig) error {
+	if err := s.Open(ctx, app); err != nil {
+		return err
+	}
+
+	// миграции применяются при старте, ошибка любой из них прерывает запуск
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Log.Infoln("Applied migrations:", applied)
+
+	return nil
+}
+
+func (s *Store) Open(ctx context.Context, app config.AppConfig) error {
+	var err error
+	if s.Conn, err = OpenDB(app.StoreDatabaseURI); err != nil {
+		return err
+	}
+
+	if len(app.StoreReplicaURIs) > 0 {
+		logger.Log.Warnln("SQLite does not support read replicas, DATABASE_REPLICA_URIS is ignored")
+	}
+
+	// настройки пула общие с pg
+	if app.StoreMaxConns > 0 {
+		s.Conn.SetMaxOpenConns(int(app.StoreMaxConns))
+	}
+	if app.StoreMinConns > 0 {
+		s.Conn.SetMaxIdleConns(int(app.StoreMinConns))
+	}
+	s.Conn.SetConnMaxLifetime(app.StoreMaxConnLifetime)
+	s.Conn.SetConnMaxIdleTime(app.StoreMaxConnIdleTime)
+
+	return nil
+}
+
+func (s *Store) Migrator() (*migrate.Migrator, error) {
+	return NewMigrator(s.Conn)
+}
+
+func (s *Store) Close() error {
+	return s.Conn.Close()
+}
+
+func (s *Store) Ping(ctx context.Context) error {
+	return s.Conn.PingContext(ctx)
+}
+
+func (s *Store) Stats() store.Stats {
+	stat := s.Conn.Stats()
+	return store.Stats{
+		MaxConns:                int64(stat.MaxOpenConnections),
+		TotalConns:              int64(stat.OpenConnections),
+		IdleConns:               int64(stat.Idle),
+		AcquiredConns:           int64(stat.InUse),
+		EmptyAcquireCount:       stat.WaitCount,
+		AcquireDuration:         stat.WaitDuration,
+		MaxLifetimeDestroyCount: stat.MaxLifetimeClosed,
+		MaxIdleDestroyCount:     stat.MaxIdleClosed + stat.MaxIdleTimeClosed,
+	}
+}
+
+func (s *Store) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	var id int64
+	var login, password, createdAt string
+	err := s.Conn.QueryRowContext(ctx, `
+		INSERT INTO users (login, password, created_at, invite_code, tenant_id, email)
+			VALUES($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''))
+				ON CONFLICT (tenant_id, login) DO NOTHING
+					RETURNING id, login, password, created_at
+	`, user.Login, user.Password, user.CreatedAt, user.InviteCode, user.TenantID, user.Email).Scan(&id, &login, &password, &createdAt)
+	var sqliteErr *sqlite.Error
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrDuplicate
+	case errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE &&
+		strings.Contains(sqliteErr.Error(), "users.invite_code"):
+		// занятый логин не дает ошибки (ON CONFLICT), это совпал случайный код приглашения
+		return nil, api.ErrInviteCodeTaken
+	case err != nil:
+		return nil, err
+	default:
+		user.ID = id
+		user.Password = password
+		user.CreatedAt = createdAt
+		return &user, nil
+	}
+}
+
+func (s *Store) GetIDUserByAuth(ctx context.Context, user models.User) (int64, error) {
+	var res int64
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT id FROM users
+			WHERE login = $1 AND password = $2 AND tenant_id = $3
+	`, user.Login, user.Password, user.TenantID).Scan(&res)
+	switch {
+	case err == sql.ErrNoRows:
+		return 0, api.ErrNotFound
+	case err != nil:
+		return 0, err
+	}
+
+	return res, nil
+}
+
+func (s *Store) GetUserIDByLogin(ctx context.Context, tenantID, login string) (int64, error) {
+	var id int64
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT id FROM users WHERE login = $1 AND tenant_id = $2 AND deleted_at IS NULL
+	`, login, tenantID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, api.ErrNotFound
+	}
+
+	return id, err
+}
+
+func (s *Store) CreateOrder(ctx context.Context, order models.Order) (string, int64, error) {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer tx.Rollback()
+
+	// SQLite не умеет INSERT внутри CTE, поэтому повторяем логику pg.CreateOrder в транзакции
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO orders (number, user_id, status, created_at, partner_id, tenant_id) VALUES($1, $2, $3, $4, NULLIF($5, 0), $6)
+			ON CONFLICT (tenant_id, number) DO NOTHING
+	`, order.Number, order.UserID, order.Status, order.CreatedAt, order.PartnerID, order.TenantID)
+	if err != nil {
+		return "", 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return "", 0, err
+	}
+	if affected == 1 { // first
+		return order.Number, order.UserID, tx.Commit()
+	}
+
+	var userDB int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id FROM orders
+			WHERE number = $1 AND tenant_id = $2
+	`, order.Number, order.TenantID).Scan(&userDB)
+	if err != nil {
+		return "", 0, err
+	}
+	if userDB == order.UserID { // owner duplicate
+		return "", 0, api.ErrDuplicate
+	}
+
+	// duplicate another
+	return order.Number, userDB, nil
+}
+
+func (s *Store) GetOrders(ctx context.Context, userID int64) ([]models.Order, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT number, accrual, bonus, status, created_at
+			FROM orders
+				WHERE user_id = $1
+				ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orders []models.Order
+	for rows.Next() {
+		var accrual sql.NullInt64
+		var bonus models.Money
+		var number, status, createdAt string
+		err = rows.Scan(&number, &accrual, &bonus, &status, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		money := models.Money(accrual.Int64)
+		orders = append(orders, models.Order{
+			Number:    number,
+			Accrual:   models.Money(money.Get()),
+			Bonus:     models.Money(bonus.Get()),
+			Status:    models.OrderState(status),
+			CreatedAt: createdAt,
+		})
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+func (s *Store) GetBalance(ctx context.Context, userID int64) (*models.Balance, error) {
+	var userDB int64
+	var current, withdrawn, reserved models.Money
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT user_id, current, withdrawn, reserved FROM balance
+			WHERE user_id = $1
+	`, userID).Scan(&userDB, &current, &withdrawn, &reserved)
+	balance := models.Balance{
+		UserID:    userDB,
+		Current:   models.Money(current.Get()),
+		Withdrawn: models.Money(withdrawn.Get()),
+		Reserved:  models.Money(reserved.Get()),
+	}
+	switch {
+	case err == sql.ErrNoRows:
+		return &balance, nil
+	case err != nil:
+		return nil, err
+	default:
+		return &balance, nil
+	}
+}
+
+func (s *Store) SetBalance(ctx context.Context, balance models.Balance, userID int64) error {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO balance (user_id, current, withdrawn) VALUES($1, $2, $3)
+			ON CONFLICT (user_id) DO
+				UPDATE SET current = balance.current + $2
+	`, userID, balance.Current, balance.Withdrawn)
+	if err != nil {
+		return err
+	}
+
+	if err := creditLot(ctx, tx, userID, models.LotSourceAccrual, "", balance.Current, sql.NullString{}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) UpdateOrder(ctx context.Context, order models.Order) error {
+	_, err := s.Conn.ExecContext(ctx, `
+		UPDATE orders SET accrual = $1, status = $2
+			WHERE number = $3 AND user_id = $4
+	`, order.Accrual, order.Status, order.Number, order.UserID)
+
+	return err
+}
+
+func (s *Store) UpdateBalanceAndOrder(ctx context.Context, order models.Order) (models.Money, error) {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO balance (user_id, current, withdrawn) VALUES($1, $2, $3)
+			ON CONFLICT (user_id) DO
+				UPDATE SET current = balance.current + $2
+	`, order.UserID, order.Accrual+order.Bonus, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := creditLot(ctx, tx, order.UserID, models.LotSourceAccrual, order.Number, order.Accrual+order.Bonus, sql.NullString{}); err != nil {
+		return 0, err
+	}
+
+	credited := order.Accrual + order.Bonus
+	if order.Accrual > 0 && order.Status == models.OrderStateProcessed {
+		awarded, err := awardCampaigns(ctx, tx, order)
+		if err != nil {
+			return 0, err
+		}
+		credited += awarded
+	}
+
+	// credited_at отмечает зачисление на баланс: по нему считаются уровни лояльности
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+			SET accrual = $1, status = $2, bonus = $4, tier = NULLIF($5, ''),
+				credited_at = CASE WHEN $1 > 0 THEN `+nowUTC+` ELSE credited_at END
+					WHERE number = $3 AND user_id = $6
+	`, order.Accrual, order.Status, order.Number, order.Bonus, order.Tier, order.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	if order.Bonus > 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO ledger (user_id, operation, amount, "order", reason) VALUES($1, $2, $3, $4, $5)
+		`, order.UserID, models.LedgerOperationTierBonus, order.Bonus, order.Number, order.Tier)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return models.Money(credited.Get()), nil
+}
+
+func (s *Store) SetWithdrawal(ctx context.Context, withdrawal models.Withdrawal) error {
+	// транзакция открывается как BEGIN IMMEDIATE (см. DSN), поэтому конкурентные
+	// списания выстраиваются в очередь и проверка остатка не может устареть
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := setWithdrawal(ctx, tx, withdrawal, fromCurrent); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) WithdrawalDone(ctx context.Context, withdrawal models.Withdrawal) (bool, error) {
+	var done bool
+	err := s.Conn.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM withdrawals
+				WHERE "order" = $1 AND tenant_id = $2 AND user_id = $3 AND sum = $4
+		)
+	`, withdrawal.Order, withdrawal.TenantID, withdrawal.UserID, withdrawal.Sum).Scan(&done)
+
+	return done, err
+}
+
+// withdrawalSource - столбец баланса, из которого списываются баллы
+type withdrawalSource string
+
+const (
+	fromCurrent  withdrawalSource = "current"  // доступные баллы, партии расходуются при списании
+	fromReserved withdrawalSource = "reserved" // резерв: партии израсходованы при его создании
+)
+
+// setWithdrawal списывает баллы из source и записывает списание в рамках транзакции tx
+func setWithdrawal(ctx context.Context, tx *sql.Tx, withdrawal models.Withdrawal, source withdrawalSource) error {
+	if err := insertWithdrawal(ctx, tx, withdrawal); err != nil {
+		return err
+	}
+
+	// списание и запись о нем фиксируются одной транзакцией
+	res, err := tx.ExecContext(ctx, `
+		UPDATE balance
+			SET `+string(source)+` = `+string(source)+` - $1, withdrawn = withdrawn + $1
+				WHERE user_id = $2 AND `+string(source)+` - $1 >= 0
+	`, withdrawal.Sum, withdrawal.UserID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return api.ErrNotEnoughMoney
+	}
+
+	var earnedAt sql.NullString
+	switch source {
+	case fromCurrent:
+		earnedAt, err = consumeLots(ctx, tx, withdrawal.UserID, withdrawal.Sum)
+	case fromReserved:
+		err = tx.QueryRowContext(ctx, `
+			SELECT earned_at FROM holds
+				WHERE "order" = $1 AND user_id = $2 AND tenant_id = $3
+		`, withdrawal.Order, withdrawal.UserID, withdrawal.TenantID).Scan(&earnedAt)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE withdrawals SET earned_at = $1
+			WHERE "order" = $2 AND user_id = $3 AND tenant_id = $4
+	`, earnedAt, withdrawal.Order, withdrawal.UserID, withdrawal.TenantID)
+
+	return err
+}
+
+// insertWithdrawal записывает списание без изменения баланса. Повтор по ключу
+// идемпотентности или номеру заказа возвращает api.ErrDuplicate
+func insertWithdrawal(ctx context.Context, tx *sql.Tx, withdrawal models.Withdrawal) error {
+	if withdrawal.IdempotencyKey != "" {
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO idempotency_keys (user_id, key, "order", sum) VALUES($1, $2, $3, $4)
+				ON CONFLICT (user_id, key) DO NOTHING
+		`, withdrawal.UserID, withdrawal.IdempotencyKey, withdrawal.Order, withdrawal.Sum)
+		if err != nil {
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			var same bool
+			err = tx.QueryRowContext(ctx, `
+				SELECT "order" = $3 AND sum = $4 FROM idempotency_keys
+					WHERE user_id = $1 AND key = $2
+			`, withdrawal.UserID, withdrawal.IdempotencyKey, withdrawal.Order, withdrawal.Sum).Scan(&same)
+			if err != nil {
+				return err
+			}
+			if !same {
+				return api.ErrIdempotencyMismatch
+			}
+			// повтор уже выполненного запроса
+			return api.ErrDuplicate
+		}
+	}
+
+	// номер заказа сам по себе ключ идемпотентности: списание по нему возможно только одно
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO withdrawals ("order", user_id, sum, tenant_id) VALUES($1, $2, $3, $4)
+			ON CONFLICT (tenant_id, "order") DO NOTHING
+	`, withdrawal.Order, withdrawal.UserID, withdrawal.Sum, withdrawal.TenantID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		var same bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT user_id = $2 AND sum = $3 FROM withdrawals
+				WHERE "order" = $1 AND tenant_id = $4
+		`, withdrawal.Order, withdrawal.UserID, withdrawal.Sum, withdrawal.TenantID).Scan(&same)
+		if err != nil {
+			return err
+		}
+		if !same {
+			return api.ErrIdempotencyMismatch
+		}
+		// повтор уже выполненного запроса
+		return api.ErrDuplicate
+	}
+
+	return nil
+}
+
+func (s *Store) ReverseWithdrawal(ctx context.Context, reversal models.Reversal) (*models.Withdrawal, error) {
+	// BEGIN IMMEDIATE (см. DSN) не дает параллельным возвратам превысить сумму списания
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var userID int64
+	var sum, reversed models.Money
+	var createdAt string
+	var earnedAt sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, sum, reversed, created_at, earned_at FROM withdrawals
+			WHERE "order" = $1 AND tenant_id = $2
+	`, reversal.Order, reversal.TenantID).Scan(&userID, &sum, &reversed, &createdAt, &earnedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	// без суммы возвращаем весь остаток
+	if reversal.Sum == 0 {
+		reversal.Sum = sum - reversed
+	}
+	if reversal.Sum <= 0 || reversed+reversal.Sum > sum {
+		return nil, api.ErrReversalExceeded
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE withdrawals SET reversed = reversed + $1
+			WHERE "order" = $2 AND tenant_id = $3
+	`, reversal.Sum, reversal.Order, reversal.TenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE balance
+			SET current = current + $1, withdrawn = withdrawn - $1
+				WHERE user_id = $2
+	`, reversal.Sum, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// возвращенные баллы сгорают не позже, чем сгорели бы списанные
+	if err := creditLot(ctx, tx, userID, models.LotSourceReversal, reversal.Order, reversal.Sum, earnedAt); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger (user_id, operation, amount, "order", reason, ref) VALUES($1, $2, $3, $4, $5, $6)
+	`, userID, models.LedgerOperationReversal, reversal.Sum, reversal.Order, reversal.Reason, reversal.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	reversed += reversal.Sum
+	return &models.Withdrawal{
+		Order:     reversal.Order,
+		UserID:    userID,
+		Sum:       models.Money(sum.Get()),
+		Reversed:  models.Money(reversed.Get()),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+func (s *Store) GetWithdrawals(ctx context.Context, userID int64) ([]models.Withdrawal, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT "order", sum, reversed, created_at
+			FROM withdrawals
+				WHERE user_id = $1
+				ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var withdrawals []models.Withdrawal
+	for rows.Next() {
+		var order, createdAt string
+		var sum, reversed models.Money
+		err = rows.Scan(&order, &sum, &reversed, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, models.Withdrawal{
+			Order:     order,
+			Sum:       models.Money(sum.Get()),
+			Reversed:  models.Money(reversed.Get()),
+			CreatedAt: createdAt,
+		})
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return withdrawals, nil
+}
@@ -0,0 +1,190 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/sqlite"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testTenant = "default"
+
+// newTestStore открывает хранилище в отдельном файле с примененными миграциями
+func newTestStore(t *testing.T) *sqlite.Store {
+	t.Helper()
+
+	if err := logger.Initialize("error", logger.FormatJSON, false); err != nil {
+		t.Fatal(err)
+	}
+	s := &sqlite.Store{}
+	app := config.AppConfig{StoreDatabaseURI: filepath.Join(t.TempDir(), "test.db")}
+	if err := s.Initialize(context.Background(), app); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// newTestUser создает пользователя с балансом current в копейках
+func newTestUser(t *testing.T, s *sqlite.Store, login string, current models.Money) int64 {
+	t.Helper()
+
+	ctx := context.Background()
+	user, err := s.CreateUser(ctx, models.User{
+		Login:      login,
+		Password:   "password",
+		CreatedAt:  "2024-01-01T00:00:00Z",
+		InviteCode: login,
+		TenantID:   testTenant,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current > 0 {
+		_, err = s.UpdateBalanceAndOrder(ctx, models.Order{
+			UserID:   user.ID,
+			TenantID: testTenant,
+			Number:   "order-" + login,
+			Accrual:  current,
+			Status:   models.OrderStateProcessed,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return user.ID
+}
+
+// checkBalance сравнивает баланс пользователя с ожидаемым в баллах
+func checkBalance(t *testing.T, s *sqlite.Store, userID int64, current, withdrawn models.Money) {
+	t.Helper()
+
+	balance, err := s.GetBalance(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != current || balance.Withdrawn != withdrawn {
+		t.Errorf("balance = %v/%v, want %v/%v", balance.Current, balance.Withdrawn, current, withdrawn)
+	}
+}
+
+func TestSetWithdrawalConcurrent(t *testing.T) {
+	s := newTestStore(t)
+	userID := newTestUser(t, s, "alice", 1000)
+
+	// 20 списаний по 100 копеек с баланса в 1000: пройти должны ровно 10
+	const attempts = 20
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.SetWithdrawal(context.Background(), models.Withdrawal{
+				Order:    fmt.Sprintf("w-%d", i),
+				UserID:   userID,
+				TenantID: testTenant,
+				Sum:      100,
+			})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	var done, rejected int
+	for err := range errs {
+		switch {
+		case err == nil:
+			done++
+		case errors.Is(err, api.ErrNotEnoughMoney):
+			rejected++
+		default:
+			t.Errorf("SetWithdrawal() error = %v", err)
+		}
+	}
+	if done != 10 || rejected != 10 {
+		t.Errorf("done = %d, rejected = %d, want 10 and 10", done, rejected)
+	}
+	checkBalance(t, s, userID, 0, 10)
+}
+
+func TestSetWithdrawalIdempotency(t *testing.T) {
+	s := newTestStore(t)
+	userID := newTestUser(t, s, "bob", 1000)
+	ctx := context.Background()
+	withdrawal := models.Withdrawal{
+		Order:          "w-1",
+		UserID:         userID,
+		TenantID:       testTenant,
+		Sum:            300,
+		IdempotencyKey: "key-1",
+	}
+
+	// параллельные повторы одного запроса списывают баллы один раз
+	const attempts = 10
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- s.SetWithdrawal(ctx, withdrawal)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var done, replayed int
+	for err := range errs {
+		switch {
+		case err == nil:
+			done++
+		case errors.Is(err, api.ErrDuplicate):
+			replayed++
+		default:
+			t.Errorf("SetWithdrawal() error = %v", err)
+		}
+	}
+	if done != 1 || replayed != attempts-1 {
+		t.Errorf("done = %d, replayed = %d, want 1 and %d", done, replayed, attempts-1)
+	}
+	checkBalance(t, s, userID, 7, 3)
+
+	tests := []struct {
+		name       string
+		withdrawal models.Withdrawal
+	}{
+		{
+			name:       "same key, other order",
+			withdrawal: models.Withdrawal{Order: "w-2", Sum: 300, IdempotencyKey: "key-1"},
+		},
+		{
+			name:       "same key, other sum",
+			withdrawal: models.Withdrawal{Order: "w-1", Sum: 200, IdempotencyKey: "key-1"},
+		},
+		{
+			name:       "same order, other key and sum",
+			withdrawal: models.Withdrawal{Order: "w-1", Sum: 200, IdempotencyKey: "key-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.withdrawal.UserID = userID
+			tt.withdrawal.TenantID = testTenant
+			err := s.SetWithdrawal(ctx, tt.withdrawal)
+			if !errors.Is(err, api.ErrIdempotencyMismatch) {
+				t.Errorf("SetWithdrawal() error = %v, want %v", err, api.ErrIdempotencyMismatch)
+			}
+		})
+	}
+	checkBalance(t, s, userID, 7, 3)
+}
@@ -0,0 +1,173 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+)
+
+func (s *Store) CreateTOTP(ctx context.Context, totp models.TOTP, recoveryHashes []string) (*models.TOTP, error) {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// неподтвержденный секрет заменяется новым вместе с кодами восстановления,
+	// включенный второй фактор сначала нужно отключить
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO totp (user_id, secret) VALUES($1, $2)
+			ON CONFLICT (user_id) DO
+				UPDATE SET secret = $2, last_step = 0, created_at = `+nowUTC+`
+					WHERE totp.confirmed_at IS NULL
+	`, totp.UserID, totp.Secret)
+	if err != nil {
+		return nil, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted == 0 {
+		return nil, api.ErrDuplicate
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, totp.UserID, recoveryHashes); err != nil {
+		return nil, err
+	}
+	created, err := getTOTP(ctx, tx, totp.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return created, tx.Commit()
+}
+
+func (s *Store) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	return getTOTP(ctx, s.Conn, userID)
+}
+
+func (s *Store) ConfirmTOTP(ctx context.Context, userID int64, step int64) error {
+	res, err := s.Conn.ExecContext(ctx, `
+		UPDATE totp SET confirmed_at = `+nowUTC+`, last_step = $2
+			WHERE user_id = $1 AND confirmed_at IS NULL
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+	confirmed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if confirmed == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	// шаг принимается один раз: перехваченный код нельзя предъявить повторно
+	res, err := s.Conn.ExecContext(ctx, `
+		UPDATE totp SET last_step = $2
+			WHERE user_id = $1 AND last_step < $2
+	`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return used > 0, nil
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	res, err := s.Conn.ExecContext(ctx, `
+		UPDATE recovery_codes SET used_at = `+nowUTC+`
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`, userID, codeHash)
+	if err != nil {
+		return err
+	}
+	used, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if used == 0 {
+		return api.ErrNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) DeleteTOTP(ctx context.Context, userID int64) error {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return api.ErrNotFound
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userID, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes заменяет коды восстановления пользователя новыми
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, hashes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO recovery_codes (user_id, code_hash) VALUES($1, $2)
+		`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryRower - соединение или транзакция, из которых читается одна строка
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getTOTP(ctx context.Context, q queryRower, userID int64) (*models.TOTP, error) {
+	totp := models.TOTP{UserID: userID}
+	err := q.QueryRowContext(ctx, `
+		SELECT u.tenant_id, u.login, t.secret, t.confirmed_at IS NOT NULL, t.last_step,
+			(SELECT COUNT(*) FROM recovery_codes c WHERE c.user_id = t.user_id AND c.used_at IS NULL)
+				FROM totp t
+					JOIN users u ON u.id = t.user_id
+						WHERE t.user_id = $1
+	`, userID).Scan(&totp.TenantID, &totp.Login, &totp.Secret, &totp.Confirmed, &totp.LastStep, &totp.RecoveryCodesLeft)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	return &totp, nil
+}
@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) Transfer(ctx context.Context, transfer models.Transfer, policy models.TransferPolicy) (*models.Transfer, error) {
+	// BEGIN IMMEDIATE (см. DSN) блокирует базу целиком, порядок блокировок
+	// балансов здесь не важен
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		SELECT id FROM users WHERE login = $1 AND tenant_id = $2 AND deleted_at IS NULL
+	`, transfer.To, transfer.TenantID).Scan(&transfer.ToUserID)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if transfer.ToUserID == transfer.FromUserID {
+		return nil, api.ErrSelfTransfer
+	}
+
+	if policy.DailyLimit > 0 {
+		var sent models.Money
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM("sum"), 0) FROM transfers
+				WHERE from_user_id = $1 AND created_at >= $2
+		`, transfer.FromUserID, formatTime(time.Now().AddDate(0, 0, -1))).Scan(&sent)
+		if err != nil {
+			return nil, err
+		}
+		if sent+transfer.Sum > policy.DailyLimit {
+			return nil, api.ErrTransferLimit
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE balance SET current = current - $1
+			WHERE user_id = $2 AND current - $1 >= 0
+	`, transfer.Sum, transfer.FromUserID)
+	if err != nil {
+		return nil, err
+	}
+	debited, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if debited == 0 {
+		return nil, api.ErrNotEnoughMoney
+	}
+
+	// получатель наследует дату самой старой из списанных партий,
+	// иначе перевод продлевал бы срок жизни баллов
+	earnedAt, err := consumeLots(ctx, tx, transfer.FromUserID, transfer.Sum)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO balance (user_id, current, withdrawn) VALUES($1, $2, 0)
+			ON CONFLICT (user_id) DO
+				UPDATE SET current = balance.current + $2
+	`, transfer.ToUserID, transfer.Sum)
+	if err != nil {
+		return nil, err
+	}
+	if err := creditLot(ctx, tx, transfer.ToUserID, models.LotSourceTransfer, "", transfer.Sum, earnedAt); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO transfers (from_user_id, to_user_id, "sum", comment) VALUES($1, $2, $3, NULLIF($4, ''))
+			RETURNING id, created_at
+	`, transfer.FromUserID, transfer.ToUserID, transfer.Sum, transfer.Comment).Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := strconv.FormatInt(transfer.ID, 10)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger (user_id, operation, amount, reason, ref)
+			VALUES($1, $2, $5, NULLIF($6, ''), $7), ($3, $4, $5, NULLIF($6, ''), $7)
+	`, transfer.FromUserID, models.LedgerOperationTransferOut, transfer.ToUserID, models.LedgerOperationTransferIn,
+		transfer.Sum, transfer.Comment, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	transfer.Sum = models.Money(transfer.Sum.Get())
+	transfer.Direction = models.TransferDirectionOut
+
+	return &transfer, tx.Commit()
+}
+
+func (s *Store) GetTransfers(ctx context.Context, userID int64) ([]models.Transfer, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT t.id, t.from_user_id, f.login, t.to_user_id, r.login, t."sum", t.comment, t.created_at
+			FROM transfers t
+				JOIN users f ON f.id = t.from_user_id
+				JOIN users r ON r.id = t.to_user_id
+					WHERE t.from_user_id = $1 OR t.to_user_id = $1
+						ORDER BY t.created_at DESC, t.id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []models.Transfer
+	for rows.Next() {
+		var t models.Transfer
+		var sum models.Money
+		var comment sql.NullString
+		err = rows.Scan(&t.ID, &t.FromUserID, &t.From, &t.ToUserID, &t.To, &sum, &comment, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		t.Sum = models.Money(sum.Get())
+		t.Comment = comment.String
+		t.Direction = models.TransferDirectionIn
+		if t.FromUserID == userID {
+			t.Direction = models.TransferDirectionOut
+		}
+		transfers = append(transfers, t)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
@@ -0,0 +1,43 @@
+package sqlite_test
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransferOpposite(t *testing.T) {
+	s := newTestStore(t)
+	aliceID := newTestUser(t, s, "alice", 1000)
+	bobID := newTestUser(t, s, "bob", 1000)
+
+	// встречные переводы не должны блокировать друг друга: при взаимной
+	// блокировке запросы упадут по таймауту
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	const rounds = 10
+	transfers := []models.Transfer{
+		{TenantID: testTenant, FromUserID: aliceID, To: "bob", Sum: 50},
+		{TenantID: testTenant, FromUserID: bobID, To: "alice", Sum: 50},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < rounds; i++ {
+		for _, transfer := range transfers {
+			wg.Add(1)
+			go func(transfer models.Transfer) {
+				defer wg.Done()
+				if _, err := s.Transfer(ctx, transfer, models.TransferPolicy{}); err != nil {
+					t.Errorf("Transfer() error = %v", err)
+				}
+			}(transfer)
+		}
+	}
+	wg.Wait()
+
+	// каждый отправил и получил одинаковую сумму
+	checkBalance(t, s, aliceID, 10, 0)
+	checkBalance(t, s, bobID, 10, 0)
+}
@@ -0,0 +1,152 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"strconv"
+	"time"
+)
+
+func (s *Store) CreateVoucherBatch(ctx context.Context, batch models.VoucherBatch, hashes []string) (*models.VoucherBatch, error) {
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO voucher_batches (name, value, count, expires_at, tenant_id) VALUES($1, $2, $3, $4, $5)
+			RETURNING id, created_at
+	`, batch.Name, batch.Value, len(hashes), batch.ExpiresAt, batch.TenantID).Scan(&batch.ID, &batch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO vouchers (batch_id, code_hash) VALUES($1, $2)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, hash := range hashes {
+		if _, err := stmt.ExecContext(ctx, batch.ID, hash); err != nil {
+			return nil, err
+		}
+	}
+
+	batch.Value = models.Money(batch.Value.Get())
+	batch.Count = len(hashes)
+
+	return &batch, tx.Commit()
+}
+
+func (s *Store) GetVoucherBatches(ctx context.Context, tenantID string) ([]models.VoucherBatch, error) {
+	rows, err := s.Conn.QueryContext(ctx, `
+		SELECT b.id, b.name, b.value, b.count, b.expires_at, b.created_at,
+			COUNT(v.redeemed_at),
+			COALESCE(SUM(CASE WHEN v.redeemed_at IS NULL AND b.expires_at <= `+nowUTC+` THEN 1 END), 0)
+				FROM voucher_batches b
+					LEFT JOIN vouchers v ON v.batch_id = b.id
+						WHERE b.tenant_id = $1
+						GROUP BY b.id
+							ORDER BY b.created_at DESC, b.id DESC
+	`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []models.VoucherBatch
+	for rows.Next() {
+		var b models.VoucherBatch
+		var value models.Money
+		err = rows.Scan(&b.ID, &b.Name, &value, &b.Count, &b.ExpiresAt, &b.CreatedAt, &b.Redeemed, &b.Expired)
+		if err != nil {
+			return nil, err
+		}
+		b.Value = models.Money(value.Get())
+		b.RedeemedValue = b.Value * models.Money(b.Redeemed)
+		batches = append(batches, b)
+	}
+
+	// необходимо проверить ошибки уровня курсора
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return batches, nil
+}
+
+func (s *Store) RedeemVoucher(ctx context.Context, tenantID string, userID int64, hash string) (*models.Voucher, error) {
+	// BEGIN IMMEDIATE (см. DSN) не дает погасить код дважды параллельными запросами
+	tx, err := s.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var voucher models.Voucher
+	var value models.Money
+	var expiresAt string
+	var redeemedBy sql.NullInt64
+	var redeemedAt sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT v.id, v.batch_id, b.value, b.expires_at, v.redeemed_by, v.redeemed_at
+			FROM vouchers v
+				JOIN voucher_batches b ON b.id = v.batch_id
+					WHERE v.code_hash = $1 AND b.tenant_id = $2
+	`, hash, tenantID).Scan(&voucher.ID, &voucher.BatchID, &value, &expiresAt, &redeemedBy, &redeemedAt)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, api.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	voucher.Value = models.Money(value.Get())
+
+	if redeemedAt.Valid {
+		if redeemedBy.Int64 != userID {
+			return nil, api.ErrVoucherRedeemed
+		}
+		// повтор погашения тем же пользователем
+		voucher.RedeemedAt = redeemedAt.String
+		return &voucher, api.ErrDuplicate
+	}
+	if expiresAt <= formatTime(time.Now()) {
+		return nil, api.ErrVoucherExpired
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE vouchers SET redeemed_by = $2, redeemed_at = `+nowUTC+`
+			WHERE id = $1
+				RETURNING redeemed_at
+	`, voucher.ID, userID).Scan(&voucher.RedeemedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO balance (user_id, current, withdrawn) VALUES($1, $2, 0)
+			ON CONFLICT (user_id) DO
+				UPDATE SET current = balance.current + $2
+	`, userID, value)
+	if err != nil {
+		return nil, err
+	}
+	if err := creditLot(ctx, tx, userID, models.LotSourceVoucher, "", value, sql.NullString{}); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO ledger (user_id, operation, amount, ref) VALUES($1, $2, $3, $4)
+	`, userID, models.LedgerOperationVoucher, value, strconv.FormatInt(voucher.ID, 10))
+	if err != nil {
+		return nil, err
+	}
+
+	return &voucher, tx.Commit()
+}
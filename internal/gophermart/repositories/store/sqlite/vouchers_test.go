@@ -0,0 +1,66 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedeemVoucherConcurrent(t *testing.T) {
+	s := newTestStore(t)
+	aliceID := newTestUser(t, s, "alice", 0)
+	bobID := newTestUser(t, s, "bob", 0)
+	ctx := context.Background()
+
+	_, err := s.CreateVoucherBatch(ctx, models.VoucherBatch{
+		TenantID:  testTenant,
+		Name:      "gift",
+		Value:     500,
+		Count:     1,
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	}, []string{"hash-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// два пользователя одновременно гасят один код: погасить его может только один
+	users := []int64{aliceID, bobID}
+	errs := make([]error, len(users))
+	var wg sync.WaitGroup
+	for i, userID := range users {
+		wg.Add(1)
+		go func(i int, userID int64) {
+			defer wg.Done()
+			_, errs[i] = s.RedeemVoucher(ctx, testTenant, userID, "hash-1")
+		}(i, userID)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			if winner >= 0 {
+				t.Fatal("voucher redeemed twice")
+			}
+			winner = i
+		case !errors.Is(err, api.ErrVoucherRedeemed):
+			t.Errorf("RedeemVoucher() error = %v, want %v", err, api.ErrVoucherRedeemed)
+		}
+	}
+	if winner < 0 {
+		t.Fatal("voucher was not redeemed")
+	}
+	checkBalance(t, s, users[winner], 5, 0)
+	checkBalance(t, s, users[1-winner], 0, 0)
+
+	// повтор победителем возвращает исходное погашение и не начисляет баллы
+	if _, err := s.RedeemVoucher(ctx, testTenant, users[winner], "hash-1"); !errors.Is(err, api.ErrDuplicate) {
+		t.Errorf("RedeemVoucher() replay error = %v, want %v", err, api.ErrDuplicate)
+	}
+	checkBalance(t, s, users[winner], 5, 0)
+}
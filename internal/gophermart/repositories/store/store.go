@@ -4,12 +4,52 @@ import (
 	"context"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/config"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store/migrate"
+	"time"
 )
 
+// Stats - статистика пула соединений хранилища для мониторинга
+type Stats struct {
+	MaxConns                int64         `json:"max_conns"`
+	TotalConns              int64         `json:"total_conns"`
+	IdleConns               int64         `json:"idle_conns"`
+	AcquiredConns           int64         `json:"acquired_conns"`
+	AcquireCount            int64         `json:"acquire_count"`
+	EmptyAcquireCount       int64         `json:"empty_acquire_count"` // сколько раз пришлось ждать свободное соединение
+	CanceledAcquireCount    int64         `json:"canceled_acquire_count"`
+	AcquireDuration         time.Duration `json:"acquire_duration"`
+	NewConnsCount           int64         `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64         `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64         `json:"max_idle_destroy_count"`
+}
+
 type Repositories interface {
+	// Initialize открывает хранилище и применяет миграции
 	Initialize(ctx context.Context, app config.AppConfig) error
+	// Open открывает хранилище без применения миграций
+	Open(ctx context.Context, app config.AppConfig) error
+	Migrator() (*migrate.Migrator, error)
+	Close() error
+	Stats() Stats
+	// Ping проверяет соединение с основным сервером хранилища
+	Ping(ctx context.Context) error
+
+	// пользователи, заказы, списания и резервы принадлежат программе лояльности
+	// (TenantID моделей): логины и номера заказов уникальны в пределах программы
 	CreateUser(ctx context.Context, user models.User) (*models.User, error)
 	GetIDUserByAuth(ctx context.Context, user models.User) (int64, error)
+	GetUserIDByLogin(ctx context.Context, tenantID, login string) (int64, error)
+	// GetUser возвращает пользователя без пароля, с текущей версией токенов
+	GetUser(ctx context.Context, userID int64) (*models.User, error)
+	SetEmail(ctx context.Context, userID int64, email string) error
+	// ChangePassword и ResetPassword меняют пароль и увеличивают версию токенов;
+	// неверный старый пароль или недействительный токен сброса - ErrNotFound
+	ChangePassword(ctx context.Context, userID int64, oldPassword, newPassword string) (*models.User, error)
+	CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	ResetPassword(ctx context.Context, tokenHash, password string) (*models.User, error)
+	// DeleteUser обезличивает пользователя, удаляет его учетные данные и отзывает токены;
+	// заказы, списания и история баланса остаются для бухгалтерского учета
+	DeleteUser(ctx context.Context, userID int64) error
 
 	CreateOrder(ctx context.Context, order models.Order) (number string, userID int64, err error)
 	GetOrders(ctx context.Context, userID int64) ([]models.Order, error)
@@ -18,8 +58,97 @@ type Repositories interface {
 	GetBalance(ctx context.Context, userID int64) (*models.Balance, error)
 	SetBalance(ctx context.Context, balance models.Balance, userID int64) error
 
-	UpdateBalanceAndOrder(ctx context.Context, order models.Order) error
+	// UpdateBalanceAndOrder возвращает все зачисленные по заказу баллы, включая бонусы акций
+	UpdateBalanceAndOrder(ctx context.Context, order models.Order) (models.Money, error)
+	// GetLedger возвращает операции с балансом помимо начислений за заказы и списаний
+	GetLedger(ctx context.Context, userID int64) ([]models.LedgerEntry, error)
 
 	GetWithdrawals(ctx context.Context, userID int64) ([]models.Withdrawal, error)
 	SetWithdrawal(ctx context.Context, withdrawal models.Withdrawal) error
+	// WithdrawalDone сообщает, что такое же списание (заказ, пользователь, сумма) уже выполнено
+	// и SetWithdrawal вернет для него ErrDuplicate
+	WithdrawalDone(ctx context.Context, withdrawal models.Withdrawal) (bool, error)
+	ReverseWithdrawal(ctx context.Context, reversal models.Reversal) (*models.Withdrawal, error)
+
+	AuthorizeHold(ctx context.Context, hold models.Hold) (*models.Hold, error)
+	// CaptureHold сообщает, списан ли резерв этим вызовом, а не был списан раньше
+	CaptureHold(ctx context.Context, userID int64, order string) (*models.Hold, bool, error)
+	VoidHold(ctx context.Context, userID int64, order string) (*models.Hold, error)
+	ExpireHolds(ctx context.Context) (int64, error)
+
+	// партии баллов: earnedBefore - граница, до которой начисленные баллы сгорают
+	GetExpiringLots(ctx context.Context, userID int64, earnedBefore time.Time) ([]models.Lot, error)
+	GetExpiringBalances(ctx context.Context, tenantID string, earnedBefore time.Time) ([]models.Balance, error)
+	ExpireLots(ctx context.Context, earnedBefore time.Time) (int64, error)
+
+	// уровни лояльности: сумма начислений или списаний с момента since
+	GetQualifyingTotal(ctx context.Context, userID int64, basis models.TierBasis, since time.Time) (models.Money, error)
+	GetQualifyingTotals(ctx context.Context, basis models.TierBasis, since time.Time) ([]models.UserTier, error)
+	GetUserTier(ctx context.Context, userID int64) (*models.UserTier, error)
+	SetUserTier(ctx context.Context, tier models.UserTier) error
+
+	CreateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error)
+	GetCampaigns(ctx context.Context, tenantID string) ([]models.Campaign, error)
+	GetCampaign(ctx context.Context, tenantID string, id int64) (*models.Campaign, error)
+	UpdateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error)
+	DeleteCampaign(ctx context.Context, tenantID string, id int64) error
+
+	GetUserIDByInviteCode(ctx context.Context, tenantID, code string) (int64, error)
+	GetInviteCode(ctx context.Context, userID int64) (string, error)
+	CountReferrals(ctx context.Context, referrerID int64, since time.Time) (int64, error)
+	CreateReferral(ctx context.Context, referral models.Referral) error
+	GetReferrals(ctx context.Context, referrerID int64) ([]models.Referral, error)
+	// RewardReferral начисляет бонусы по ожидающему приглашению пользователя userID;
+	// без такого приглашения возвращает nil
+	RewardReferral(ctx context.Context, userID int64, order string, policy models.ReferralPolicy) (*models.Referral, error)
+
+	Transfer(ctx context.Context, transfer models.Transfer, policy models.TransferPolicy) (*models.Transfer, error)
+	GetTransfers(ctx context.Context, userID int64) ([]models.Transfer, error)
+
+	CreateVoucherBatch(ctx context.Context, batch models.VoucherBatch, hashes []string) (*models.VoucherBatch, error)
+	GetVoucherBatches(ctx context.Context, tenantID string) ([]models.VoucherBatch, error)
+	RedeemVoucher(ctx context.Context, tenantID string, userID int64, hash string) (*models.Voucher, error)
+
+	CreatePartner(ctx context.Context, partner models.Partner, keyHash string) (*models.Partner, error)
+	GetPartners(ctx context.Context, tenantID string) ([]models.Partner, error)
+	GetPartner(ctx context.Context, tenantID string, id int64) (*models.Partner, error)
+	GetPartnerByKeyHash(ctx context.Context, keyHash string) (*models.Partner, error)
+	UpdatePartner(ctx context.Context, partner models.Partner) (*models.Partner, error)
+	SetPartnerKey(ctx context.Context, tenantID string, id int64, keyPrefix, keyHash string) (*models.Partner, error)
+	GetPartnerOrder(ctx context.Context, partnerID int64, number string) (*models.Order, error)
+	GetPartnerWithdrawal(ctx context.Context, partnerID int64, order string) (*models.Withdrawal, error)
+	GetPartnerReport(ctx context.Context, partnerID int64, from, to time.Time) (*models.PartnerReport, error)
+
+	// неудачные попытки входа по логину и по IP программы лояльности tenantID
+	GetLoginAttempts(ctx context.Context, tenantID, login, ip string) ([]models.LoginAttempts, error)
+	// RecordLoginFailure учитывает неудачу по логину и по IP и пишет в журнал аудита блокировки,
+	// к которым она привела
+	RecordLoginFailure(ctx context.Context, tenantID, login, ip string, policy models.LoginPolicy) error
+	// ResetLoginAttempts забывает неудачи и сообщает, была ли блокировка действующей
+	ResetLoginAttempts(ctx context.Context, tenantID string, scope models.LoginScope, value string) (locked bool, err error)
+	GetLoginLockouts(ctx context.Context, tenantID string) ([]models.LoginAttempts, error)
+	DeleteStaleLoginAttempts(ctx context.Context, failedBefore time.Time) (int64, error)
+
+	CreateAuditEvent(ctx context.Context, event models.AuditEvent) error
+	GetAuditEvents(ctx context.Context, tenantID, event string, limit int) ([]models.AuditEvent, error)
+
+	// второй фактор: CreateTOTP заменяет неподтвержденный секрет и коды восстановления,
+	// для включенного второго фактора возвращает ErrDuplicate
+	CreateTOTP(ctx context.Context, totp models.TOTP, recoveryHashes []string) (*models.TOTP, error)
+	GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error)
+	ConfirmTOTP(ctx context.Context, userID int64, step int64) error
+	// UseTOTPStep принимает шаг времени, если он позже последнего принятого
+	UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+	DeleteTOTP(ctx context.Context, userID int64) error
+}
+
+// RateLimits - корзины ограничения частоты запросов, общие для всех реплик приложения.
+// Реализуется только хранилищами, которые используют несколько реплик (PostgreSQL)
+type RateLimits interface {
+	// TakeRateToken забирает токен из корзины key; если токенов нет, возвращает,
+	// через сколько появится следующий
+	TakeRateToken(ctx context.Context, key string, limit models.RateLimit) (retryAfter time.Duration, err error)
+	// DeleteIdleRateBuckets удаляет полные корзины: их состояние совпадает с отсутствием записи
+	DeleteIdleRateBuckets(ctx context.Context) (int64, error)
 }
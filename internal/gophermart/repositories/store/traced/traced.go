@@ -0,0 +1,621 @@
+// Package traced оборачивает хранилище спанами трассировки: по одному на каждый вызов
+// метода, с драйвером и именем метода, чтобы видеть, сколько запрос провел в базе
+package traced
+
+import (
+	"context"
+	"errors"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/repositories/store"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// Store - хранилище со спанами. Служебные методы (Initialize, Open, Migrator, Close,
+// Stats, Ping) вызываются напрямую: они не относятся к запросам и не трассируются
+type Store struct {
+	store.Repositories
+
+	system attribute.KeyValue
+}
+
+// New оборачивает хранилище db драйвера driver
+func New(db store.Repositories, driver string) *Store {
+	system := semconv.DBSystemKey.String(driver)
+	switch driver {
+	case "postgresql", "postgres":
+		system = semconv.DBSystemPostgreSQL
+	case "sqlite", "sqlite3":
+		system = semconv.DBSystemSqlite
+	}
+
+	return &Store{Repositories: db, system: system}
+}
+
+func (s *Store) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "store."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(s.system, semconv.DBOperation(method)),
+	)
+}
+
+// outcomes - штатные ответы хранилища: они отмечаются на спане, но ошибкой не считаются
+var outcomes = []error{
+	api.ErrNotFound,
+	api.ErrDuplicate,
+	api.ErrNotEnoughMoney,
+	api.ErrIdempotencyMismatch,
+	api.ErrReversalExceeded,
+	api.ErrHoldClosed,
+	api.ErrSelfTransfer,
+	api.ErrTransferLimit,
+	api.ErrVoucherRedeemed,
+	api.ErrVoucherExpired,
+}
+
+func (s *Store) end(span trace.Span, err error) {
+	for _, outcome := range outcomes {
+		if errors.Is(err, outcome) {
+			span.SetAttributes(attribute.String("store.outcome", outcome.Error()))
+			err = nil
+			break
+		}
+	}
+	tracing.End(span, err)
+}
+
+func (s *Store) CreateUser(ctx context.Context, user models.User) (*models.User, error) {
+	ctx, span := s.start(ctx, "CreateUser")
+	res, err := s.Repositories.CreateUser(ctx, user)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetIDUserByAuth(ctx context.Context, user models.User) (int64, error) {
+	ctx, span := s.start(ctx, "GetIDUserByAuth")
+	res, err := s.Repositories.GetIDUserByAuth(ctx, user)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetUserIDByLogin(ctx context.Context, tenantID, login string) (int64, error) {
+	ctx, span := s.start(ctx, "GetUserIDByLogin")
+	res, err := s.Repositories.GetUserIDByLogin(ctx, tenantID, login)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetUser(ctx context.Context, userID int64) (*models.User, error) {
+	ctx, span := s.start(ctx, "GetUser")
+	res, err := s.Repositories.GetUser(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) SetEmail(ctx context.Context, userID int64, email string) error {
+	ctx, span := s.start(ctx, "SetEmail")
+	err := s.Repositories.SetEmail(ctx, userID, email)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) ChangePassword(ctx context.Context, userID int64, oldPassword, newPassword string) (*models.User, error) {
+	ctx, span := s.start(ctx, "ChangePassword")
+	res, err := s.Repositories.ChangePassword(ctx, userID, oldPassword, newPassword)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	ctx, span := s.start(ctx, "CreatePasswordReset")
+	err := s.Repositories.CreatePasswordReset(ctx, userID, tokenHash, expiresAt)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) ResetPassword(ctx context.Context, tokenHash, password string) (*models.User, error) {
+	ctx, span := s.start(ctx, "ResetPassword")
+	res, err := s.Repositories.ResetPassword(ctx, tokenHash, password)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) DeleteUser(ctx context.Context, userID int64) error {
+	ctx, span := s.start(ctx, "DeleteUser")
+	err := s.Repositories.DeleteUser(ctx, userID)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) CreateOrder(ctx context.Context, order models.Order) (string, int64, error) {
+	ctx, span := s.start(ctx, "CreateOrder")
+	number, userID, err := s.Repositories.CreateOrder(ctx, order)
+	s.end(span, err)
+
+	return number, userID, err
+}
+
+func (s *Store) GetOrders(ctx context.Context, userID int64) ([]models.Order, error) {
+	ctx, span := s.start(ctx, "GetOrders")
+	res, err := s.Repositories.GetOrders(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) UpdateOrder(ctx context.Context, order models.Order) error {
+	ctx, span := s.start(ctx, "UpdateOrder")
+	err := s.Repositories.UpdateOrder(ctx, order)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) GetBalance(ctx context.Context, userID int64) (*models.Balance, error) {
+	ctx, span := s.start(ctx, "GetBalance")
+	res, err := s.Repositories.GetBalance(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) SetBalance(ctx context.Context, balance models.Balance, userID int64) error {
+	ctx, span := s.start(ctx, "SetBalance")
+	err := s.Repositories.SetBalance(ctx, balance, userID)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) UpdateBalanceAndOrder(ctx context.Context, order models.Order) (models.Money, error) {
+	ctx, span := s.start(ctx, "UpdateBalanceAndOrder")
+	res, err := s.Repositories.UpdateBalanceAndOrder(ctx, order)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetLedger(ctx context.Context, userID int64) ([]models.LedgerEntry, error) {
+	ctx, span := s.start(ctx, "GetLedger")
+	res, err := s.Repositories.GetLedger(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetWithdrawals(ctx context.Context, userID int64) ([]models.Withdrawal, error) {
+	ctx, span := s.start(ctx, "GetWithdrawals")
+	res, err := s.Repositories.GetWithdrawals(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) SetWithdrawal(ctx context.Context, withdrawal models.Withdrawal) error {
+	ctx, span := s.start(ctx, "SetWithdrawal")
+	err := s.Repositories.SetWithdrawal(ctx, withdrawal)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) WithdrawalDone(ctx context.Context, withdrawal models.Withdrawal) (bool, error) {
+	ctx, span := s.start(ctx, "WithdrawalDone")
+	res, err := s.Repositories.WithdrawalDone(ctx, withdrawal)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) ReverseWithdrawal(ctx context.Context, reversal models.Reversal) (*models.Withdrawal, error) {
+	ctx, span := s.start(ctx, "ReverseWithdrawal")
+	res, err := s.Repositories.ReverseWithdrawal(ctx, reversal)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) AuthorizeHold(ctx context.Context, hold models.Hold) (*models.Hold, error) {
+	ctx, span := s.start(ctx, "AuthorizeHold")
+	res, err := s.Repositories.AuthorizeHold(ctx, hold)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CaptureHold(ctx context.Context, userID int64, order string) (*models.Hold, bool, error) {
+	ctx, span := s.start(ctx, "CaptureHold")
+	res, captured, err := s.Repositories.CaptureHold(ctx, userID, order)
+	s.end(span, err)
+
+	return res, captured, err
+}
+
+func (s *Store) VoidHold(ctx context.Context, userID int64, order string) (*models.Hold, error) {
+	ctx, span := s.start(ctx, "VoidHold")
+	res, err := s.Repositories.VoidHold(ctx, userID, order)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) ExpireHolds(ctx context.Context) (int64, error) {
+	ctx, span := s.start(ctx, "ExpireHolds")
+	res, err := s.Repositories.ExpireHolds(ctx)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetExpiringLots(ctx context.Context, userID int64, earnedBefore time.Time) ([]models.Lot, error) {
+	ctx, span := s.start(ctx, "GetExpiringLots")
+	res, err := s.Repositories.GetExpiringLots(ctx, userID, earnedBefore)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetExpiringBalances(ctx context.Context, tenantID string, earnedBefore time.Time) ([]models.Balance, error) {
+	ctx, span := s.start(ctx, "GetExpiringBalances")
+	res, err := s.Repositories.GetExpiringBalances(ctx, tenantID, earnedBefore)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) ExpireLots(ctx context.Context, earnedBefore time.Time) (int64, error) {
+	ctx, span := s.start(ctx, "ExpireLots")
+	res, err := s.Repositories.ExpireLots(ctx, earnedBefore)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetQualifyingTotal(ctx context.Context, userID int64, basis models.TierBasis, since time.Time) (models.Money, error) {
+	ctx, span := s.start(ctx, "GetQualifyingTotal")
+	res, err := s.Repositories.GetQualifyingTotal(ctx, userID, basis, since)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetQualifyingTotals(ctx context.Context, basis models.TierBasis, since time.Time) ([]models.UserTier, error) {
+	ctx, span := s.start(ctx, "GetQualifyingTotals")
+	res, err := s.Repositories.GetQualifyingTotals(ctx, basis, since)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetUserTier(ctx context.Context, userID int64) (*models.UserTier, error) {
+	ctx, span := s.start(ctx, "GetUserTier")
+	res, err := s.Repositories.GetUserTier(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) SetUserTier(ctx context.Context, tier models.UserTier) error {
+	ctx, span := s.start(ctx, "SetUserTier")
+	err := s.Repositories.SetUserTier(ctx, tier)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) CreateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	ctx, span := s.start(ctx, "CreateCampaign")
+	res, err := s.Repositories.CreateCampaign(ctx, campaign)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetCampaigns(ctx context.Context, tenantID string) ([]models.Campaign, error) {
+	ctx, span := s.start(ctx, "GetCampaigns")
+	res, err := s.Repositories.GetCampaigns(ctx, tenantID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetCampaign(ctx context.Context, tenantID string, id int64) (*models.Campaign, error) {
+	ctx, span := s.start(ctx, "GetCampaign")
+	res, err := s.Repositories.GetCampaign(ctx, tenantID, id)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) UpdateCampaign(ctx context.Context, campaign models.Campaign) (*models.Campaign, error) {
+	ctx, span := s.start(ctx, "UpdateCampaign")
+	res, err := s.Repositories.UpdateCampaign(ctx, campaign)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) DeleteCampaign(ctx context.Context, tenantID string, id int64) error {
+	ctx, span := s.start(ctx, "DeleteCampaign")
+	err := s.Repositories.DeleteCampaign(ctx, tenantID, id)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) GetUserIDByInviteCode(ctx context.Context, tenantID, code string) (int64, error) {
+	ctx, span := s.start(ctx, "GetUserIDByInviteCode")
+	res, err := s.Repositories.GetUserIDByInviteCode(ctx, tenantID, code)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetInviteCode(ctx context.Context, userID int64) (string, error) {
+	ctx, span := s.start(ctx, "GetInviteCode")
+	res, err := s.Repositories.GetInviteCode(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CountReferrals(ctx context.Context, referrerID int64, since time.Time) (int64, error) {
+	ctx, span := s.start(ctx, "CountReferrals")
+	res, err := s.Repositories.CountReferrals(ctx, referrerID, since)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CreateReferral(ctx context.Context, referral models.Referral) error {
+	ctx, span := s.start(ctx, "CreateReferral")
+	err := s.Repositories.CreateReferral(ctx, referral)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) GetReferrals(ctx context.Context, referrerID int64) ([]models.Referral, error) {
+	ctx, span := s.start(ctx, "GetReferrals")
+	res, err := s.Repositories.GetReferrals(ctx, referrerID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) RewardReferral(ctx context.Context, userID int64, order string, policy models.ReferralPolicy) (*models.Referral, error) {
+	ctx, span := s.start(ctx, "RewardReferral")
+	res, err := s.Repositories.RewardReferral(ctx, userID, order, policy)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) Transfer(ctx context.Context, transfer models.Transfer, policy models.TransferPolicy) (*models.Transfer, error) {
+	ctx, span := s.start(ctx, "Transfer")
+	res, err := s.Repositories.Transfer(ctx, transfer, policy)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetTransfers(ctx context.Context, userID int64) ([]models.Transfer, error) {
+	ctx, span := s.start(ctx, "GetTransfers")
+	res, err := s.Repositories.GetTransfers(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CreateVoucherBatch(ctx context.Context, batch models.VoucherBatch, hashes []string) (*models.VoucherBatch, error) {
+	ctx, span := s.start(ctx, "CreateVoucherBatch")
+	res, err := s.Repositories.CreateVoucherBatch(ctx, batch, hashes)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetVoucherBatches(ctx context.Context, tenantID string) ([]models.VoucherBatch, error) {
+	ctx, span := s.start(ctx, "GetVoucherBatches")
+	res, err := s.Repositories.GetVoucherBatches(ctx, tenantID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) RedeemVoucher(ctx context.Context, tenantID string, userID int64, hash string) (*models.Voucher, error) {
+	ctx, span := s.start(ctx, "RedeemVoucher")
+	res, err := s.Repositories.RedeemVoucher(ctx, tenantID, userID, hash)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CreatePartner(ctx context.Context, partner models.Partner, keyHash string) (*models.Partner, error) {
+	ctx, span := s.start(ctx, "CreatePartner")
+	res, err := s.Repositories.CreatePartner(ctx, partner, keyHash)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetPartners(ctx context.Context, tenantID string) ([]models.Partner, error) {
+	ctx, span := s.start(ctx, "GetPartners")
+	res, err := s.Repositories.GetPartners(ctx, tenantID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetPartner(ctx context.Context, tenantID string, id int64) (*models.Partner, error) {
+	ctx, span := s.start(ctx, "GetPartner")
+	res, err := s.Repositories.GetPartner(ctx, tenantID, id)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetPartnerByKeyHash(ctx context.Context, keyHash string) (*models.Partner, error) {
+	ctx, span := s.start(ctx, "GetPartnerByKeyHash")
+	res, err := s.Repositories.GetPartnerByKeyHash(ctx, keyHash)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) UpdatePartner(ctx context.Context, partner models.Partner) (*models.Partner, error) {
+	ctx, span := s.start(ctx, "UpdatePartner")
+	res, err := s.Repositories.UpdatePartner(ctx, partner)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) SetPartnerKey(ctx context.Context, tenantID string, id int64, keyPrefix, keyHash string) (*models.Partner, error) {
+	ctx, span := s.start(ctx, "SetPartnerKey")
+	res, err := s.Repositories.SetPartnerKey(ctx, tenantID, id, keyPrefix, keyHash)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetPartnerOrder(ctx context.Context, partnerID int64, number string) (*models.Order, error) {
+	ctx, span := s.start(ctx, "GetPartnerOrder")
+	res, err := s.Repositories.GetPartnerOrder(ctx, partnerID, number)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetPartnerWithdrawal(ctx context.Context, partnerID int64, order string) (*models.Withdrawal, error) {
+	ctx, span := s.start(ctx, "GetPartnerWithdrawal")
+	res, err := s.Repositories.GetPartnerWithdrawal(ctx, partnerID, order)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetPartnerReport(ctx context.Context, partnerID int64, from, to time.Time) (*models.PartnerReport, error) {
+	ctx, span := s.start(ctx, "GetPartnerReport")
+	res, err := s.Repositories.GetPartnerReport(ctx, partnerID, from, to)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetLoginAttempts(ctx context.Context, tenantID, login, ip string) ([]models.LoginAttempts, error) {
+	ctx, span := s.start(ctx, "GetLoginAttempts")
+	res, err := s.Repositories.GetLoginAttempts(ctx, tenantID, login, ip)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) RecordLoginFailure(ctx context.Context, tenantID, login, ip string, policy models.LoginPolicy) error {
+	ctx, span := s.start(ctx, "RecordLoginFailure")
+	err := s.Repositories.RecordLoginFailure(ctx, tenantID, login, ip, policy)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) ResetLoginAttempts(ctx context.Context, tenantID string, scope models.LoginScope, value string) (bool, error) {
+	ctx, span := s.start(ctx, "ResetLoginAttempts")
+	locked, err := s.Repositories.ResetLoginAttempts(ctx, tenantID, scope, value)
+	s.end(span, err)
+
+	return locked, err
+}
+
+func (s *Store) GetLoginLockouts(ctx context.Context, tenantID string) ([]models.LoginAttempts, error) {
+	ctx, span := s.start(ctx, "GetLoginLockouts")
+	res, err := s.Repositories.GetLoginLockouts(ctx, tenantID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) DeleteStaleLoginAttempts(ctx context.Context, failedBefore time.Time) (int64, error) {
+	ctx, span := s.start(ctx, "DeleteStaleLoginAttempts")
+	res, err := s.Repositories.DeleteStaleLoginAttempts(ctx, failedBefore)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CreateAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	ctx, span := s.start(ctx, "CreateAuditEvent")
+	err := s.Repositories.CreateAuditEvent(ctx, event)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) GetAuditEvents(ctx context.Context, tenantID, event string, limit int) ([]models.AuditEvent, error) {
+	ctx, span := s.start(ctx, "GetAuditEvents")
+	res, err := s.Repositories.GetAuditEvents(ctx, tenantID, event, limit)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) CreateTOTP(ctx context.Context, totp models.TOTP, recoveryHashes []string) (*models.TOTP, error) {
+	ctx, span := s.start(ctx, "CreateTOTP")
+	res, err := s.Repositories.CreateTOTP(ctx, totp, recoveryHashes)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	ctx, span := s.start(ctx, "GetTOTP")
+	res, err := s.Repositories.GetTOTP(ctx, userID)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) ConfirmTOTP(ctx context.Context, userID int64, step int64) error {
+	ctx, span := s.start(ctx, "ConfirmTOTP")
+	err := s.Repositories.ConfirmTOTP(ctx, userID, step)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	ctx, span := s.start(ctx, "UseTOTPStep")
+	res, err := s.Repositories.UseTOTPStep(ctx, userID, step)
+	s.end(span, err)
+
+	return res, err
+}
+
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	ctx, span := s.start(ctx, "UseRecoveryCode")
+	err := s.Repositories.UseRecoveryCode(ctx, userID, codeHash)
+	s.end(span, err)
+
+	return err
+}
+
+func (s *Store) DeleteTOTP(ctx context.Context, userID int64) error {
+	ctx, span := s.start(ctx, "DeleteTOTP")
+	err := s.Repositories.DeleteTOTP(ctx, userID)
+	s.end(span, err)
+
+	return err
+}
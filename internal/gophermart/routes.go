@@ -3,32 +3,121 @@ package gophermart
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/metrics"
 	"github.com/webkimru/go-shop-loyalty/internal/gophermart/middleware"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/models"
 	"net/http"
 )
 
 func Routes() http.Handler {
 	r := chi.NewRouter()
 
+	r.Use(middleware.WithTracing)
+	r.Use(middleware.WithRequestID)
 	r.Use(middleware.WithLogging)
+	r.Use(middleware.WithMetrics)
 	r.Use(middleware.Gzip)
+	r.Use(middleware.ResolveTenant)
+	r.Use(middleware.RateLimit(models.RateLimitRouteDefault))
 
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.CheckApplicationJSON)
 
-		r.Post("/api/user/register", api.Repo.Register)
-		r.Post("/api/user/login", api.Repo.Login)
+		r.With(middleware.RateLimit(models.RateLimitRouteRegister)).Post("/api/user/register", api.Repo.Register)
+		r.With(middleware.RateLimit(models.RateLimitRouteLogin)).Post("/api/user/login", api.Repo.Login)
+		r.With(middleware.RateLimit(models.RateLimitRouteLogin)).Post("/api/user/login/2fa", api.Repo.LoginSecondFactor)
+		r.With(middleware.RateLimit(models.RateLimitRouteLogin)).Post("/api/user/password/reset", api.Repo.RequestPasswordReset)
+		r.With(middleware.RateLimit(models.RateLimitRouteLogin)).Post("/api/user/password/reset/confirm", api.Repo.ResetPassword)
 	})
 
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.CheckAuth)
 
-		r.Post("/api/user/orders", api.Repo.CreateOrder)
+		r.With(middleware.RateLimit(models.RateLimitRouteOrders)).Post("/api/user/orders", api.Repo.CreateOrder)
 		r.Get("/api/user/orders", api.Repo.GetOrders)
 		r.Get("/api/user/balance", api.Repo.GetBalance)
-		r.With(middleware.CheckApplicationJSON).Post("/api/user/balance/withdraw", api.Repo.PostWithdrawal)
+		r.Get("/api/user/balance/expiring", api.Repo.GetExpiringPoints)
+		r.Get("/api/user/tier", api.Repo.GetTier)
+		r.Get("/api/user/referrals", api.Repo.GetReferrals)
+		r.With(middleware.RateLimit(models.RateLimitRouteWithdraw), middleware.CheckApplicationJSON).
+			Post("/api/user/balance/withdraw", api.Repo.PostWithdrawal)
 		r.Get("/api/user/withdrawals", api.Repo.GetWithdrawals)
+		r.With(middleware.CheckApplicationJSON).Post("/api/user/balance/transfer", api.Repo.PostTransfer)
+		r.Get("/api/user/transfers", api.Repo.GetTransfers)
+		r.With(middleware.CheckApplicationJSON).Post("/api/user/vouchers/redeem", api.Repo.RedeemVoucher)
+
+		r.With(middleware.CheckApplicationJSON).Post("/api/user/balance/holds", api.Repo.AuthorizeHold)
+		r.Post("/api/user/balance/holds/{order}/capture", api.Repo.CaptureHold)
+		r.Post("/api/user/balance/holds/{order}/void", api.Repo.VoidHold)
+
+		r.Get("/api/user/2fa", api.Repo.GetTOTPStatus)
+		r.Post("/api/user/2fa", api.Repo.EnrollTOTP)
+		r.With(middleware.CheckApplicationJSON).Post("/api/user/2fa/confirm", api.Repo.ConfirmTOTP)
+		r.Delete("/api/user/2fa", api.Repo.DisableTOTP)
+
+		r.With(middleware.CheckApplicationJSON).Post("/api/user/password", api.Repo.ChangePassword)
+		r.With(middleware.CheckApplicationJSON).Put("/api/user/email", api.Repo.SetEmail)
+
+		r.Get("/api/user/export", api.Repo.ExportUser)
+		r.With(middleware.CheckApplicationJSON).Delete("/api/user", api.Repo.DeleteUser)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.CheckAdmin)
+
+		r.With(middleware.CheckApplicationJSON).Post("/api/admin/withdrawals/{order}/reversal", api.Repo.AdminReverseWithdrawal)
+		r.Get("/api/admin/points/expiring", api.Repo.AdminGetExpiringPoints)
+
+		r.With(middleware.CheckApplicationJSON).Post("/api/admin/campaigns", api.Repo.CreateCampaign)
+		r.Get("/api/admin/campaigns", api.Repo.GetCampaigns)
+		r.Get("/api/admin/campaigns/{id}", api.Repo.GetCampaign)
+		r.With(middleware.CheckApplicationJSON).Put("/api/admin/campaigns/{id}", api.Repo.UpdateCampaign)
+		r.Delete("/api/admin/campaigns/{id}", api.Repo.DeleteCampaign)
+
+		r.With(middleware.CheckApplicationJSON).Post("/api/admin/vouchers", api.Repo.CreateVoucherBatch)
+		r.Get("/api/admin/vouchers", api.Repo.GetVoucherBatches)
+
+		r.With(middleware.CheckApplicationJSON).Post("/api/admin/partners", api.Repo.CreatePartner)
+		r.Get("/api/admin/partners", api.Repo.GetPartners)
+		r.Get("/api/admin/partners/{id}", api.Repo.AdminGetPartner)
+		r.With(middleware.CheckApplicationJSON).Put("/api/admin/partners/{id}", api.Repo.UpdatePartner)
+		r.Post("/api/admin/partners/{id}/key", api.Repo.RotatePartnerKey)
+		r.Get("/api/admin/partners/{id}/report", api.Repo.AdminGetPartnerReport)
+
+		r.Get("/api/admin/lockouts", api.Repo.GetLoginLockouts)
+		r.Delete("/api/admin/lockouts/{scope}/{value}", api.Repo.UnlockLogin)
+		r.Get("/api/admin/audit", api.Repo.GetAuditEvents)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.CheckMerchant)
+		r.Use(middleware.CheckApplicationJSON)
+
+		r.Post("/api/merchant/withdrawals/{order}/reversal", api.Repo.MerchantReverseWithdrawal)
+	})
+
+	// партнерский API: ключ проверяется в каждом маршруте со своей областью доступа
+	r.Group(func(r chi.Router) {
+		r.With(middleware.CheckPartner(models.PartnerScopeOrdersWrite), middleware.CheckApplicationJSON).
+			Post("/api/partner/orders", api.Repo.PartnerCreateOrder)
+		r.With(middleware.CheckPartner(models.PartnerScopeOrdersRead)).Get("/api/partner/orders/{number}", api.Repo.PartnerGetOrder)
+		r.With(middleware.CheckPartner(models.PartnerScopeWithdrawalsRead)).Get("/api/partner/withdrawals/{order}", api.Repo.PartnerGetWithdrawal)
+		r.With(middleware.CheckPartner(models.PartnerScopeReportsRead)).Get("/api/partner/report", api.Repo.PartnerGetReport)
 	})
 
 	return r
 }
+
+// AdminRoutes - служебные маршруты для отдельного адреса app.AdminAddress,
+// недоступного снаружи. Доступны до Setup: пока хранилище открывается, /readyz отвечает `503`
+func AdminRoutes() http.Handler {
+	r := chi.NewRouter()
+
+	r.Handle("/metrics", metrics.Handler())
+	r.Get("/healthz", Healthz)
+	r.Get("/readyz", Readyz)
+	r.Handle("/log/level", logger.LevelHandler())
+
+	return r
+}
@@ -0,0 +1,38 @@
+package gophermart
+
+import (
+	"context"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/api"
+	"github.com/webkimru/go-shop-loyalty/internal/gophermart/logger"
+	"sync"
+	"time"
+)
+
+// RecalcTiers периодически пересчитывает уровни лояльности по скользящему окну
+func RecalcTiers(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if len(app.LoyaltyTiers) == 0 {
+		return
+	}
+
+	logger.Log.Infoln("Starting loyalty tiers recalculation")
+	ticker := time.NewTicker(app.LoyaltyTierRecalcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := api.Repo.RecalcTiers(ctx)
+			if err != nil {
+				logger.Log.Errorln("failed RecalcTiers()=", err)
+				continue
+			}
+			if changed > 0 {
+				logger.Log.Infoln("Loyalty tiers changed:", changed)
+			}
+		}
+	}
+}
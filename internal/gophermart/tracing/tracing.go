@@ -0,0 +1,108 @@
+// Package tracing - распределенная трассировка OpenTelemetry: запросы к API,
+// методы хранилища и опрос системы расчета accrual
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "gophermart"
+
+// экспортеры спанов
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp" // OTLP/HTTP, например локальный OpenTelemetry Collector
+)
+
+// Tracer - трассировщик приложения. До Initialize и с экспортером none спаны не записываются
+func Tracer() trace.Tracer {
+	return otel.Tracer(serviceName)
+}
+
+// Initialize настраивает экспорт спанов и W3C Trace Context для входящих и исходящих
+// запросов. endpoint - адрес OTLP/HTTP коллектора вида http://localhost:4318 (пустой -
+// из переменных окружения OTEL_EXPORTER_OTLP_*), ratio - доля трассируемых запросов без
+// родительского контекста. Возвращает функцию, отправляющую оставшиеся спаны при остановке
+func Initialize(ctx context.Context, exporter, endpoint string, ratio float64) (func(context.Context) error, error) {
+	// контекст передается дальше даже без экспорта: его может записывать вызывающая система
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New()
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		spanExporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %s", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+		// решение о записи принимает начало трассы, чтобы она не рвалась посередине
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Carrier сохраняет контекст трассировки ctx в W3C-заголовках, чтобы продолжить
+// трассу после очереди
+func Carrier(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+
+	return carrier
+}
+
+// Link связывает спан с трассой, сохраненной Carrier
+func Link(carrier map[string]string) trace.Link {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(carrier))
+
+	return trace.LinkFromContext(ctx, attribute.String("link.kind", "enqueue"))
+}
+
+// End завершает спан, отмечая ошибку err
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}